@@ -0,0 +1,96 @@
+package js
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// ackPrefix starts the stdout line a flow prints with ctx.ack(value)
+const ackPrefix = "__ACK__ "
+
+// runWithAck runs a flow that may acknowledge early with ctx.ack(value). The
+// acknowledged value is returned as soon as it's printed, while the process
+// keeps running in the background; its final metrics and checks are still
+// recorded once it exits. If the flow finishes without acknowledging, its
+// result is returned as usual.
+func (j *ExternalJS) runWithAck(flowPath string, opts *RunOptions) (map[string]interface{}, error) {
+	acked := make(chan map[string]interface{}, 1)
+	var once sync.Once
+	opts.onAck = func(line string) {
+		once.Do(func() {
+			var value map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &value); err != nil {
+				j.logger().Warnf("ignoring invalid acknowledgment from %s: %v", opts.Entry, err)
+				return
+			}
+			acked <- value
+		})
+	}
+
+	var (
+		result map[string]interface{}
+		err    error
+	)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		result, err = j.run(flowPath, opts)
+	}()
+
+	select {
+	case <-done:
+		return result, err
+	case value := <-acked:
+		go func() {
+			<-done
+			if err != nil {
+				j.logger().Warnf("%s failed after acknowledging: %v", opts.Entry, err)
+			}
+		}()
+		return value, nil
+	}
+}
+
+// ackWriter passes output through to w and calls onAck with the payload of
+// the first __ACK__ line. Only lines that may be an acknowledgment are
+// buffered, so large results aren't held twice.
+type ackWriter struct {
+	w     io.Writer
+	onAck func(string)
+
+	line  []byte
+	skip  bool
+	acked bool
+}
+
+func (a *ackWriter) Write(p []byte) (int, error) {
+	n, err := a.w.Write(p)
+
+	for _, b := range p[:n] {
+		if a.acked {
+			break
+		}
+		if b == '\n' {
+			if !a.skip && bytes.HasPrefix(a.line, []byte(ackPrefix)) {
+				a.acked = true
+				a.onAck(string(bytes.TrimSuffix(a.line[len(ackPrefix):], []byte("\r"))))
+			}
+			a.line = a.line[:0]
+			a.skip = false
+			continue
+		}
+		if a.skip {
+			continue
+		}
+		a.line = append(a.line, b)
+		// Stop buffering lines that can't be an acknowledgment
+		if len(a.line) <= len(ackPrefix) && !bytes.HasPrefix([]byte(ackPrefix), a.line) {
+			a.skip = true
+			a.line = a.line[:0]
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,61 @@
+package js
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// affinityWarnOnce limits the warning about cpuAffinity on other platforms
+// to one per test
+var affinityWarnOnce sync.Once
+
+// affinityWrapper returns the command prefix that pins the runtime process to
+// cpus with taskset. It returns nil on platforms other than Linux, where
+// cpuAffinity is ignored, and warn is called once.
+func affinityWrapper(cpus []int, warn func(string, ...interface{})) ([]string, error) {
+	if runtime.GOOS != "linux" {
+		affinityWarnOnce.Do(func() {
+			warn("cpuAffinity is only supported on Linux, ignoring it on %s", runtime.GOOS)
+		})
+		return nil, nil
+	}
+
+	path, err := exec.LookPath("taskset")
+	if err != nil {
+		return nil, fmt.Errorf("cpuAffinity requires taskset (from util-linux): %w", err)
+	}
+
+	list := make([]string, len(cpus))
+	for i, cpu := range cpus {
+		list[i] = strconv.Itoa(cpu)
+	}
+	return []string{path, "--cpu-list", strings.Join(list, ",")}, nil
+}
+
+// parseCPUAffinity reads the cpuAffinity option, an array of CPU numbers
+func parseCPUAffinity(value []interface{}) ([]int, error) {
+	cpus := make([]int, 0, len(value))
+	for _, item := range value {
+		var cpu int
+		switch v := item.(type) {
+		case int64:
+			cpu = int(v)
+		case float64:
+			if v != float64(int(v)) {
+				return nil, fmt.Errorf("cpuAffinity must contain whole CPU numbers, got %v", v)
+			}
+			cpu = int(v)
+		default:
+			return nil, fmt.Errorf("cpuAffinity must be an array of CPU numbers, got %T element", item)
+		}
+		if cpu < 0 {
+			return nil, fmt.Errorf("cpuAffinity must not contain negative CPU numbers, got %d", cpu)
+		}
+		cpus = append(cpus, cpu)
+	}
+	return cpus, nil
+}
@@ -0,0 +1,305 @@
+package js
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// archiveInstallTimeout bounds how long installing an archive's dependencies
+// may take
+const archiveInstallTimeout = 5 * time.Minute
+
+// archiveExtensions are the entry suffixes of packaged flows
+var archiveExtensions = []string{".tgz", ".tar.gz", ".tar", ".zip"}
+
+// archiveFlows caches flows packaged with their dependencies as an archive.
+// Each archive is extracted once per test to a temp directory shared by all
+// VUs, and its dependencies are installed the first time a call asks for it.
+type archiveFlows struct {
+	mu    sync.Mutex
+	dir   string
+	flows map[string]*archiveFlow
+}
+
+type archiveFlow struct {
+	mu        sync.Mutex
+	root      string
+	entry     string
+	installed bool
+}
+
+// isArchiveEntry reports whether entry is an archive to run the flow from
+func isArchiveEntry(entry string) bool {
+	lower := strings.ToLower(entry)
+	for _, ext := range archiveExtensions {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// get returns the path of the entry of the flow packaged in archivePath,
+// extracting it on first use. With install, the dependencies in its lockfile
+// are installed first. Calls for the same archive wait for the one preparing
+// it, and failures aren't cached.
+func (a *archiveFlows) get(ctx context.Context, archivePath string, install bool) (string, error) {
+	key, err := filepath.Abs(archivePath)
+	if err != nil {
+		return "", fmt.Errorf("invalid archive path %q: %w", archivePath, err)
+	}
+
+	a.mu.Lock()
+	if a.flows == nil {
+		a.flows = make(map[string]*archiveFlow)
+	}
+	if a.dir == "" {
+		dir, err := os.MkdirTemp("", "xk6-external-js-archive-*")
+		if err != nil {
+			a.mu.Unlock()
+			return "", fmt.Errorf("failed to create archive directory: %w", err)
+		}
+		a.dir = dir
+	}
+	flow, ok := a.flows[key]
+	if !ok {
+		flow = &archiveFlow{}
+		a.flows[key] = flow
+	}
+	dir := a.dir
+	a.mu.Unlock()
+
+	flow.mu.Lock()
+	defer flow.mu.Unlock()
+
+	if flow.entry == "" {
+		flowDir, err := os.MkdirTemp(dir, "flow-*")
+		if err != nil {
+			return "", fmt.Errorf("failed to create directory for %s: %w", archivePath, err)
+		}
+		if err := extractArchive(archivePath, flowDir); err != nil {
+			os.RemoveAll(flowDir)
+			return "", fmt.Errorf("failed to extract %s: %w", archivePath, err)
+		}
+		root, entry, err := archiveEntry(flowDir)
+		if err != nil {
+			os.RemoveAll(flowDir)
+			return "", fmt.Errorf("%s: %w", archivePath, err)
+		}
+		flow.root, flow.entry = root, entry
+	}
+
+	if install && !flow.installed {
+		if err := installArchiveDeps(ctx, flow.root); err != nil {
+			return "", fmt.Errorf("failed to install the dependencies of %s: %w", archivePath, err)
+		}
+		flow.installed = true
+	}
+	return flow.entry, nil
+}
+
+// cleanup removes the extracted flows
+func (a *archiveFlows) cleanup() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.dir != "" {
+		_ = os.RemoveAll(a.dir)
+		a.dir = ""
+		a.flows = nil
+	}
+}
+
+// extractArchive extracts the tar, gzipped tar or zip archive at path to dir.
+// Entries that would land outside of dir and links are rejected.
+func extractArchive(path, dir string) error {
+	if strings.HasSuffix(strings.ToLower(path), ".zip") {
+		return extractZip(path, dir)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if !strings.HasSuffix(strings.ToLower(path), ".tar") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target, err := archiveTarget(dir, header.Name)
+		if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeArchiveFile(target, tr, os.FileMode(header.Mode)); err != nil {
+				return err
+			}
+		case tar.TypeSymlink, tar.TypeLink:
+			return fmt.Errorf("%s is a link, which isn't supported in flow archives", header.Name)
+		default:
+			// Metadata like PAX headers, and special files flows don't need
+		}
+	}
+}
+
+func extractZip(path, dir string) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, file := range zr.File {
+		target, err := archiveTarget(dir, file.Name)
+		if err != nil {
+			return err
+		}
+		mode := file.Mode()
+		switch {
+		case mode.IsDir():
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case mode&os.ModeSymlink != 0:
+			return fmt.Errorf("%s is a link, which isn't supported in flow archives", file.Name)
+		default:
+			rc, err := file.Open()
+			if err != nil {
+				return err
+			}
+			err = writeArchiveFile(target, rc, mode)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// archiveTarget is where the archive entry name is extracted to in dir
+func archiveTarget(dir, name string) (string, error) {
+	local := filepath.FromSlash(name)
+	if !filepath.IsLocal(local) {
+		return "", fmt.Errorf("%s would be extracted outside of the archive's directory", name)
+	}
+	return filepath.Join(dir, local), nil
+}
+
+// writeArchiveFile writes r to target, keeping the executable bits of mode
+func writeArchiveFile(target string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644|mode.Perm()&0o111)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// archiveEntry finds the package.json of the flow extracted to dir, at its
+// top level or in its only directory, like the package/ of npm pack, and
+// returns the package's directory and the path of its main file
+func archiveEntry(dir string) (string, string, error) {
+	root := dir
+	if _, err := os.Stat(filepath.Join(dir, "package.json")); err != nil {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return "", "", err
+		}
+		if len(entries) != 1 || !entries[0].IsDir() {
+			return "", "", fmt.Errorf("no package.json at the top of the archive")
+		}
+		root = filepath.Join(dir, entries[0].Name())
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, "package.json"))
+	if err != nil {
+		return "", "", fmt.Errorf("no package.json at the top of the archive")
+	}
+	var pkg struct {
+		Main string `json:"main"`
+	}
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return "", "", fmt.Errorf("invalid package.json: %w", err)
+	}
+	main := pkg.Main
+	if main == "" {
+		main = "index.js"
+	}
+	local := filepath.FromSlash(main)
+	if !filepath.IsLocal(local) {
+		return "", "", fmt.Errorf("the main of package.json, %s, is outside of the package", main)
+	}
+	entry := filepath.Join(root, local)
+	if _, err := os.Stat(entry); err != nil {
+		return "", "", fmt.Errorf("the main of package.json, %s, is missing", main)
+	}
+	return root, entry, nil
+}
+
+// installArchiveDeps installs the dependencies of the package in root with
+// npm ci or bun install, whichever its lockfile is for. Packages without a
+// lockfile are left as they are.
+func installArchiveDeps(ctx context.Context, root string) error {
+	var args []string
+	switch {
+	case fileExists(filepath.Join(root, "package-lock.json")), fileExists(filepath.Join(root, "npm-shrinkwrap.json")):
+		args = []string{"npm", "ci", "--no-audit", "--no-fund"}
+	case fileExists(filepath.Join(root, "bun.lock")), fileExists(filepath.Join(root, "bun.lockb")):
+		args = []string{"bun", "install", "--frozen-lockfile"}
+	default:
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, archiveInstallTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = root
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %w\nOutput: %s", strings.Join(args, " "), err, output)
+	}
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,184 @@
+package js
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// archiveFile is an entry of a test archive. Entries with a link are links.
+type archiveFile struct {
+	name, body, link string
+}
+
+// writeTestArchive writes files to an archive in a temp dir, in the format
+// its name's extension says
+func writeTestArchive(t *testing.T, name string, files []archiveFile) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if strings.HasSuffix(name, ".zip") {
+		zw := zip.NewWriter(f)
+		for _, file := range files {
+			header := &zip.FileHeader{Name: file.name}
+			body := file.body
+			if file.link != "" {
+				header.SetMode(os.ModeSymlink | 0o777)
+				body = file.link
+			}
+			w, err := zw.CreateHeader(header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.WriteString(w, body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	var w io.Writer = f
+	if !strings.HasSuffix(name, ".tar") {
+		gz := gzip.NewWriter(f)
+		defer gz.Close()
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+	for _, file := range files {
+		header := &tar.Header{Name: file.name, Mode: 0o644, Size: int64(len(file.body)), Typeflag: tar.TypeReg}
+		if file.link != "" {
+			header.Typeflag, header.Linkname, header.Size = tar.TypeSymlink, file.link, 0
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if file.link == "" {
+			if _, err := io.WriteString(tw, file.body); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return path
+}
+
+func TestArchiveFlows(t *testing.T) {
+	files := []archiveFile{
+		{name: "package/package.json", body: `{"name": "flow", "main": "lib/flow.js"}`},
+		{name: "package/lib/flow.js", body: "module.exports = async () => ({ ok: true });"},
+	}
+	for _, name := range []string{"flow.tgz", "flow.tar.gz", "flow.tar", "flow.zip"} {
+		t.Run(name, func(t *testing.T) {
+			archive := writeTestArchive(t, name, files)
+			flows := &archiveFlows{}
+			defer flows.cleanup()
+
+			entry, err := flows.get(context.Background(), archive, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasSuffix(entry, filepath.Join("package", "lib", "flow.js")) {
+				t.Errorf("entry is %s", entry)
+			}
+			content, err := os.ReadFile(entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != files[1].body {
+				t.Errorf("entry has %q", content)
+			}
+
+			again, err := flows.get(context.Background(), archive, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again != entry {
+				t.Errorf("second call extracted again to %s", again)
+			}
+		})
+	}
+}
+
+func TestExtractArchiveRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    archiveFile
+		wantErr string
+	}{
+		{name: "parent path", file: archiveFile{name: "../evil.js", body: "x"}, wantErr: "outside of the archive's directory"},
+		{name: "nested parent path", file: archiveFile{name: "package/../../evil.js", body: "x"}, wantErr: "outside of the archive's directory"},
+		{name: "absolute path", file: archiveFile{name: "/tmp/evil.js", body: "x"}, wantErr: "outside of the archive's directory"},
+		{name: "symlink", file: archiveFile{name: "package/index.js", link: "/etc/passwd"}, wantErr: "is a link"},
+	}
+	for _, format := range []string{"flow.tgz", "flow.zip"} {
+		for _, tt := range tests {
+			t.Run(format+"/"+tt.name, func(t *testing.T) {
+				archive := writeTestArchive(t, format, []archiveFile{tt.file})
+				dir := t.TempDir()
+
+				err := extractArchive(archive, dir)
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				if _, err := os.Lstat(filepath.Join(filepath.Dir(dir), "evil.js")); err == nil {
+					t.Error("evil.js was written outside of the directory")
+				}
+			})
+		}
+	}
+}
+
+func TestArchiveInstall(t *testing.T) {
+	if _, err := exec.LookPath("npm"); err != nil {
+		t.Skip("npm is not installed")
+	}
+	// A lockfile with a local dependency, so npm ci doesn't need the network
+	archive := writeTestArchive(t, "flow.tgz", []archiveFile{
+		{name: "package/package.json", body: `{"name": "flow", "version": "1.0.0", "dependencies": {"dep": "file:./dep"}}`},
+		{name: "package/package-lock.json", body: `{
+  "name": "flow", "version": "1.0.0", "lockfileVersion": 3, "requires": true,
+  "packages": {
+    "": {"name": "flow", "version": "1.0.0", "dependencies": {"dep": "file:./dep"}},
+    "dep": {"version": "1.0.0"},
+    "node_modules/dep": {"resolved": "dep", "link": true}
+  }
+}`},
+		{name: "package/dep/package.json", body: `{"name": "dep", "version": "1.0.0"}`},
+		{name: "package/dep/index.js", body: "module.exports = 1;"},
+		{name: "package/index.js", body: `const dep = require("dep"); module.exports = async () => ({ dep });`},
+	})
+	flows := &archiveFlows{}
+	defer flows.cleanup()
+
+	entry, err := flows.get(context.Background(), archive, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modules := filepath.Join(filepath.Dir(entry), "node_modules", "dep")
+	if _, err := os.Stat(modules); err == nil {
+		t.Fatal("dependencies were installed without install")
+	}
+
+	if _, err := flows.get(context.Background(), archive, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(modules, "index.js")); err != nil {
+		t.Errorf("dependencies weren't installed: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package js
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// attachmentsKey lists files a flow produced, like screenshots or reports,
+// instead of inlining them in the result
+const attachmentsKey = "__attachments__"
+
+// collectAttachments checks the { name, path, contentType } entries of a
+// __attachments__ array and, with an artifacts dir, moves each file there as
+// <flow>-<vu>-<iteration>-<name>, like log files. The entries are updated in
+// place with the absolute path of the file and its size.
+func (j *ExternalJS) collectAttachments(opts *RunOptions, raw interface{}) error {
+	entries, ok := raw.([]interface{})
+	if !ok {
+		return fmt.Errorf("%s must be an array, got %T", attachmentsKey, raw)
+	}
+
+	prefix := j.artifactPrefix(opts)
+
+	if opts.ArtifactsDir != "" {
+		if err := os.MkdirAll(opts.ArtifactsDir, 0o755); err != nil {
+			return fmt.Errorf("failed to create artifacts directory %q: %w", opts.ArtifactsDir, err)
+		}
+	}
+
+	for i, rawEntry := range entries {
+		entry, ok := rawEntry.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("attachment %d must be an object, got %T", i, rawEntry)
+		}
+		name, _ := entry["name"].(string)
+		if name == "" {
+			return fmt.Errorf("attachment %d must have a name", i)
+		}
+		path, _ := entry["path"].(string)
+		if path == "" {
+			return fmt.Errorf("attachment %s must have a path", name)
+		}
+		if contentType, ok := entry["contentType"]; ok && contentType != nil {
+			if _, ok := contentType.(string); !ok {
+				return fmt.Errorf("contentType of attachment %s must be a string, got %T", name, contentType)
+			}
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("attachment %s: %w", name, err)
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("attachment %s: %s is not a file", name, path)
+		}
+
+		if opts.ArtifactsDir != "" {
+			base := strings.Trim(logFileNameRegex.ReplaceAllString(name, "_"), "._")
+			ext := filepath.Ext(base)
+			stem := prefix + "-" + strings.TrimSuffix(base, ext)
+			if path, err = moveAttachment(path, opts.ArtifactsDir, stem, ext); err != nil {
+				return fmt.Errorf("attachment %s: %w", name, err)
+			}
+		}
+		if path, err = filepath.Abs(path); err != nil {
+			return fmt.Errorf("attachment %s: %w", name, err)
+		}
+
+		entry["path"] = path
+		entry["size"] = info.Size()
+	}
+	return nil
+}
+
+// artifactPrefix names the files a call leaves behind, as
+// <flow>-<vu>-<iteration>
+func (j *ExternalJS) artifactPrefix(opts *RunOptions) string {
+	var vuID, iteration int64
+	if state := j.vu.State(); state != nil {
+		vuID = int64(state.VUID)
+		iteration = state.Iteration
+	}
+	return fmt.Sprintf("%s-%d-%d", strings.Trim(logFileNameRegex.ReplaceAllString(opts.Entry, "_"), "._"), vuID, iteration)
+}
+
+// moveAttachment moves the file at src into dir as stem+ext, or stem-1+ext,
+// stem-2+ext and so on if that name is taken, and returns its new path
+func moveAttachment(src, dir, stem, ext string) (string, error) {
+	dst := filepath.Join(dir, stem+ext)
+	for n := 1; ; n++ {
+		// Reserve the name, so concurrent calls don't pick the same one
+		f, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			f.Close()
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return "", fmt.Errorf("failed to create %q: %w", dst, err)
+		}
+		dst = filepath.Join(dir, fmt.Sprintf("%s-%d%s", stem, n, ext))
+	}
+
+	err := os.Rename(src, dst)
+	if errors.Is(err, syscall.EXDEV) {
+		// The flow's temp dir is on another filesystem
+		err = copyAttachment(src, dst)
+	}
+	if err != nil {
+		os.Remove(dst)
+		return "", fmt.Errorf("failed to move %q to %q: %w", src, dst, err)
+	}
+	return dst, nil
+}
+
+// copyAttachment copies src over dst and removes src
+func copyAttachment(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
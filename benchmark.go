@@ -0,0 +1,126 @@
+package js
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// defaultBenchmarkIterations is how many measured runs Benchmark makes
+	defaultBenchmarkIterations = 10
+	// defaultBenchmarkWarmup is how many unmeasured runs come first, which
+	// also start the worker
+	defaultBenchmarkWarmup = 1
+)
+
+// Benchmark runs a flow repeatedly in one call and returns timing stats, for
+// comparing flow implementations outside a full scenario:
+//
+//	const stats = ext.benchmark("./lib.js", { payload: {} }, { iterations: 100, warmup: 5 });
+//	console.log(stats.p95, stats.mean);
+//
+// The flow arguments are the same as for Run. Runs use the VU's persistent
+// worker unless persistPerVU is set to false or the options need one-shot
+// runs, so the numbers reflect steady state rather than process startup. All durations are in milliseconds. The
+// first failing run stops the benchmark and its error is returned.
+func (j *ExternalJS) Benchmark(flowPath string, payloadOrOptions interface{}, benchOptions map[string]interface{}) (map[string]interface{}, error) {
+	iterations, err := benchmarkCount(benchOptions, "iterations", defaultBenchmarkIterations)
+	if err != nil {
+		return nil, err
+	}
+	if iterations == 0 {
+		return nil, fmt.Errorf("benchmark iterations must be positive")
+	}
+	warmup, err := benchmarkCount(benchOptions, "warmup", defaultBenchmarkWarmup)
+	if err != nil {
+		return nil, err
+	}
+
+	explicitPersist := false
+	if rawMap, ok := payloadOrOptions.(map[string]interface{}); ok {
+		_, explicitPersist = rawMap["persistPerVU"]
+	}
+
+	durations := make([]float64, 0, iterations)
+	for i := 0; i < warmup+iterations; i++ {
+		// Parsed for every run, since running a flow fills in its options
+		opts, err := parseRunOptionsFromArgs(flowPath, payloadOrOptions)
+		if err != nil {
+			return nil, err
+		}
+		if !explicitPersist && supportsWorkers(opts) {
+			opts.PersistPerVU = true
+		}
+
+		start := time.Now()
+		if _, err := j.execute(flowPath, opts); err != nil {
+			return nil, fmt.Errorf("benchmark run %d of %s failed: %w", i+1, flowPath, err)
+		}
+		if i >= warmup {
+			durations = append(durations, float64(time.Since(start).Microseconds())/1000)
+		}
+	}
+
+	sorted := append([]float64(nil), durations...)
+	sort.Float64s(sorted)
+	var total float64
+	for _, d := range sorted {
+		total += d
+	}
+
+	runs := make([]interface{}, len(durations))
+	for i, d := range durations {
+		runs[i] = d
+	}
+
+	return map[string]interface{}{
+		"iterations": iterations,
+		"min":        sorted[0],
+		"max":        sorted[len(sorted)-1],
+		"mean":       total / float64(len(sorted)),
+		"p50":        percentile(sorted, 50),
+		"p95":        percentile(sorted, 95),
+		"p99":        percentile(sorted, 99),
+		"durations":  runs,
+	}, nil
+}
+
+// supportsWorkers reports whether opts can run in a persistent worker
+func supportsWorkers(opts *RunOptions) bool {
+	runtime := opts.Runtime
+	if runtime == "" {
+		runtime = detectRuntimeFromFilename(opts.Entry)
+	}
+	return runtime != "workerd" && opts.Transport != "socket" && opts.Format != "cbor" &&
+		opts.Stdin == nil && opts.Debug == "" && !opts.BunCompile
+}
+
+// benchmarkCount reads a non-negative count from the benchmark options
+func benchmarkCount(options map[string]interface{}, key string, fallback int) (int, error) {
+	var count int
+	switch v := options[key].(type) {
+	case nil:
+		return fallback, nil
+	case int64:
+		count = int(v)
+	case float64:
+		count = int(v)
+	default:
+		return 0, fmt.Errorf("benchmark %s must be a number, got %T", key, v)
+	}
+	if count < 0 {
+		return 0, fmt.Errorf("benchmark %s must not be negative, got %d", key, count)
+	}
+	return count, nil
+}
+
+// percentile returns the nearest-rank percentile p of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
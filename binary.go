@@ -0,0 +1,141 @@
+package js
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// binaryResultMagic starts the result frame of protocol: "binary". Its NUL
+// bytes keep it apart from what flows print, and JSON results can't contain
+// them.
+var binaryResultMagic = []byte("\x00__K6_RESULT__\x00")
+
+// binaryTransport reads the result from a frame on stdout instead of between
+// text markers: binaryResultMagic, a 4-byte big-endian length and exactly
+// that many bytes of JSON, or raw CBOR with the cbor format. The result is
+// never scanned, so nothing in it can be mistaken for the end of it.
+type binaryTransport struct {
+	cbor     bool
+	maxBytes int64
+	frame    *binaryFrameWriter
+}
+
+func (t *binaryTransport) send(payload interface{}, execContext map[string]interface{}) ([]byte, error) {
+	execContext["protocol"] = "binary"
+	return (&stdoutTransport{cbor: t.cbor}).send(payload, execContext)
+}
+
+// writer returns w with the result frame taken out of it, so it doesn't go
+// through the stdout writers that look for text lines. Results larger than
+// maxBytes call onExceed.
+func (t *binaryTransport) writer(w io.Writer, onExceed func()) io.Writer {
+	t.frame = &binaryFrameWriter{w: w, maxBytes: t.maxBytes, onExceed: onExceed}
+	return t.frame
+}
+
+func (t *binaryTransport) receive(string) (map[string]interface{}, error) {
+	f := t.frame
+	if f == nil || len(f.header) < 4 {
+		return nil, fmt.Errorf("result frame not found in output")
+	}
+	if !f.done {
+		return nil, fmt.Errorf("result frame cut short after %d of %d bytes", len(f.body), f.size)
+	}
+
+	var result map[string]interface{}
+	if t.cbor {
+		if err := cborDecMode.Unmarshal(f.body, &result); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal cbor result: %w", err)
+		}
+		return normalizeCBORValue(result).(map[string]interface{}), nil
+	}
+	result, err := unmarshalResult(f.body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal result: %w", err)
+	}
+	return result, nil
+}
+
+func (t *binaryTransport) close() {}
+
+// binaryFrameWriter passes output through to w until binaryResultMagic, and
+// keeps the frame that follows it. Output after the frame is passed through
+// again.
+type binaryFrameWriter struct {
+	w        io.Writer
+	maxBytes int64
+	onExceed func()
+
+	// matched is how much of binaryResultMagic the output ends with
+	matched int
+	header  []byte
+	size    int64
+	body    []byte
+	done    bool
+	// exceeded is set once the frame is larger than maxBytes
+	exceeded bool
+}
+
+func (f *binaryFrameWriter) Write(p []byte) (int, error) {
+	if f.exceeded {
+		return 0, errOutputLimit
+	}
+	n := len(p)
+	for len(p) > 0 {
+		switch {
+		case f.done:
+			_, err := f.w.Write(p)
+			return n, err
+
+		case len(f.header) == 4:
+			take := min(int64(len(p)), f.size-int64(len(f.body)))
+			f.body = append(f.body, p[:take]...)
+			p = p[take:]
+			f.done = int64(len(f.body)) == f.size
+
+		case f.matched == len(binaryResultMagic):
+			take := min(len(p), 4-len(f.header))
+			f.header = append(f.header, p[:take]...)
+			p = p[take:]
+			if len(f.header) < 4 {
+				continue
+			}
+			f.size = int64(binary.BigEndian.Uint32(f.header))
+			if f.size > f.maxBytes {
+				f.exceeded = true
+				f.onExceed()
+				return n, errOutputLimit
+			}
+			f.body = make([]byte, 0, f.size)
+			f.done = f.size == 0
+
+		case f.matched > 0:
+			if p[0] == binaryResultMagic[f.matched] {
+				f.matched++
+				p = p[1:]
+				continue
+			}
+			// Not the magic after all. Only its first byte is a NUL
+			// before the last one, so p[0] can only start it over.
+			if _, err := f.w.Write(binaryResultMagic[:f.matched]); err != nil {
+				return n, err
+			}
+			f.matched = 0
+
+		default:
+			i := bytes.IndexByte(p, binaryResultMagic[0])
+			if i < 0 {
+				_, err := f.w.Write(p)
+				return n, err
+			}
+			if _, err := f.w.Write(p[:i]); err != nil {
+				return n, err
+			}
+			f.matched = 1
+			p = p[i+1:]
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,160 @@
+package js
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// binaryFrame returns body framed the way protocol: "binary" writes it
+func binaryFrame(body string) string {
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(len(body)))
+	return string(binaryResultMagic) + string(size) + body
+}
+
+func TestBinaryFrameWriter(t *testing.T) {
+	frame := binaryFrame(`{"ok":true}`)
+	tests := []struct {
+		name       string
+		chunks     []string
+		wantOutput string
+		wantBody   string
+	}{
+		{
+			name:       "whole frame",
+			chunks:     []string{"before\n" + frame + "after\n"},
+			wantOutput: "before\nafter\n",
+			wantBody:   `{"ok":true}`,
+		},
+		{
+			name:       "magic split across writes",
+			chunks:     []string{"before\n\x00__K6_", "RESULT", "__", "\x00" + frame[len(binaryResultMagic):] + "after\n"},
+			wantOutput: "before\nafter\n",
+			wantBody:   `{"ok":true}`,
+		},
+		{
+			name:       "header and body split across writes",
+			chunks:     []string{frame[:len(binaryResultMagic)+2], frame[len(binaryResultMagic)+2 : len(frame)-3], frame[len(frame)-3:]},
+			wantOutput: "",
+			wantBody:   `{"ok":true}`,
+		},
+		{
+			name:       "false match on a NUL byte",
+			chunks:     []string{"a\x00b\x00__K6_RES\x00x\n", frame},
+			wantOutput: "a\x00b\x00__K6_RES\x00x\n",
+			wantBody:   `{"ok":true}`,
+		},
+		{
+			name:       "false match ending in the start of the magic",
+			chunks:     []string{"\x00__K6\x00", frame[1:]},
+			wantOutput: "\x00__K6",
+			wantBody:   `{"ok":true}`,
+		},
+		{
+			name:       "empty body",
+			chunks:     []string{binaryFrame("") + "after\n"},
+			wantOutput: "after\n",
+			wantBody:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			f := &binaryFrameWriter{w: &out, maxBytes: 1024, onExceed: func() { t.Error("unexpected onExceed") }}
+			for _, chunk := range tt.chunks {
+				if n, err := f.Write([]byte(chunk)); err != nil || n != len(chunk) {
+					t.Fatalf("Write returned %d, %v", n, err)
+				}
+			}
+			if out.String() != tt.wantOutput {
+				t.Errorf("output is %q, want %q", out.String(), tt.wantOutput)
+			}
+			if !f.done || string(f.body) != tt.wantBody {
+				t.Errorf("frame is %q (done %v), want %q", f.body, f.done, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestBinaryFrameWriterByteByByte(t *testing.T) {
+	stream := "x\x00y\n" + binaryFrame(`{"n":1}`) + "after\n"
+	var out bytes.Buffer
+	f := &binaryFrameWriter{w: &out, maxBytes: 1024}
+	for i := 0; i < len(stream); i++ {
+		if _, err := f.Write([]byte{stream[i]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out.String() != "x\x00y\nafter\n" {
+		t.Errorf("output is %q", out.String())
+	}
+	if string(f.body) != `{"n":1}` {
+		t.Errorf("body is %q", f.body)
+	}
+}
+
+func TestBinaryFrameWriterOversize(t *testing.T) {
+	var out bytes.Buffer
+	exceeded := 0
+	f := &binaryFrameWriter{w: &out, maxBytes: 8, onExceed: func() { exceeded++ }}
+
+	frame := binaryFrame(`{"too":"large"}`)
+	if _, err := f.Write([]byte("before\n" + frame)); !errors.Is(err, errOutputLimit) {
+		t.Fatalf("expected errOutputLimit, got %v", err)
+	}
+	if exceeded != 1 {
+		t.Errorf("onExceed called %d times, want 1", exceeded)
+	}
+	if n, err := f.Write([]byte("more")); n != 0 || !errors.Is(err, errOutputLimit) {
+		t.Errorf("Write after the limit returned %d, %v", n, err)
+	}
+	if out.String() != "before\n" {
+		t.Errorf("output is %q", out.String())
+	}
+	if len(f.body) != 0 {
+		t.Errorf("body of an oversize frame was kept: %q", f.body)
+	}
+}
+
+func TestBinaryTransportReceive(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		cbor    bool
+		want    map[string]interface{}
+		wantErr string
+	}{
+		{name: "json", output: binaryFrame(`{"ok":true}`), want: map[string]interface{}{"ok": true}},
+		{name: "cbor", output: binaryFrame("\xa1\x62ok\xf5"), cbor: true, want: map[string]interface{}{"ok": true}},
+		{name: "no frame", output: "just logs\n", wantErr: "result frame not found"},
+		{name: "cut short", output: binaryFrame(`{"ok":true}`)[:len(binaryResultMagic)+6], wantErr: "result frame cut short after 2 of 11 bytes"},
+		{name: "invalid json", output: binaryFrame(`{"ok":`), wantErr: "failed to unmarshal result"},
+		{name: "invalid cbor", output: binaryFrame("\xff"), cbor: true, wantErr: "failed to unmarshal cbor result"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &binaryTransport{cbor: tt.cbor, maxBytes: 1024}
+			w := tr.writer(&bytes.Buffer{}, func() {})
+			if _, err := w.Write([]byte(tt.output)); err != nil {
+				t.Fatal(err)
+			}
+			result, err := tr.receive("")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result, tt.want) {
+				t.Errorf("result is %#v, want %#v", result, tt.want)
+			}
+		})
+	}
+}
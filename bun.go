@@ -0,0 +1,117 @@
+package js
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// bunArtifacts caches flows compiled with `bun build --compile`, keyed by a
+// hash of the entry's content and the runner script. Each flow is compiled
+// once per test, and the executable is reused by every later call.
+type bunArtifacts struct {
+	mu      sync.Mutex
+	dir     string
+	entries map[string]*bunArtifact
+}
+
+type bunArtifact struct {
+	once sync.Once
+	path string
+	err  error
+}
+
+// get returns the path of the compiled executable for entry, compiling it on
+// first use. Calls for the same content wait for the one compiling it.
+func (b *bunArtifacts) get(entry string) (string, error) {
+	path := flowPath(entry)
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s for bunCompile: %w", path, err)
+	}
+	sum := sha256.Sum256(append(source, runnerScript...))
+	key := hex.EncodeToString(sum[:])
+
+	b.mu.Lock()
+	if b.entries == nil {
+		b.entries = make(map[string]*bunArtifact)
+	}
+	if b.dir == "" {
+		dir, err := os.MkdirTemp("", "xk6-external-js-bun-*")
+		if err != nil {
+			b.mu.Unlock()
+			return "", fmt.Errorf("failed to create bunCompile cache directory: %w", err)
+		}
+		b.dir = dir
+	}
+	artifact, ok := b.entries[key]
+	if !ok {
+		artifact = &bunArtifact{}
+		b.entries[key] = artifact
+	}
+	dir := b.dir
+	b.mu.Unlock()
+
+	artifact.once.Do(func() {
+		artifact.path, artifact.err = compileBunFlow(dir, key[:16], path)
+	})
+	return artifact.path, artifact.err
+}
+
+// cleanup removes the compiled executables
+func (b *bunArtifacts) cleanup() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.dir != "" {
+		_ = os.RemoveAll(b.dir)
+		b.dir = ""
+		b.entries = nil
+	}
+}
+
+// compileBunFlow bundles the runner and the flow at path into a standalone
+// executable. The flow is imported statically, so bun parses and bundles it
+// with its dependencies at build time instead of on every call, and the
+// runner picks it up from globalThis.__k6_compiled_flow__.
+func compileBunFlow(dir, name, path string) (string, error) {
+	runner := filepath.Join(dir, name+"-runner.js")
+	if err := os.WriteFile(runner, []byte(runnerScript), 0o600); err != nil {
+		return "", fmt.Errorf("failed to write runner for bunCompile: %w", err)
+	}
+
+	wrapper := filepath.Join(dir, name+"-entry.js")
+	source := fmt.Sprintf("import * as flow from %s;\nglobalThis.__k6_compiled_flow__ = flow;\nawait import(%s);\n",
+		strconv.Quote(path), strconv.Quote("./"+filepath.Base(runner)))
+	if err := os.WriteFile(wrapper, []byte(source), 0o600); err != nil {
+		return "", fmt.Errorf("failed to write entry for bunCompile: %w", err)
+	}
+
+	output := filepath.Join(dir, name)
+	cmd := exec.Command("bun", "build", "--compile", wrapper, "--outfile", output)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("bun build --compile failed for %s: %w\n%s", path, err, strings.TrimSpace(string(out)))
+	}
+	return output, nil
+}
+
+// flowPath resolves entry the way the runner does for node and bun: relative
+// to the working directory, with .js added when there's no extension
+func flowPath(entry string) string {
+	path := entry
+	if !filepath.IsAbs(path) {
+		if wd, err := os.Getwd(); err == nil {
+			path = filepath.Join(wd, path)
+		}
+	}
+	if !strings.HasSuffix(path, ".js") && !strings.HasSuffix(path, ".ts") {
+		path += ".js"
+	}
+	return path
+}
@@ -0,0 +1,77 @@
+package js
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// cborDecMode decodes CBOR maps into map[string]interface{} like encoding/json does
+var cborDecMode, _ = cbor.DecOptions{
+	DefaultMapType: reflect.TypeOf(map[string]interface{}{}),
+}.DecMode()
+
+// encodeCBORPayload encodes payload as base64 CBOR, so it can be passed as an argument
+func encodeCBORPayload(payload interface{}) ([]byte, error) {
+	raw, err := cbor.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(raw)))
+	base64.StdEncoding.Encode(encoded, raw)
+	return encoded, nil
+}
+
+// extractCBORResult decodes the base64 CBOR result between the result markers
+func extractCBORResult(output string) (map[string]interface{}, error) {
+	encoded, err := extractMarkedResult(output)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64 result: %w", err)
+	}
+
+	var result map[string]interface{}
+	if err := cborDecMode.Unmarshal(raw, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal cbor result: %w", err)
+	}
+
+	return normalizeCBORValue(result).(map[string]interface{}), nil
+}
+
+// normalizeCBORValue converts CBOR integers, bignums included, to
+// json.Number, matching the types produced by the JSON path so results are
+// handled the same way.
+func normalizeCBORValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, item := range val {
+			val[k] = normalizeCBORValue(item)
+		}
+		return val
+	case []interface{}:
+		for i, item := range val {
+			val[i] = normalizeCBORValue(item)
+		}
+		return val
+	case uint64:
+		return json.Number(strconv.FormatUint(val, 10))
+	case int64:
+		return json.Number(strconv.FormatInt(val, 10))
+	case big.Int:
+		return json.Number(val.String())
+	case *big.Int:
+		return json.Number(val.String())
+	default:
+		return v
+	}
+}
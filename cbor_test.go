@@ -0,0 +1,115 @@
+package js
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// markedCBOR returns raw as base64 between the result markers, with other
+// output around it
+func markedCBOR(raw []byte) string {
+	return "log line\n__RESULT_START__\n" + base64.StdEncoding.EncodeToString(raw) + "\n__RESULT_END__\n"
+}
+
+func TestExtractCBORResult(t *testing.T) {
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	raw, err := cbor.Marshal(map[string]interface{}{
+		"count":    uint64(18446744073709551615),
+		"offset":   int64(-42),
+		"huge":     huge,
+		"ratio":    0.5,
+		"name":     "sensor",
+		"readings": []interface{}{uint64(1), -2.5, map[string]interface{}{"id": uint64(7)}},
+		"ok":       true,
+		"missing":  nil,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := extractCBORResult(markedCBOR(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"count":    json.Number("18446744073709551615"),
+		"offset":   json.Number("-42"),
+		"huge":     json.Number("123456789012345678901234567890"),
+		"ratio":    0.5,
+		"name":     "sensor",
+		"readings": []interface{}{json.Number("1"), -2.5, map[string]interface{}{"id": json.Number("7")}},
+		"ok":       true,
+		"missing":  nil,
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("result is\n%#v\nwant\n%#v", result, want)
+	}
+}
+
+func TestExtractCBORResultErrors(t *testing.T) {
+	array, _ := cbor.Marshal([]interface{}{1, 2})
+	tests := []struct {
+		name    string
+		output  string
+		wantErr string
+	}{
+		{name: "no markers", output: "oops", wantErr: "result"},
+		{name: "invalid base64", output: "__RESULT_START__\n!!!\n__RESULT_END__\n", wantErr: "failed to decode base64 result"},
+		{name: "invalid cbor", output: markedCBOR([]byte{0xff, 0x00}), wantErr: "failed to unmarshal cbor result"},
+		{name: "truncated cbor", output: markedCBOR([]byte{0xa1, 0x61}), wantErr: "failed to unmarshal cbor result"},
+		{name: "not a map", output: markedCBOR(array), wantErr: "failed to unmarshal cbor result"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := extractCBORResult(tt.output)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestEncodeCBORPayload(t *testing.T) {
+	payload := map[string]interface{}{"device": "thermo-1", "temp": -3.5, "tags": []interface{}{"a", "b"}}
+	encoded, err := encodeCBORPayload(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := cborDecMode.Unmarshal(raw, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, payload) {
+		t.Errorf("decoded %#v, want %#v", decoded, payload)
+	}
+}
+
+func TestCBORFormat(t *testing.T) {
+	entry := writeFlow(t, `module.exports = async (ctx) => ({ echo: ctx.payload, big: 2n ** 70n });`)
+	j, _, _ := newTestInstance(t)
+
+	result, err := j.Run(entry, map[string]interface{}{
+		"payload": map[string]interface{}{"device": "thermo-1", "temp": -3.5, "n": 3},
+		"format":  "cbor",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"device": "thermo-1", "temp": -3.5, "n": float64(3)}
+	if !reflect.DeepEqual(result["echo"], want) {
+		t.Errorf("echo is %#v, want %#v", result["echo"], want)
+	}
+	if result["big"] != float64(1<<70) {
+		t.Errorf("big is %#v", result["big"])
+	}
+}
@@ -0,0 +1,61 @@
+package js
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+)
+
+// writeCompressedPayload gzips payload into a temp file, so large payloads
+// aren't bound by command line length limits. The caller must remove it.
+func writeCompressedPayload(payload []byte) (string, error) {
+	f, err := os.CreateTemp("", "xk6-external-js-payload-*.json.gz")
+	if err != nil {
+		return "", fmt.Errorf("failed to create payload file: %w", err)
+	}
+	defer f.Close()
+
+	zw := gzip.NewWriter(f)
+	if _, err := zw.Write(payload); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to compress payload: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to compress payload: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// extractCompressedResult decodes the base64 gzipped JSON result between the result markers
+func extractCompressedResult(output string) (map[string]interface{}, error) {
+	encoded, err := extractMarkedResult(output)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64 result: %w", err)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress result: %w", err)
+	}
+	defer zr.Close()
+
+	resultJSON, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress result: %w", err)
+	}
+
+	result, err := unmarshalResult(resultJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal result: %w", err)
+	}
+	return result, nil
+}
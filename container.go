@@ -0,0 +1,134 @@
+package js
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// containerRemoveTimeout bounds how long removing a container that outlived
+// its call may take
+const containerRemoveTimeout = 10 * time.Second
+
+// ContainerOptions runs the flow's runtime inside a container image
+type ContainerOptions struct {
+	// Image is the image to run, e.g. "node:20-alpine"
+	Image string `json:"image"`
+	// Engine is the container CLI, "docker" or "podman". By default the
+	// first one installed is used.
+	Engine string `json:"engine"`
+	// Args are extra arguments for `run`, e.g. ["--network", "host"]
+	Args []string `json:"args"`
+}
+
+// parseContainerOptions reads the container option, either an image name or
+// an object with image, engine and args
+func parseContainerOptions(raw interface{}) (*ContainerOptions, error) {
+	switch v := raw.(type) {
+	case string:
+		if v == "" {
+			return nil, fmt.Errorf("container image must not be empty")
+		}
+		return &ContainerOptions{Image: v}, nil
+	case map[string]interface{}:
+		opts := &ContainerOptions{}
+		opts.Image, _ = v["image"].(string)
+		if opts.Image == "" {
+			return nil, fmt.Errorf("container.image must be a non-empty string")
+		}
+		if engine, ok := v["engine"].(string); ok && engine != "" {
+			if engine != "docker" && engine != "podman" {
+				return nil, fmt.Errorf("unsupported container.engine %q (supported: docker, podman)", engine)
+			}
+			opts.Engine = engine
+		}
+		if rawArgs, ok := v["args"].([]interface{}); ok {
+			for _, arg := range rawArgs {
+				s, ok := arg.(string)
+				if !ok {
+					return nil, fmt.Errorf("container.args must be an array of strings, got %T element", arg)
+				}
+				opts.Args = append(opts.Args, s)
+			}
+		}
+		return opts, nil
+	default:
+		return nil, fmt.Errorf("container must be an image name or an object, got %T", raw)
+	}
+}
+
+// containerCommand wraps cmd in `docker run` (or podman). The working
+// directory and the temp directory are mounted at the same paths, so entry
+// paths, relative imports and the temp files holding payloads, shared data
+// and inline files resolve as they do on the host. Only the variables named
+// in envKeys are passed in, by name so their values stay off the command
+// line, and cpus are applied with --cpuset-cpus.
+//
+// Killing the engine client doesn't stop the container, so the returned
+// cleanup function removes it if ctx ended before it exited.
+func containerCommand(ctx context.Context, opts *ContainerOptions, cmd *exec.Cmd, envKeys []string, cpus []int) (*exec.Cmd, func(), error) {
+	engine := opts.Engine
+	if engine == "" {
+		for _, candidate := range []string{"docker", "podman"} {
+			if _, err := exec.LookPath(candidate); err == nil {
+				engine = candidate
+				break
+			}
+		}
+		if engine == "" {
+			return nil, nil, fmt.Errorf("the container option requires docker or podman")
+		}
+	}
+	enginePath, err := exec.LookPath(engine)
+	if err != nil {
+		return nil, nil, fmt.Errorf("container engine %s is not installed: %w", engine, err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get the working directory for the container: %w", err)
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, nil, fmt.Errorf("failed to name container: %w", err)
+	}
+	name := "xk6-external-js-" + hex.EncodeToString(suffix)
+
+	args := []string{"run", "--rm", "-i", "--name", name, "-v", wd + ":" + wd, "-w", wd}
+	if tmp := os.TempDir(); tmp != wd && !strings.HasPrefix(tmp, wd+string(filepath.Separator)) {
+		args = append(args, "-v", tmp+":"+tmp)
+	}
+	sort.Strings(envKeys)
+	for _, key := range envKeys {
+		args = append(args, "-e", key)
+	}
+	if len(cpus) > 0 {
+		list := make([]string, len(cpus))
+		for i, cpu := range cpus {
+			list[i] = strconv.Itoa(cpu)
+		}
+		args = append(args, "--cpuset-cpus", strings.Join(list, ","))
+	}
+	args = append(args, opts.Args...)
+	args = append(args, opts.Image)
+
+	wrapped := wrapCommand(ctx, append([]string{enginePath}, args...), cmd)
+	cleanup := func() {
+		if ctx.Err() == nil {
+			return
+		}
+		removeCtx, cancel := context.WithTimeout(context.Background(), containerRemoveTimeout)
+		defer cancel()
+		_ = exec.CommandContext(removeCtx, enginePath, "rm", "-f", name).Run()
+	}
+	return wrapped, cleanup, nil
+}
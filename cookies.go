@@ -0,0 +1,59 @@
+package js
+
+import (
+	"fmt"
+	"net/url"
+
+	"go.k6.io/k6/lib"
+)
+
+// parseCookieJarURLs reads the cookieJar option, a URL or an array of URLs
+// whose cookies are passed to the flow
+func parseCookieJarURLs(raw interface{}) ([]*url.URL, error) {
+	var rawURLs []interface{}
+	switch v := raw.(type) {
+	case string:
+		rawURLs = []interface{}{v}
+	case []interface{}:
+		rawURLs = v
+	default:
+		return nil, fmt.Errorf("cookieJar must be a URL or an array of URLs, got %T", raw)
+	}
+
+	urls := make([]*url.URL, 0, len(rawURLs))
+	for _, rawURL := range rawURLs {
+		s, ok := rawURL.(string)
+		if !ok {
+			return nil, fmt.Errorf("cookieJar must be an array of URLs, got %T element", rawURL)
+		}
+		u, err := url.Parse(s)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid cookieJar URL %q, expected an http(s) URL", s)
+		}
+		urls = append(urls, u)
+	}
+	return urls, nil
+}
+
+// jarCookies returns the cookies the VU's cookie jar would send to each of
+// urls, in the shape the runner expects:
+//
+//	[{ "url": "https://example.com/", "cookies": [{ "name": "sid", "value": "..." }] }]
+//
+// The jar only exposes names and values, so the runner sends a URL's cookies
+// to requests with the same origin under the same path.
+func jarCookies(state *lib.State, urls []*url.URL) ([]interface{}, error) {
+	if state == nil || state.CookieJar == nil {
+		return nil, fmt.Errorf("cookieJar needs the VU's cookie jar, which only exists in VU code and setup()")
+	}
+
+	entries := make([]interface{}, 0, len(urls))
+	for _, u := range urls {
+		cookies := make([]interface{}, 0)
+		for _, cookie := range state.CookieJar.Cookies(u) {
+			cookies = append(cookies, map[string]interface{}{"name": cookie.Name, "value": cookie.Value})
+		}
+		entries = append(entries, map[string]interface{}{"url": u.String(), "cookies": cookies})
+	}
+	return entries, nil
+}
@@ -0,0 +1,26 @@
+package js
+
+import "github.com/google/uuid"
+
+// correlationTag is the tag with the ID of the call a sample comes from
+const correlationTag = "correlation_id"
+
+// newCorrelationID returns a random ID for a call, passed to the flow as
+// ctx.correlationId
+func newCorrelationID() string {
+	return uuid.NewString()
+}
+
+// callTags returns tags with the correlation_id of the call added, when the
+// call has correlationId: true. tags may be nil and is not modified.
+func callTags(opts *RunOptions, tags map[string]string) map[string]string {
+	if !opts.TagCorrelationID {
+		return tags
+	}
+	withID := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		withID[k] = v
+	}
+	withID[correlationTag] = opts.correlationID
+	return withID
+}
@@ -0,0 +1,31 @@
+package js
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// parseEncoding looks up a character encoding by its WHATWG label, e.g.
+// "utf-8", "latin1", "windows-1252" or "shift_jis"
+func parseEncoding(label string) (encoding.Encoding, error) {
+	enc, err := htmlindex.Get(label)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported encoding %q: %w", label, err)
+	}
+	return enc, nil
+}
+
+// decodeOutput turns output a flow wrote to stdout or stderr into text. With
+// no encoding, output is taken as UTF-8 and invalid sequences are replaced
+// with U+FFFD, so they can't corrupt anything the text is embedded in.
+func decodeOutput(output []byte, enc encoding.Encoding) string {
+	if enc != nil {
+		if decoded, err := enc.NewDecoder().Bytes(output); err == nil {
+			return strings.ToValidUTF8(string(decoded), "\uFFFD")
+		}
+	}
+	return strings.ToValidUTF8(string(output), "\uFFFD")
+}
@@ -0,0 +1,56 @@
+package js
+
+import (
+	"testing"
+)
+
+func TestDecodeOutput(t *testing.T) {
+	const resultJSON = `{"name":"café","n":1}`
+
+	tests := []struct {
+		name       string
+		encoding   string
+		stderr     []byte
+		wantStderr string
+	}{
+		{name: "latin1", encoding: "latin1", stderr: []byte("caf\xe9 cr\xe8me"), wantStderr: "café crème"},
+		{name: "windows-1252", encoding: "windows-1252", stderr: []byte("\x93quoted\x94"), wantStderr: "“quoted”"},
+		{name: "invalid utf-8", stderr: []byte("bad \xff\xfe bytes"), wantStderr: "bad � bytes"},
+		{name: "latin1 read as utf-8", stderr: []byte("caf\xe9"), wantStderr: "caf�"},
+		{name: "utf-8", stderr: []byte("café"), wantStderr: "café"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := map[string]interface{}{"payload": nil}
+			if tt.encoding != "" {
+				args["encoding"] = tt.encoding
+			}
+			opts, err := parseRunOptionsFromArgs("flow.js", args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := decodeOutput(tt.stderr, opts.outputEncoding); got != tt.wantStderr {
+				t.Errorf("stderr decoded as %q, want %q", got, tt.wantStderr)
+			}
+
+			// The result is read from the raw stdout, so the encoding of the
+			// flow's other output doesn't touch it
+			stdout := string(tt.stderr) + "\n__RESULT_START__\n" + resultJSON + "\n__RESULT_END__\n" + string(tt.stderr)
+			region, err := extractMarkedResult(stdout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if region != resultJSON {
+				t.Errorf("result region is %q, want %q", region, resultJSON)
+			}
+			result, err := extractResult(stdout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result["name"] != "café" {
+				t.Errorf("result name is %q, want café", result["name"])
+			}
+		})
+	}
+}
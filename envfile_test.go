@@ -0,0 +1,59 @@
+package js
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEnvKeys(t *testing.T) {
+	tests := []struct {
+		key     string
+		wantErr bool
+	}{
+		{key: "API_URL"},
+		{key: "_private"},
+		{key: "v2"},
+		{key: "has space", wantErr: true},
+		{key: "A=B", wantErr: true},
+		{key: "", wantErr: true},
+		{key: "2FAST", wantErr: true},
+		{key: "my.key", wantErr: true},
+		{key: "my-key", wantErr: true},
+		{key: "NUL\x00", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			opts, err := parseRunOptionsFromArgs("flow.js", map[string]interface{}{
+				"env": map[string]interface{}{tt.key: "value"},
+			})
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "invalid env key") {
+					t.Errorf("expected an invalid env key error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opts.Env[tt.key] != "value" {
+				t.Errorf("env is %v", opts.Env)
+			}
+		})
+	}
+}
+
+func TestEnvFileKeys(t *testing.T) {
+	for _, line := range []string{"has space=1", "=1", "my.key=1", "my-key=1"} {
+		t.Run(line, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "flow.env")
+			if err := os.WriteFile(path, []byte("OK=1\n"+line+"\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := loadEnvFile(path); err == nil || !strings.Contains(err.Error(), ":2:") {
+				t.Errorf("expected an error on line 2, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package js
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.k6.io/k6/lib"
+	"go.k6.io/k6/metrics"
+)
+
+// eventKey is how flows emit events with their result, one or an array of
+// { type, data }
+const eventKey = "__k6_event__"
+
+// FlowEvent is a lifecycle or business event a flow emitted, e.g. an order
+// being placed
+type FlowEvent struct {
+	// Type names the event, e.g. "order.placed"
+	Type string
+	// Data is the event's payload as decoded from JSON, nil if it has none
+	Data interface{}
+	// Flow is the entry of the flow that emitted it
+	Flow string
+	// VU and Iteration are of the call that emitted it
+	VU        uint64
+	Iteration int64
+	// CorrelationID is the call's ctx.correlationId
+	CorrelationID string
+	Time          time.Time
+}
+
+// FlowEventHandler reacts to the events flows emit
+type FlowEventHandler func(event FlowEvent)
+
+// flowEvents holds the registered FlowEventHandlers
+type flowEvents struct {
+	mu       sync.RWMutex
+	handlers []FlowEventHandler
+}
+
+// publish passes event to every registered handler
+func (e *flowEvents) publish(event FlowEvent) {
+	e.mu.RLock()
+	handlers := e.handlers
+	e.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// OnFlowEvent registers fn to receive the events flows emit with
+// __k6_event__, across all VUs. It's meant for Go code embedding the
+// extension, like other extensions that forward events to an incident
+// timeline. fn runs concurrently for different VUs, on the VU's goroutine,
+// so it should hand slow work off.
+func (m *ExternalJSModule) OnFlowEvent(fn FlowEventHandler) {
+	m.events.mu.Lock()
+	defer m.events.mu.Unlock()
+	m.events.handlers = append(m.events.handlers, fn)
+}
+
+// OnFlowEvent registers fn on the module registered as k6/x/external_js. See
+// ExternalJSModule.OnFlowEvent.
+func OnFlowEvent(fn FlowEventHandler) {
+	rootModule.OnFlowEvent(fn)
+}
+
+// parseEvents reads a __k6_event__ value. Invalid events are skipped and
+// returned as errors.
+func parseEvents(raw interface{}) ([]FlowEvent, []error) {
+	rawEvents, ok := raw.([]interface{})
+	if !ok {
+		rawEvents = []interface{}{raw}
+	}
+
+	var (
+		events []FlowEvent
+		errs   []error
+	)
+	for _, rawEvent := range rawEvents {
+		event, ok := rawEvent.(map[string]interface{})
+		if !ok {
+			errs = append(errs, fmt.Errorf("events must be objects with a type, got %v", rawEvent))
+			continue
+		}
+		eventType, _ := event["type"].(string)
+		if eventType == "" {
+			errs = append(errs, fmt.Errorf("events need a non-empty type, got %v", event["type"]))
+			continue
+		}
+		events = append(events, FlowEvent{Type: eventType, Data: event["data"]})
+	}
+	return events, errs
+}
+
+// emitEvents records each event as an external_js_events sample, with its
+// type and its data as JSON in the sample's metadata, so outputs get it, and
+// passes it to the OnFlowEvent handlers. The type isn't a tag since flows can
+// name events freely, and each tag value is a new time series.
+func (j *ExternalJS) emitEvents(state *lib.State, opts *RunOptions, events []FlowEvent) {
+	now := time.Now()
+	for _, event := range events {
+		event.Flow, event.CorrelationID, event.Time = opts.Entry, opts.correlationID, now
+		if state != nil {
+			event.VU, event.Iteration = state.VUID, state.Iteration
+
+			metadata := map[string]string{"event": event.Type}
+			if event.Data != nil {
+				if data, err := json.Marshal(event.Data); err == nil {
+					metadata["data"] = string(data)
+				}
+			}
+			j.pushSample(state, metrics.Sample{
+				TimeSeries: metrics.TimeSeries{
+					Metric: j.flowEvents,
+					Tags: state.Tags.GetCurrentValues().Tags.WithTagsFromMap(
+						callTags(opts, map[string]string{"flow": opts.Entry, "runtime": opts.runtimeTag}),
+					),
+				},
+				Time:     now,
+				Value:    1,
+				Metadata: metadata,
+			})
+		}
+		j.module.events.publish(event)
+	}
+}
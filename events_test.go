@@ -0,0 +1,77 @@
+package js
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestOnFlowEvent(t *testing.T) {
+	entry := writeFlow(t, `
+module.exports.handler = async () => ({
+  ok: true,
+  __k6_event__: [
+    { type: "order.placed", data: { orderId: 7 } },
+    { type: "cache.warmed" },
+    { data: { missing: "type" } },
+  ],
+});
+`)
+	j, state, samples := newTestInstance(t)
+	state.Iteration = 3
+
+	var (
+		mu     sync.Mutex
+		events []FlowEvent
+	)
+	j.module.OnFlowEvent(func(event FlowEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	})
+
+	result, err := j.Run(entry, map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := result[eventKey]; ok {
+		t.Errorf("result still has %s: %v", eventKey, result)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 2 {
+		t.Fatalf("handler got %d events, want 2: %+v", len(events), events)
+	}
+	placed := events[0]
+	if placed.Type != "order.placed" || placed.Flow != entry || placed.VU != 1 || placed.Iteration != 3 {
+		t.Errorf("handler got %+v", placed)
+	}
+	if want := map[string]interface{}{"orderId": float64(7)}; !reflect.DeepEqual(placed.Data, want) {
+		t.Errorf("handler got data %#v, want %#v", placed.Data, want)
+	}
+	if events[1].Type != "cache.warmed" || events[1].Data != nil {
+		t.Errorf("handler got %+v", events[1])
+	}
+
+	var metadata []map[string]string
+	for _, sample := range drainSamples(samples) {
+		if sample.Metric.Name != "external_js_events" {
+			continue
+		}
+		if _, ok := sample.Tags.Get("event"); ok {
+			t.Errorf("sample is tagged with the event type: %v", sample.Tags.Map())
+		}
+		if flow, _ := sample.Tags.Get("flow"); flow != entry {
+			t.Errorf("sample has flow tag %q, want %q", flow, entry)
+		}
+		metadata = append(metadata, sample.Metadata)
+	}
+	want := []map[string]string{
+		{"event": "order.placed", "data": `{"orderId":7}`},
+		{"event": "cache.warmed"},
+	}
+	if !reflect.DeepEqual(metadata, want) {
+		t.Errorf("samples have metadata %v, want %v", metadata, want)
+	}
+}
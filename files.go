@@ -0,0 +1,43 @@
+package js
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// materializeFiles writes files, keyed by relative path, into a new temp
+// directory and returns its path. Nested directories are created as needed.
+// The caller must remove the directory once the flow has finished.
+func materializeFiles(files map[string][]byte) (string, error) {
+	dir, err := os.MkdirTemp("", "xk6-external-js-files-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create files directory: %w", err)
+	}
+
+	for name, data := range files {
+		if err := writeFile(dir, name, data); err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// writeFile writes data to name inside dir, refusing paths that escape it
+func writeFile(dir, name string, data []byte) error {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if name == "" || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("invalid file path %q: must be relative and stay inside the files directory", name)
+	}
+
+	path := filepath.Join(dir, clean)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for %q: %w", name, err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write %q: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package js
+
+import (
+	"bytes"
+	"io"
+)
+
+// frameWriter removes lines starting with prefix from the output passed to w,
+// calling onFrame with the rest of each such line. Only lines that may be a
+// frame are buffered.
+type frameWriter struct {
+	w       io.Writer
+	prefix  []byte
+	onFrame func(body []byte)
+
+	line        []byte
+	passthrough bool
+}
+
+func newFrameWriter(w io.Writer, prefix string, onFrame func([]byte)) *frameWriter {
+	return &frameWriter{w: w, prefix: []byte(prefix), onFrame: onFrame}
+}
+
+func (f *frameWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if f.passthrough {
+			i := bytes.IndexByte(p, '\n')
+			if i < 0 {
+				_, err := f.w.Write(p)
+				return n, err
+			}
+			if _, err := f.w.Write(p[:i+1]); err != nil {
+				return n, err
+			}
+			p = p[i+1:]
+			f.passthrough = false
+			continue
+		}
+
+		chunk := p
+		if i := bytes.IndexByte(p, '\n'); i >= 0 {
+			chunk = p[:i+1]
+		}
+		p = p[len(chunk):]
+		f.line = append(f.line, chunk...)
+		complete := f.line[len(f.line)-1] == '\n'
+
+		if !f.mayBeFrame() {
+			if _, err := f.w.Write(f.line); err != nil {
+				return n, err
+			}
+			f.passthrough = !complete
+			f.line = f.line[:0]
+			continue
+		}
+		if complete {
+			f.onFrame(bytes.TrimRight(f.line[len(f.prefix):], "\r\n"))
+			f.line = f.line[:0]
+		}
+	}
+	return n, nil
+}
+
+// mayBeFrame reports whether the buffered line starts like a frame
+func (f *frameWriter) mayBeFrame() bool {
+	if len(f.line) < len(f.prefix) {
+		return bytes.HasPrefix(f.prefix, f.line)
+	}
+	return bytes.HasPrefix(f.line, f.prefix)
+}
@@ -0,0 +1,77 @@
+package js
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// outputGuard caps the total output of a call, stdout and stderr together,
+// so a flow printing in a tight loop can't exhaust memory. Once either limit
+// is exceeded, further writes fail and onExceed is called once to stop the
+// process.
+type outputGuard struct {
+	mu       sync.Mutex
+	maxBytes int64
+	maxLines int64
+	bytes    int64
+	lines    int64
+	exceeded bool
+	onExceed func()
+}
+
+// writer returns a writer that counts against the guard before writing to w
+func (g *outputGuard) writer(w io.Writer) io.Writer {
+	return &guardedWriter{w: w, guard: g}
+}
+
+// add counts p and reports whether it fits within the limits
+func (g *outputGuard) add(p []byte) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.exceeded {
+		return false
+	}
+	g.bytes += int64(len(p))
+	g.lines += int64(bytes.Count(p, []byte("\n")))
+	if (g.maxBytes > 0 && g.bytes > g.maxBytes) || (g.maxLines > 0 && g.lines > g.maxLines) {
+		g.exceeded = true
+		g.onExceed()
+		return false
+	}
+	return true
+}
+
+// tripped reports whether a limit was exceeded
+func (g *outputGuard) tripped() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.exceeded
+}
+
+type guardedWriter struct {
+	w     io.Writer
+	guard *outputGuard
+}
+
+func (g *guardedWriter) Write(p []byte) (int, error) {
+	if !g.guard.add(p) {
+		return 0, errOutputLimit
+	}
+	return g.w.Write(p)
+}
+
+// outputLimitDescription describes the limits set in opts for error messages
+func outputLimitDescription(opts *RunOptions) string {
+	var limits []string
+	if opts.MaxOutputBytes > 0 {
+		limits = append(limits, fmt.Sprintf("%d bytes", opts.MaxOutputBytes))
+	}
+	if opts.MaxOutputLines > 0 {
+		limits = append(limits, fmt.Sprintf("%d lines", opts.MaxOutputLines))
+	}
+	return strings.Join(limits, " or ")
+}
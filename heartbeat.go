@@ -0,0 +1,73 @@
+package js
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// heartbeatPrefix starts the lines the runner prints with the flow's phase
+const heartbeatPrefix = "__HEARTBEAT__ "
+
+// defaultHeartbeatInterval is used when the heartbeat option is true
+const defaultHeartbeatInterval = time.Second
+
+// heartbeatTracker remembers the last phase reported by heartbeat lines
+type heartbeatTracker struct {
+	mu    sync.Mutex
+	phase string
+	seen  time.Time
+}
+
+// record stores the phase of a heartbeat line's JSON body
+func (h *heartbeatTracker) record(body []byte) {
+	var beat struct {
+		Phase string `json:"phase"`
+	}
+	if err := json.Unmarshal(bytes.TrimSpace(body), &beat); err != nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.phase = beat.Phase
+	h.seen = time.Now()
+}
+
+// describe explains where the flow was when it timed out, or returns an empty
+// string if no heartbeat was seen
+func (h *heartbeatTracker) describe(now time.Time) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.seen.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf(" during phase %q (last heartbeat %s before the timeout)",
+		h.phase, now.Sub(h.seen).Round(time.Millisecond))
+}
+
+// parseHeartbeat reads the heartbeat option: true for the default interval,
+// or a duration string like "500ms"
+func parseHeartbeat(value interface{}) (time.Duration, error) {
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return defaultHeartbeatInterval, nil
+		}
+		return 0, nil
+	case string:
+		interval, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, fmt.Errorf("invalid heartbeat interval %q: %w", v, err)
+		}
+		if interval <= 0 {
+			return 0, fmt.Errorf("heartbeat interval must be positive, got %s", v)
+		}
+		return interval, nil
+	default:
+		return 0, fmt.Errorf("heartbeat must be a boolean or a duration string, got %T", value)
+	}
+}
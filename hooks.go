@@ -0,0 +1,147 @@
+package js
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// k6TestStartEvent is k6's event.TestStart type, see k6ExitEvent
+const k6TestStartEvent = 2
+
+// hookRegistry holds the flows registered with SetupHook and TeardownHook,
+// shared by all VUs, and their results
+type hookRegistry struct {
+	mu       sync.Mutex
+	setup    []*hook
+	teardown []*hook
+	// seen are the flows and arguments already registered, since every VU
+	// runs the init context
+	seen    map[string]bool
+	results map[string]*hookResult
+}
+
+// hook is a registered flow, run by the VU that registered it first
+type hook struct {
+	flowPath string
+	run      func() (map[string]interface{}, error)
+}
+
+type hookResult struct {
+	result map[string]interface{}
+	err    error
+}
+
+// add registers run for flowPath in the setup or teardown list, unless it's
+// registered with the same arguments already
+func (r *hookRegistry) add(teardown bool, key, flowPath string, run func() (map[string]interface{}, error)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.seen == nil {
+		r.seen = make(map[string]bool)
+	}
+	if r.seen[key] {
+		return
+	}
+	r.seen[key] = true
+
+	h := &hook{flowPath: flowPath, run: run}
+	if teardown {
+		r.teardown = append(r.teardown, h)
+	} else {
+		r.setup = append(r.setup, h)
+	}
+}
+
+// runAll runs the setup or teardown hooks in the order they were registered
+// and stores their results. A failed hook is logged, and doesn't stop the
+// ones after it.
+func (r *hookRegistry) runAll(teardown bool, logger logrus.FieldLogger) {
+	r.mu.Lock()
+	hooks, phase := r.setup, "setup"
+	if teardown {
+		hooks, phase = r.teardown, "teardown"
+	}
+	r.mu.Unlock()
+
+	for _, h := range hooks {
+		result, err := h.run()
+		if err != nil && logger != nil {
+			logger.Errorf("external_js: %s hook %s failed: %v", phase, h.flowPath, err)
+		}
+
+		r.mu.Lock()
+		if r.results == nil {
+			r.results = make(map[string]*hookResult)
+		}
+		r.results[h.flowPath] = &hookResult{result: result, err: err}
+		r.mu.Unlock()
+	}
+}
+
+// get returns the result of the last hook run for flowPath
+func (r *hookRegistry) get(flowPath string) (*hookResult, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res, ok := r.results[flowPath]
+	return res, ok
+}
+
+// SetupHook registers a flow to run once when the test starts, before
+// setup(), e.g. to provision a fixture the whole test uses:
+//
+//	ext.setupHook("./provision.js", { payload: { users: 10 } });
+//
+// It's meant for the init context, which every VU runs, so a flow registered
+// again with the same arguments is only run once. Hooks run in the order they
+// were registered, without emitting metrics. A failed hook is logged and
+// HookResult returns its error.
+func (j *ExternalJS) SetupHook(flowPath string, payloadOrOptions interface{}) error {
+	return j.addHook(false, flowPath, payloadOrOptions)
+}
+
+// TeardownHook registers a flow to run once when the test ends, after
+// teardown(), e.g. to remove what a setup hook provisioned. See SetupHook.
+func (j *ExternalJS) TeardownHook(flowPath string, payloadOrOptions interface{}) error {
+	return j.addHook(true, flowPath, payloadOrOptions)
+}
+
+// HookResult returns a copy of the result of the setup or teardown hook of
+// flowPath, or null if it hasn't run yet. If the hook failed, its error is
+// thrown, so a setup() that checks the result fails the test.
+func (j *ExternalJS) HookResult(flowPath string) (map[string]interface{}, error) {
+	res, ok := j.module.hooks.get(flowPath)
+	if !ok {
+		return nil, nil
+	}
+	if res.err != nil {
+		return nil, fmt.Errorf("hook %s failed: %w", flowPath, res.err)
+	}
+	return copyValue(res.result).(map[string]interface{}), nil
+}
+
+func (j *ExternalJS) addHook(teardown bool, flowPath string, payloadOrOptions interface{}) error {
+	name := "setupHook"
+	if teardown {
+		name = "teardownHook"
+	}
+	if j.vu.State() != nil {
+		return fmt.Errorf("%s must be called in the init context", name)
+	}
+	args, err := json.Marshal(payloadOrOptions)
+	if err != nil {
+		return fmt.Errorf("%s arguments must be serializable to JSON: %w", name, err)
+	}
+	opts, err := parseRunOptionsFromArgs(flowPath, payloadOrOptions)
+	if err != nil {
+		return err
+	}
+
+	j.module.hooks.add(teardown, name+"\x00"+flowPath+"\x00"+string(args), flowPath, func() (map[string]interface{}, error) {
+		return j.execute(flowPath, opts)
+	})
+	return nil
+}
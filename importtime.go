@@ -0,0 +1,39 @@
+package js
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+)
+
+// importTimePrefix starts the line the runner prints with how long importing
+// the flow's entry took, in milliseconds
+const importTimePrefix = "__IMPORT_TIME__ "
+
+// importTimer keeps the import time reported by a call's runner. Workers only
+// report it for the call that imported the entry, later calls reuse the module.
+type importTimer struct {
+	mu       sync.Mutex
+	ms       float64
+	reported bool
+}
+
+// record stores the time of an import time line's body
+func (t *importTimer) record(body []byte) {
+	ms, err := strconv.ParseFloat(string(bytes.TrimSpace(body)), 64)
+	if err != nil || ms < 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ms = ms
+	t.reported = true
+}
+
+// get returns the import time, if the runner reported one
+func (t *importTimer) get() (float64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.ms, t.reported
+}
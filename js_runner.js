@@ -2,8 +2,10 @@ const isDeno = typeof Deno !== "undefined";
 const isBun = typeof Bun !== "undefined";
 const isNode = !isDeno && !isBun && typeof process !== "undefined" && process.versions?.node;
 
-// Helper function to create metrics and checks wrapper
-function createMetricsAndChecksWrapper(handler) {
+// Helper function to create metrics and checks wrapper. With keepValue, as for
+// the functions called by the fn option, results other than objects are kept,
+// wrapped as { value }.
+function createMetricsAndChecksWrapper(handler, keepValue = false) {
   let currentMetrics = null;
   let currentChecks = null;
 
@@ -14,36 +16,39 @@ function createMetricsAndChecksWrapper(handler) {
 
     counter(name) {
       return {
-        add: (value = 1, tags = {}) => {
-          this.metrics.push({ type: "counter", name, value, tags });
-        },
+        add: (value = 1, tags = {}, time) => this._push("counter", name, value, tags, time),
       };
     }
 
     gauge(name) {
       return {
-        set: (value, tags = {}) => {
-          this.metrics.push({ type: "gauge", name, value, tags });
-        },
+        set: (value, tags = {}, time) => this._push("gauge", name, value, tags, time),
       };
     }
 
     trend(name) {
       return {
-        add: (value, tags = {}) => {
-          this.metrics.push({ type: "trend", name, value, tags });
-        },
+        add: (value, tags = {}, time) => this._push("trend", name, value, tags, time),
+        // addAll records many observations with the same tags in one entry
+        addAll: (values, tags = {}, time) => this._push("trend", name, undefined, tags, time, values),
       };
     }
 
     rate(name) {
       return {
-        add: (value, tags = {}) => {
-          this.metrics.push({ type: "rate", name, value, tags });
-        },
+        add: (value, tags = {}, time) => this._push("rate", name, value, tags, time),
       };
     }
 
+    // time is an optional event timestamp (Date or epoch ms), defaults to now on the k6 side
+    _push(type, name, value, tags, time, values) {
+      const entry = values === undefined ? { type, name, value, tags } : { type, name, values: Array.from(values), tags };
+      if (time !== undefined) {
+        entry.time = time instanceof Date ? time.getTime() : time;
+      }
+      this.metrics.push(entry);
+    }
+
     _collect() {
       return this.metrics;
     }
@@ -54,8 +59,12 @@ function createMetricsAndChecksWrapper(handler) {
       this.checks = [];
     }
 
-    check(name, condition) {
-      this.checks.push({ name, ok: Boolean(condition) });
+    check(name, condition, tags) {
+      const entry = { name, ok: Boolean(condition) };
+      if (tags && Object.keys(tags).length > 0) {
+        entry.tags = tags;
+      }
+      this.checks.push(entry);
     }
   }
 
@@ -80,9 +89,9 @@ function createMetricsAndChecksWrapper(handler) {
   };
 
   const checksAPI = {
-    check(name, condition) {
+    check(name, condition, tags) {
       if (!currentChecks) throw new Error("checks can only be used inside handler");
-      return currentChecks.check(name, condition);
+      return currentChecks.check(name, condition, tags);
     },
   };
 
@@ -96,7 +105,7 @@ function createMetricsAndChecksWrapper(handler) {
     global.checks = checksAPI;
   }
 
-  return async function(ctx) {
+  return async function(...args) {
     const metricsCollector = new MetricsCollector();
     const checksCollector = new ChecksCollector();
     
@@ -106,10 +115,15 @@ function createMetricsAndChecksWrapper(handler) {
     currentChecks = checksCollector;
     
     try {
-      const result = await handler(ctx);
-      
-      const safeResult = result && typeof result === "object" ? result : {};
+      const result = await handler(...args);
       const metricsData = metricsCollector._collect();
+
+      let safeResult;
+      if (result && typeof result === "object" && !(keepValue && Array.isArray(result))) {
+        safeResult = result;
+      } else {
+        safeResult = keepValue ? { value: result } : {};
+      }
       if (metricsData.length > 0) {
         safeResult.__k6_metrics__ = metricsData;
       }
@@ -126,91 +140,627 @@ function createMetricsAndChecksWrapper(handler) {
   };
 }
 
-(async () => {
-  try {
-    const entryPath = isDeno ? Deno.args[0] : process.argv[1];
-    const payloadJson = isDeno ? Deno.args[1] : process.argv[2];
-    const execContextJson = isDeno ? Deno.args[2] : process.argv[3];
+// instrumentFetch wraps the global fetch so every outbound request is recorded
+// as k6 metrics (via the handler's metrics collector) without touching the flow.
+function instrumentFetch() {
+  const originalFetch = globalThis.fetch;
+  if (typeof originalFetch !== "function" || originalFetch.__k6_instrumented__) {
+    return;
+  }
+  if (isNode) {
+    instrumentConnectionPhases();
+  }
 
-    if (!entryPath) {
-      throw new Error("Missing entry path argument");
+  const record = (method, url, status, duration) => {
+    const tags = { method: method.toUpperCase(), url, status: String(status) };
+    try {
+      globalThis.metrics.counter("external_js_http_reqs").add(1, tags);
+      globalThis.metrics.trend("external_js_http_req_duration").add(duration, tags);
+      globalThis.metrics.rate("external_js_http_req_failed").add(status === 0 || status >= 400 ? 1 : 0, tags);
+    } catch {
+      // Requests made outside a handler have no collector to report to
     }
-    if (!payloadJson) {
-      throw new Error("Missing payload JSON argument");
+  };
+
+  const instrumentedFetch = async function (input, init) {
+    const method = (init && init.method) || (input && input.method) || "GET";
+    const url = typeof input === "string" ? input : (input && input.url) || String(input);
+    const start = performance.now();
+    try {
+      const response = await originalFetch(input, init);
+      record(method, url, response.status, performance.now() - start);
+      return response;
+    } catch (error) {
+      record(method, url, 0, performance.now() - start);
+      throw error;
     }
+  };
+  instrumentedFetch.__k6_instrumented__ = true;
+  instrumentedFetch.__k6_cookies__ = originalFetch.__k6_cookies__;
+  globalThis.fetch = instrumentedFetch;
+}
 
-    const payload = JSON.parse(payloadJson);
-    
-    let executionContext = {};
-    if (execContextJson) {
-      executionContext = JSON.parse(execContextJson);
+// instrumentConnectionPhases records how long the DNS lookup, TCP connect, TLS
+// handshake and wait for the first byte of every fetch() took, like k6's own
+// http_req_* metrics. Node.js only: its fetch reports requests on undici's
+// diagnostics channels, and connections are timed from net.Socket events.
+// Requests on a reused connection record 0 for the connection phases.
+function instrumentConnectionPhases() {
+  const net = require("net");
+  const diagnosticsChannel = require("diagnostics_channel");
+
+  // The phases of a new connection, until the first request on it reports them
+  const connections = new WeakMap();
+  const originalSocketConnect = net.Socket.prototype.connect;
+  net.Socket.prototype.connect = function (...args) {
+    const phases = { lookingUp: 0, connecting: 0, tlsHandshaking: 0 };
+    let last = performance.now();
+    const elapsed = () => {
+      const now = performance.now();
+      const duration = now - last;
+      last = now;
+      return duration;
+    };
+    this.once("lookup", () => (phases.lookingUp = elapsed()));
+    this.once("connect", () => (phases.connecting = elapsed()));
+    this.once("secureConnect", () => (phases.tlsHandshaking = elapsed()));
+    connections.set(this, phases);
+    return originalSocketConnect.apply(this, args);
+  };
+
+  const requests = new WeakMap();
+  diagnosticsChannel.subscribe("undici:client:sendHeaders", ({ request, socket }) => {
+    const phases = connections.get(socket) || { lookingUp: 0, connecting: 0, tlsHandshaking: 0 };
+    connections.delete(socket);
+    requests.set(request, { phases, sent: performance.now() });
+  });
+  diagnosticsChannel.subscribe("undici:request:headers", ({ request, response }) => {
+    const timing = requests.get(request);
+    if (!timing) {
+      return;
+    }
+    requests.delete(request);
+    const tags = { method: request.method, url: request.origin + request.path, status: String(response.statusCode) };
+    try {
+      globalThis.metrics.trend("external_js_http_req_looking_up").add(timing.phases.lookingUp, tags);
+      globalThis.metrics.trend("external_js_http_req_connecting").add(timing.phases.connecting, tags);
+      globalThis.metrics.trend("external_js_http_req_tls_handshaking").add(timing.phases.tlsHandshaking, tags);
+      globalThis.metrics.trend("external_js_http_req_waiting").add(performance.now() - timing.sent, tags);
+    } catch {
+      // Requests made outside a handler have no collector to report to
     }
+  });
+}
+
+// jarCookies are the cookies from the VU's cookie jar passed to the current
+// call with the cookieJar option, as [{ url, cookies: [{ name, value }] }]
+let jarCookies = [];
 
-    let fullPath;
-    if (isDeno) {
-      if (!entryPath.startsWith("file://") && !entryPath.startsWith("http://") && !entryPath.startsWith("https://")) {
-        try {
-          const absPath = await Deno.realPath(entryPath);
-          fullPath = `file://${absPath}`;
-        } catch {
-          const cwd = Deno.cwd();
-          const baseUrl = `file://${cwd}/`;
-          const resolvedUrl = new URL(entryPath, baseUrl);
-          fullPath = resolvedUrl.href;
+// installCookieFetch wraps the global fetch so requests send the jarCookies
+// of URLs with the same origin whose path is a prefix of theirs. Cookies the
+// request already has are kept.
+function installCookieFetch() {
+  const originalFetch = globalThis.fetch;
+  if (typeof originalFetch !== "function" || originalFetch.__k6_cookies__) {
+    return;
+  }
+
+  const cookieFetch = function (input, init) {
+    const url = new URL(typeof input === "string" || input instanceof URL ? String(input) : input.url);
+    const matching = new Map();
+    for (const entry of jarCookies) {
+      const jarURL = new URL(entry.url);
+      if (jarURL.origin === url.origin && url.pathname.startsWith(jarURL.pathname)) {
+        for (const cookie of entry.cookies) {
+          matching.set(cookie.name, cookie.value);
         }
-      } else {
-        fullPath = entryPath;
       }
+    }
+    if (matching.size === 0) {
+      return originalFetch(input, init);
+    }
+
+    const headers = new Headers((init && init.headers) || (input instanceof Request ? input.headers : undefined));
+    const header = [...matching].map(([name, value]) => `${name}=${value}`).join("; ");
+    const existing = headers.get("cookie");
+    headers.set("cookie", existing ? `${existing}; ${header}` : header);
+    return originalFetch(input, { ...init, headers });
+  };
+  cookieFetch.__k6_cookies__ = true;
+  cookieFetch.__k6_instrumented__ = originalFetch.__k6_instrumented__;
+  globalThis.fetch = cookieFetch;
+}
+
+// networkDenied is set for calls made with network: false
+let networkDenied = false;
+
+// installNetworkGuard makes fetch, WebSocket and TCP, TLS and UDP sockets
+// throw while networkDenied is set. Unix sockets are still allowed, since the
+// socket transport uses them and they don't leave the machine. Deno one-shot
+// calls are also started with --deny-net.
+async function installNetworkGuard() {
+  if (globalThis.__k6_network_guard__) {
+    return;
+  }
+  globalThis.__k6_network_guard__ = true;
+
+  const blocked = (target) =>
+    new Error(`network access is disabled for this flow (network: false), blocked ${target}`);
+
+  const originalFetch = globalThis.fetch;
+  if (typeof originalFetch === "function") {
+    const guardedFetch = function (input, init) {
+      if (networkDenied) {
+        const url = typeof input === "string" || input instanceof URL ? String(input) : input && input.url;
+        return Promise.reject(blocked(`fetch(${url})`));
+      }
+      return originalFetch(input, init);
+    };
+    guardedFetch.__k6_instrumented__ = originalFetch.__k6_instrumented__;
+    guardedFetch.__k6_cookies__ = originalFetch.__k6_cookies__;
+    globalThis.fetch = guardedFetch;
+  }
+
+  const OriginalWebSocket = globalThis.WebSocket;
+  if (typeof OriginalWebSocket === "function") {
+    globalThis.WebSocket = new Proxy(OriginalWebSocket, {
+      construct(target, args, newTarget) {
+        if (networkDenied) {
+          throw blocked(`WebSocket(${args[0]})`);
+        }
+        return Reflect.construct(target, args, newTarget);
+      },
+    });
+  }
+
+  if (isDeno) {
+    for (const name of ["connect", "connectTls", "listenDatagram"]) {
+      const original = Deno[name];
+      if (typeof original !== "function") {
+        continue;
+      }
+      try {
+        Deno[name] = function (options) {
+          if (networkDenied && options?.transport !== "unix" && options?.transport !== "unixpacket") {
+            throw blocked(`Deno.${name}(${options?.hostname ?? ""}:${options?.port ?? ""})`);
+          }
+          return original.apply(this, arguments);
+        };
+      } catch {
+        // Read-only in some Deno versions, --deny-net still applies
+      }
+    }
+  }
+  if (isBun && typeof Bun.connect === "function") {
+    const originalConnect = Bun.connect;
+    Bun.connect = function (options) {
+      if (networkDenied && !options?.unix) {
+        throw blocked(`Bun.connect(${options?.hostname ?? ""}:${options?.port ?? ""})`);
+      }
+      return originalConnect.apply(this, arguments);
+    };
+  }
+
+  const net = await nodeModule("net");
+  const originalSocketConnect = net.Socket.prototype.connect;
+  net.Socket.prototype.connect = function (...args) {
+    // net.connect() passes its normalized arguments as an array
+    const options = Array.isArray(args[0]) ? args[0][0] : args[0];
+    // Like net itself, a non-empty path means a unix socket or pipe
+    const isPipe =
+      (typeof options === "string" && Number.isNaN(Number(options))) ||
+      (options !== null && typeof options === "object" && typeof options.path === "string" && options.path !== "");
+    if (networkDenied && !isPipe) {
+      const target = typeof options === "object" && options !== null ? `${options.host ?? "localhost"}:${options.port}` : String(options);
+      throw blocked(`a connection to ${target}`);
+    }
+    return originalSocketConnect.apply(this, args);
+  };
+
+  const dgram = await nodeModule("dgram");
+  for (const name of ["send", "connect"]) {
+    const original = dgram.Socket.prototype[name];
+    dgram.Socket.prototype[name] = function (...args) {
+      if (networkDenied) {
+        throw blocked(`a UDP ${name}`);
+      }
+      return original.apply(this, args);
+    };
+  }
+}
+
+// installK6Compat provides a subset of k6's API (check, group, sleep, fail and
+// the k6/metrics classes) on top of the handler's metrics and checks, so code
+// can move between k6 scripts and flows with minimal rewriting. The API is
+// available as globalThis.k6, and as the "k6" and "k6/metrics" modules on
+// Node.js and Bun.
+function installK6Compat() {
+  if (globalThis.k6) {
+    return;
+  }
+
+  const groups = [];
+  const withGroup = (tags) => (groups.length > 0 ? { group: "::" + groups.join("::"), ...tags } : { ...tags });
+
+  const check = (value, sets, tags = {}) => {
+    let passed = true;
+    for (const [name, condition] of Object.entries(sets)) {
+      const ok = Boolean(typeof condition === "function" ? condition(value) : condition);
+      globalThis.checks.check(name, ok, withGroup(tags));
+      passed = passed && ok;
+    }
+    return passed;
+  };
+
+  const group = (name, fn) => {
+    groups.push(name);
+    let result;
+    try {
+      result = fn();
+    } catch (error) {
+      groups.pop();
+      throw error;
+    }
+    if (result && typeof result.then === "function") {
+      return result.finally(() => groups.pop());
+    }
+    groups.pop();
+    return result;
+  };
+
+  // Blocks like k6's sleep, so ported code doesn't need to await it
+  const sleep = (seconds) => {
+    Atomics.wait(new Int32Array(new SharedArrayBuffer(4)), 0, 0, seconds * 1000);
+  };
+
+  const fail = (message) => {
+    throw new Error(message);
+  };
+
+  // k6 metrics are declared at the top of a script, so the collector is only
+  // looked up when a sample is added
+  const metricClass = (type, push) =>
+    class {
+      constructor(name) {
+        this.name = name;
+      }
+
+      add(value, tags = {}) {
+        push(globalThis.metrics[type](this.name), value, withGroup(tags));
+      }
+    };
+
+  const metrics = {
+    Counter: metricClass("counter", (m, value, tags) => m.add(value, tags)),
+    Gauge: metricClass("gauge", (m, value, tags) => m.set(value, tags)),
+    Rate: metricClass("rate", (m, value, tags) => m.add(value ? 1 : 0, tags)),
+    Trend: metricClass("trend", (m, value, tags) => m.add(value, tags)),
+  };
+
+  globalThis.k6 = { check, group, sleep, fail, metrics };
+
+  if (isNode) {
+    const nodeModule = require("module");
+    if (typeof nodeModule.register !== "function") {
+      return;
+    }
+    const modules = {
+      k6: "const k6 = globalThis.k6; export const { check, group, sleep, fail } = k6; export default k6;",
+      "k6/metrics":
+        "const m = globalThis.k6.metrics; export const { Counter, Gauge, Rate, Trend } = m; export default m;",
+    };
+    const urls = {};
+    for (const [name, source] of Object.entries(modules)) {
+      urls[name] = "data:text/javascript," + encodeURIComponent(source);
+    }
+    const hooks = `const urls = ${JSON.stringify(urls)};
+export async function resolve(specifier, context, next) {
+  if (Object.hasOwn(urls, specifier)) {
+    return { url: urls[specifier], shortCircuit: true };
+  }
+  return next(specifier, context);
+}`;
+    nodeModule.register("data:text/javascript," + encodeURIComponent(hooks));
+  } else if (isBun) {
+    Bun.plugin({
+      name: "k6compat",
+      setup(build) {
+        build.module("k6", () => ({ exports: globalThis.k6, loader: "object" }));
+        build.module("k6/metrics", () => ({ exports: globalThis.k6.metrics, loader: "object" }));
+      },
+    });
+  }
+}
+
+// nodeModule loads a Node.js built-in, which Deno and Bun provide under node:
+async function nodeModule(name) {
+  if (isNode) {
+    return require(name);
+  }
+  const mod = await import("node:" + name);
+  return mod.default || mod;
+}
+
+// readCompressedPayload reads and parses the gzipped JSON payload file
+async function readCompressedPayload(filePath) {
+  const fs = await nodeModule("fs");
+  const zlib = await nodeModule("zlib");
+  return JSON.parse(zlib.gunzipSync(fs.readFileSync(filePath)).toString("utf8"));
+}
+
+// compressResult gzips the JSON-encoded result and returns it as base64
+async function compressResult(result) {
+  const zlib = await nodeModule("zlib");
+  return zlib.gzipSync(stringifyResult(result)).toString("base64");
+}
+
+// stringifyResult encodes a result as JSON. BigInt values, which
+// JSON.stringify rejects, are written as plain numbers with all their digits,
+// for the extension to decode as numberMode says.
+function stringifyResult(value) {
+  // The marker is random so strings in the result won't be mistaken for it
+  const marker = "bigint" + Math.random().toString(36).slice(2);
+  let bigints = false;
+  const json = JSON.stringify(value, (key, item) => {
+    if (typeof item !== "bigint") return item;
+    bigints = true;
+    return "\u0000" + marker + ":" + item;
+  });
+  return bigints ? json.replace(new RegExp(`"\\\\u0000${marker}:(-?\\d+)"`, "g"), "$1") : json;
+}
+
+// Minimal CBOR (RFC 8949) codec for the "cbor" payload/result format
+function cborEncode(value) {
+  const chunks = [];
+  const head = (major, n) => {
+    if (n < 24) {
+      chunks.push(Uint8Array.of((major << 5) | n));
+    } else if (n < 0x100) {
+      chunks.push(Uint8Array.of((major << 5) | 24, n));
+    } else if (n < 0x10000) {
+      chunks.push(Uint8Array.of((major << 5) | 25, n >> 8, n & 0xff));
+    } else if (n < 0x100000000) {
+      const buf = new Uint8Array(5);
+      buf[0] = (major << 5) | 26;
+      new DataView(buf.buffer).setUint32(1, n);
+      chunks.push(buf);
     } else {
-      let path, fs;
-      if (isNode) {
-        path = require("path");
-        fs = require("fs");
+      const buf = new Uint8Array(9);
+      buf[0] = (major << 5) | 27;
+      new DataView(buf.buffer).setBigUint64(1, BigInt(n));
+      chunks.push(buf);
+    }
+  };
+  const encode = (v) => {
+    if (v === null || v === undefined) {
+      chunks.push(Uint8Array.of(0xf6));
+    } else if (v === false || v === true) {
+      chunks.push(Uint8Array.of(v ? 0xf5 : 0xf4));
+    } else if (typeof v === "number") {
+      if (Number.isSafeInteger(v)) {
+        v >= 0 ? head(0, v) : head(1, -1 - v);
       } else {
-        const pathMod = await import("path");
-        const fsMod = await import("fs");
-        path = pathMod.default || pathMod;
-        fs = fsMod.default || fsMod;
+        const buf = new Uint8Array(9);
+        buf[0] = 0xfb;
+        new DataView(buf.buffer).setFloat64(1, v);
+        chunks.push(buf);
       }
-      
-      fullPath = path.join(process.cwd(), entryPath);
-      if (!fullPath.endsWith(".js") && !fullPath.endsWith(".ts")) {
-        fullPath += ".js";
+    } else if (typeof v === "bigint") {
+      const magnitude = v >= 0n ? v : -1n - v;
+      if (magnitude < 2n ** 64n) {
+        const buf = new Uint8Array(9);
+        buf[0] = ((v >= 0n ? 0 : 1) << 5) | 27;
+        new DataView(buf.buffer).setBigUint64(1, magnitude);
+        chunks.push(buf);
+      } else {
+        // Past 64 bits, a bignum: tag 2 or 3 and the magnitude's bytes
+        const hex = magnitude.toString(16);
+        const bytes = Uint8Array.from((hex.length % 2 ? "0" + hex : hex).match(/../g), (b) => parseInt(b, 16));
+        head(6, v >= 0n ? 2 : 3);
+        head(2, bytes.length);
+        chunks.push(bytes);
       }
+    } else if (typeof v === "string") {
+      const bytes = new TextEncoder().encode(v);
+      head(3, bytes.length);
+      chunks.push(bytes);
+    } else if (v instanceof Uint8Array) {
+      head(2, v.length);
+      chunks.push(v);
+    } else if (Array.isArray(v)) {
+      head(4, v.length);
+      v.forEach(encode);
+    } else if (typeof v === "object") {
+      const entries = Object.entries(v).filter(([, item]) => item !== undefined);
+      head(5, entries.length);
+      for (const [key, item] of entries) {
+        encode(key);
+        encode(item);
+      }
+    } else {
+      throw new Error(`cannot encode ${typeof v} as CBOR`);
+    }
+  };
+  encode(value);
 
-      if (isNode) {
-        if (!fs.existsSync(fullPath)) {
-          throw new Error("Flow not found: " + fullPath);
-        }
-      } else {
-        try {
-          await fs.promises.stat(fullPath);
-        } catch {
-          throw new Error("Flow not found: " + fullPath);
-        }
+  const out = new Uint8Array(chunks.reduce((n, c) => n + c.length, 0));
+  let offset = 0;
+  for (const chunk of chunks) {
+    out.set(chunk, offset);
+    offset += chunk.length;
+  }
+  return out;
+}
+
+function cborDecode(bytes) {
+  const view = new DataView(bytes.buffer, bytes.byteOffset, bytes.byteLength);
+  let pos = 0;
+  const readLength = (info) => {
+    if (info < 24) return info;
+    if (info === 24) return view.getUint8(pos++);
+    if (info === 25) { pos += 2; return view.getUint16(pos - 2); }
+    if (info === 26) { pos += 4; return view.getUint32(pos - 4); }
+    if (info === 27) { pos += 8; return Number(view.getBigUint64(pos - 8)); }
+    throw new Error("indefinite-length CBOR items are not supported");
+  };
+  const decode = () => {
+    const initial = view.getUint8(pos++);
+    const major = initial >> 5;
+    const info = initial & 0x1f;
+    switch (major) {
+      case 0: return readLength(info);
+      case 1: return -1 - readLength(info);
+      case 2: { const n = readLength(info); pos += n; return bytes.slice(pos - n, pos); }
+      case 3: { const n = readLength(info); pos += n; return new TextDecoder().decode(bytes.subarray(pos - n, pos)); }
+      case 4: { const n = readLength(info); const arr = []; for (let i = 0; i < n; i++) arr.push(decode()); return arr; }
+      case 5: { const n = readLength(info); const obj = {}; for (let i = 0; i < n; i++) { const key = decode(); obj[key] = decode(); } return obj; }
+      case 6: readLength(info); return decode(); // tags are ignored
+      default:
+        if (info === 20) return false;
+        if (info === 21) return true;
+        if (info === 22 || info === 23) return null;
+        if (info === 25) { pos += 2; return decodeFloat16(view.getUint16(pos - 2)); }
+        if (info === 26) { pos += 4; return view.getFloat32(pos - 4); }
+        if (info === 27) { pos += 8; return view.getFloat64(pos - 8); }
+        throw new Error(`unsupported CBOR simple value ${info}`);
+    }
+  };
+  return decode();
+}
+
+function decodeFloat16(half) {
+  const exp = (half >> 10) & 0x1f;
+  const mant = half & 0x3ff;
+  const sign = half & 0x8000 ? -1 : 1;
+  if (exp === 0) return sign * 2 ** -14 * (mant / 1024);
+  if (exp === 31) return mant ? NaN : sign * Infinity;
+  return sign * 2 ** (exp - 15) * (1 + mant / 1024);
+}
+
+function base64ToBytes(text) {
+  const binary = atob(text);
+  const bytes = new Uint8Array(binary.length);
+  for (let i = 0; i < binary.length; i++) bytes[i] = binary.charCodeAt(i);
+  return bytes;
+}
+
+function bytesToBase64(bytes) {
+  let binary = "";
+  for (let i = 0; i < bytes.length; i += 0x8000) {
+    binary += String.fromCharCode(...bytes.subarray(i, i + 0x8000));
+  }
+  return btoa(binary);
+}
+
+// sendFrame writes a length-prefixed JSON frame to the unix socket at socketPath
+async function sendFrame(socketPath, message) {
+  const body = new TextEncoder().encode(stringifyResult(message));
+  const frame = new Uint8Array(4 + body.length);
+  new DataView(frame.buffer).setUint32(0, body.length);
+  frame.set(body, 4);
+
+  if (isDeno) {
+    const conn = await Deno.connect({ transport: "unix", path: socketPath });
+    let written = 0;
+    while (written < frame.length) {
+      written += await conn.write(frame.subarray(written));
+    }
+    conn.close();
+    return;
+  }
+
+  const net = isNode ? require("net") : (await import("net")).default;
+  await new Promise((resolve, reject) => {
+    const conn = net.createConnection(socketPath, () => conn.end(frame));
+    conn.on("close", resolve);
+    conn.on("error", reject);
+  });
+}
+
+// importedFlows are the entries this process has loaded, whose import time
+// was reported already
+const importedFlows = new Set();
+
+// loadFlow returns the function to invoke, like importFlow, and prints how
+// long it took as an __IMPORT_TIME__ line the first time entryPath is loaded
+async function loadFlow(entryPath, fn) {
+  const start = performance.now();
+  const flowFunction = await importFlow(entryPath, fn);
+  if (!importedFlows.has(entryPath) && !globalThis.__k6_compiled_flow__) {
+    importedFlows.add(entryPath);
+    console.log("__IMPORT_TIME__ " + (performance.now() - start));
+  }
+  return flowFunction;
+}
+
+// importFlow resolves entryPath and returns the function to invoke, the export
+// named fn if set. Modules are cached by the runtime, so loading the same
+// entry again is cheap.
+async function importFlow(entryPath, fn) {
+  // bunCompile bundles the flow into the executable next to this runner
+  if (globalThis.__k6_compiled_flow__) {
+    return flowFromModule(globalThis.__k6_compiled_flow__, fn);
+  }
+
+  let fullPath;
+  if (isDeno) {
+    if (!entryPath.startsWith("file://") && !entryPath.startsWith("http://") && !entryPath.startsWith("https://")) {
+      try {
+        const absPath = await Deno.realPath(entryPath);
+        fullPath = `file://${absPath}`;
+      } catch {
+        const cwd = Deno.cwd();
+        const baseUrl = `file://${cwd}/`;
+        const resolvedUrl = new URL(entryPath, baseUrl);
+        fullPath = resolvedUrl.href;
       }
+    } else {
+      fullPath = entryPath;
+    }
+  } else {
+    let path, fs;
+    if (isNode) {
+      path = require("path");
+      fs = require("fs");
+    } else {
+      const pathMod = await import("path");
+      const fsMod = await import("fs");
+      path = pathMod.default || pathMod;
+      fs = fsMod.default || fsMod;
+    }
+    
+    fullPath = path.resolve(process.cwd(), entryPath);
+    if (!fullPath.endsWith(".js") && !fullPath.endsWith(".ts")) {
+      fullPath += ".js";
     }
 
-    let flowFunction;
-    if (isNode && typeof require !== "undefined") {
+    if (isNode) {
+      if (!fs.existsSync(fullPath)) {
+        throw new Error("Flow not found: " + fullPath);
+      }
+    } else {
       try {
-        const required = require(fullPath);
-        // Check for handler export first
-        if (required && typeof required.handler === "function") {
-          flowFunction = createMetricsAndChecksWrapper(required.handler);
-        } else if (typeof required === "function") {
-          flowFunction = required;
-        } else if (required && typeof required.default === "function") {
-          flowFunction = required.default;
-        } else {
-          const flowModule = await import(fullPath);
-          if (flowModule.handler && typeof flowModule.handler === "function") {
-            flowFunction = createMetricsAndChecksWrapper(flowModule.handler);
-          } else {
-            flowFunction = flowModule.default || flowModule;
-          }
-        }
+        await fs.promises.stat(fullPath);
       } catch {
+        throw new Error("Flow not found: " + fullPath);
+      }
+    }
+  }
+
+  let flowFunction;
+  if (fn) {
+    return flowFromModule(await import(fullPath), fn);
+  } else if (isNode && typeof require !== "undefined") {
+    try {
+      const required = require(fullPath);
+      // Check for handler export first
+      if (required && typeof required.handler === "function") {
+        flowFunction = createMetricsAndChecksWrapper(required.handler);
+      } else if (typeof required === "function") {
+        flowFunction = required;
+      } else if (required && typeof required.default === "function") {
+        flowFunction = required.default;
+      } else {
         const flowModule = await import(fullPath);
         if (flowModule.handler && typeof flowModule.handler === "function") {
           flowFunction = createMetricsAndChecksWrapper(flowModule.handler);
@@ -218,50 +768,584 @@ function createMetricsAndChecksWrapper(handler) {
           flowFunction = flowModule.default || flowModule;
         }
       }
-    } else {
+    } catch {
       const flowModule = await import(fullPath);
       if (flowModule.handler && typeof flowModule.handler === "function") {
         flowFunction = createMetricsAndChecksWrapper(flowModule.handler);
-      } else if (flowModule.default && typeof flowModule.default === "function") {
-        flowFunction = flowModule.default;
       } else {
         flowFunction = flowModule.default || flowModule;
       }
     }
+  } else {
+    return flowFromModule(await import(fullPath));
+  }
+
+  if (typeof flowFunction !== "function") {
+    throw new Error(`Expected a function or handler export but got ${typeof flowFunction}. Make sure your module exports a handler function (export const handler = ...) or a default function.`);
+  }
+
+  return flowFunction;
+}
 
-    if (typeof flowFunction !== "function") {
-      throw new Error(`Expected a function or handler export but got ${typeof flowFunction}. Make sure your module exports a handler function (export const handler = ...) or a default function.`);
+// invokeFlow calls flowFunction with the ctx from buildCtx, or, with the args
+// or kwargs options, with the args followed by the kwargs object, like
+// createUser("alice", 30, { admin: true }). Those calls return anything the
+// function does, with values other than objects wrapped as { value }.
+async function invokeFlow(flowFunction, executionContext, buildCtx) {
+  const { args, kwargs } = executionContext;
+  if (!args && !kwargs) {
+    return flowFunction(buildCtx());
+  }
+  const result = await flowFunction(...(args || []), ...(kwargs ? [kwargs] : []));
+  return result && typeof result === "object" && !Array.isArray(result) ? result : { value: result };
+}
+
+// flowFromModule picks the function to invoke from an imported flow module
+function flowFromModule(flowModule, fn) {
+  if (fn) {
+    // CommonJS modules imported by node keep their exports on default
+    const named = fn in flowModule ? flowModule[fn] : flowModule.default?.[fn];
+    if (typeof named !== "function") {
+      throw new Error(`Expected export "${fn}" to be a function but got ${typeof named}.`);
     }
+    return createMetricsAndChecksWrapper(named, true);
+  }
 
-    const env = isDeno ? Deno.env.toObject() : process.env;
+  let flowFunction;
+  if (flowModule.handler && typeof flowModule.handler === "function") {
+    flowFunction = createMetricsAndChecksWrapper(flowModule.handler);
+  } else if (flowModule.default && typeof flowModule.default === "function") {
+    flowFunction = flowModule.default;
+  } else {
+    flowFunction = flowModule.default || flowModule;
+  }
 
-    // Flatten context structure for easier destructuring
-    const vu = executionContext.vu || { id: 0, iteration: 0, scenario: "" };
-    const ctx = {
-      payload,
-      env,
-      vu,
-      execution: executionContext, // Keep for backward compatibility if needed
-    };
+  if (typeof flowFunction !== "function") {
+    throw new Error(`Expected a function or handler export but got ${typeof flowFunction}. Make sure your module exports a handler function (export const handler = ...) or a default function.`);
+  }
+
+  return flowFunction;
+}
+
+// buildContext creates the ctx object passed to the flow
+function buildContext(payload, executionContext, envOverrides) {
+  const baseEnv = isDeno ? Deno.env.toObject() : process.env;
+  const env = envOverrides ? { ...baseEnv, ...envOverrides } : baseEnv;
+
+  // Shared datasets are read lazily, so flows only pay for what they use
+  const shared = {};
+  for (const [name, filePath] of Object.entries(executionContext.shared || {})) {
+    let value;
+    let loaded = false;
+    Object.defineProperty(shared, name, {
+      enumerable: true,
+      get() {
+        if (!loaded) {
+          const text = isDeno ? Deno.readTextFileSync(filePath) : require("fs").readFileSync(filePath, "utf8");
+          value = JSON.parse(text);
+          loaded = true;
+        }
+        return value;
+      },
+    });
+  }
+
+  // Flatten context structure for easier destructuring
+  const vu = executionContext.vu || { id: 0, iteration: 0, scenario: "" };
+  const ctx = {
+    payload: decodeMultipart(payload),
+    meta: executionContext.meta || {},
+    setupData: executionContext.setupData,
+    env,
+    vu,
+    seed: executionContext.seed,
+    // now is the same for every flow of the iteration, in epoch milliseconds
+    now: executionContext.now ?? Date.now(),
+    // correlationId identifies this call, see the correlationId option
+    correlationId: executionContext.correlationId,
+    // deadline is when the call times out, in epoch milliseconds, if it has
+    // a timeout; signal aborts shortly before, e.g. fetch(url, { signal })
+    deadline: executionContext.deadline,
+    signal: deadlineSignal(executionContext.deadline),
+    // stream yields the chunks written with runStreaming
+    stream: executionContext.stream ? streamChunks() : undefined,
+    shared,
+    filesDir: executionContext.filesDir,
+    // otlpEndpoint receives OTLP/HTTP metrics, see the otlpReceiver option
+    otlpEndpoint: executionContext.otlpEndpoint,
+    cookies: executionContext.cookies || [],
+    // ack returns value to k6 right away while the flow keeps running
+    ack(value) {
+      if (!executionContext.ack) {
+        throw new Error("ctx.ack() requires the ack option");
+      }
+      console.log("__ACK__ " + JSON.stringify(value && typeof value === "object" ? value : { value }));
+    },
+    // log writes to k6's logger, e.g. ctx.log.info("paid", { orderId })
+    log: flowLogger(),
+    // phase names what the flow is doing, reported if the call times out
+    phase(name) {
+      heartbeat?.phase(name);
+    },
+    execution: executionContext, // Keep for backward compatibility if needed
+  };
+
+  return ctx;
+}
+
+// streamChunks yields the chunks of a runStreaming call, one JSON value per
+// stdin line, until the script closes the stream
+async function* streamChunks() {
+  const source = isDeno ? Deno.stdin.readable : process.stdin;
+  const decoder = new TextDecoder();
+  let buffered = "";
+  for await (const bytes of source) {
+    buffered += decoder.decode(bytes, { stream: true });
+    let newline;
+    while ((newline = buffered.indexOf("\n")) >= 0) {
+      const line = buffered.slice(0, newline);
+      buffered = buffered.slice(newline + 1);
+      if (line.trim() !== "") {
+        yield JSON.parse(line);
+      }
+    }
+  }
+  if (buffered.trim() !== "") {
+    yield JSON.parse(buffered);
+  }
+}
+
+// deadlineSignal returns an AbortSignal that aborts ahead of deadline, by a
+// tenth of the time left and at most a second, so the flow can wind down
+// before the process is killed. Without a deadline it never aborts.
+function deadlineSignal(deadline) {
+  if (!deadline) {
+    return new AbortController().signal;
+  }
+  const remaining = deadline - Date.now();
+  return AbortSignal.timeout(Math.max(0, Math.round(remaining - Math.min(1000, remaining / 10))));
+}
+
+// decodeMultipart replaces every { __multipart__: [...] } object in value with
+// a FormData. Parts with a filename, a contentType or base64 data become Blobs,
+// typed text/plain for text and application/octet-stream for binary data by
+// default.
+function decodeMultipart(value) {
+  if (Array.isArray(value)) {
+    return value.map(decodeMultipart);
+  }
+  if (!value || typeof value !== "object") {
+    return value;
+  }
+  if (Array.isArray(value.__multipart__)) {
+    const form = new FormData();
+    for (const part of value.__multipart__) {
+      const data = part.encoding === "base64" ? base64ToBytes(part.data) : part.data;
+      if (part.filename === undefined && part.contentType === undefined && typeof data === "string") {
+        form.append(part.name, data);
+        continue;
+      }
+      const type = part.contentType || (typeof data === "string" ? "text/plain" : "application/octet-stream");
+      const blob = new Blob([data], { type });
+      if (part.filename !== undefined) {
+        form.append(part.name, blob, part.filename);
+      } else {
+        form.append(part.name, blob);
+      }
+    }
+    return form;
+  }
+  for (const key of Object.keys(value)) {
+    value[key] = decodeMultipart(value[key]);
+  }
+  return value;
+}
+
+// flowLogger returns the ctx.log functions, which print __LOG__ lines that
+// are written to k6's logger with their fields
+function flowLogger() {
+  const log = (level) => (msg, fields) =>
+    console.log("__LOG__ " + JSON.stringify({ level, msg: String(msg), fields: fields || {} }));
+  return { debug: log("debug"), info: log("info"), warn: log("warn"), error: log("error") };
+}
+
+// heartbeat is the running heartbeat of the current call, if enabled
+let heartbeat = null;
+
+// startHeartbeat prints the flow's current phase every intervalMs, so a
+// timeout can report where the flow was. Phases are set with ctx.phase().
+function startHeartbeat(intervalMs) {
+  let current = "load";
+  const emit = () => console.log("__HEARTBEAT__ " + JSON.stringify({ phase: current }));
+  emit();
+  const timer = setInterval(emit, intervalMs);
+  // The heartbeat alone must not keep the process alive
+  if (isDeno) {
+    Deno.unrefTimer?.(timer);
+  } else {
+    timer.unref?.();
+  }
+
+  heartbeat = {
+    phase(name) {
+      current = String(name);
+      emit();
+    },
+    stop() {
+      clearInterval(timer);
+      heartbeat = null;
+    },
+  };
+}
+
+// flushStdout waits until buffered stdout is written, since Node.js and Bun
+// write to pipes asynchronously and exiting would cut large results short
+function flushStdout() {
+  if (isDeno) {
+    return Promise.resolve();
+  }
+  return new Promise((resolve) => process.stdout.write("", resolve));
+}
+
+// RESULT_MAGIC starts the result frame of protocol: "binary"
+const RESULT_MAGIC = new TextEncoder().encode("\0__K6_RESULT__\0");
+
+// writeResultFrame writes bytes to stdout as RESULT_MAGIC, a 4-byte
+// big-endian length and the bytes themselves, which k6 reads exactly
+async function writeResultFrame(bytes) {
+  const frame = new Uint8Array(RESULT_MAGIC.length + 4 + bytes.length);
+  frame.set(RESULT_MAGIC);
+  new DataView(frame.buffer).setUint32(RESULT_MAGIC.length, bytes.length);
+  frame.set(bytes, RESULT_MAGIC.length + 4);
+  if (isDeno) {
+    for (let written = 0; written < frame.length; ) {
+      written += Deno.stdout.writeSync(frame.subarray(written));
+    }
+    return;
+  }
+  await new Promise((resolve) => process.stdout.write(frame, resolve));
+}
 
-    const result = await flowFunction(ctx);
+function exit(code) {
+  if (isDeno) {
+    Deno.exit(code);
+  } else {
+    process.exit(code);
+  }
+}
+
+// readLines yields stdin line by line
+async function* readLines() {
+  if (isDeno) {
+    let buffered = "";
+    for await (const chunk of Deno.stdin.readable.pipeThrough(new TextDecoderStream())) {
+      buffered += chunk;
+      let newline;
+      while ((newline = buffered.indexOf("\n")) >= 0) {
+        yield buffered.slice(0, newline);
+        buffered = buffered.slice(newline + 1);
+      }
+    }
+    if (buffered) {
+      yield buffered;
+    }
+    return;
+  }
+
+  const readline = isNode ? require("readline") : (await import("readline")).default;
+  yield* readline.createInterface({ input: process.stdin, crlfDelay: Infinity });
+}
+
+// runWorker keeps the runtime alive and runs one job per stdin line:
+//
+//	{"entry": "...", "payload": ..., "context": {...}, "env": {...}}
+//
+// Each job answers with the usual result markers on stdout, or a single
+// __FLOW_ERROR__ line followed by the JSON-encoded error, and then prints
+// __JOB_DONE__ to stderr.
+async function runWorker() {
+  console.log("__WORKER_READY__");
+
+  for await (const line of readLines()) {
+    if (!line.trim()) {
+      continue;
+    }
+
+    let job;
+    try {
+      job = JSON.parse(line);
+    } catch (error) {
+      console.log("__FLOW_ERROR__ " + JSON.stringify(error && error.stack ? error.stack : String(error)));
+      console.error("__JOB_DONE__");
+      continue;
+    }
+    await runJob(job);
+  }
+
+  exit(0);
+}
+
+// runJob runs one worker job and prints its result or error, followed by
+// __JOB_DONE__ on stderr
+async function runJob(job) {
+  try {
+    const executionContext = job.context || {};
+    if (executionContext.autoInstrumentHttp) {
+      instrumentFetch();
+    }
+    if (executionContext.k6compat) {
+      installK6Compat();
+    }
+    if (executionContext.cookies) {
+      installCookieFetch();
+    }
+    // Reset for every job, so a worker doesn't send an earlier job's cookies
+    jarCookies = executionContext.cookies || [];
+    if (executionContext.denyNet) {
+      await installNetworkGuard();
+    }
+    networkDenied = Boolean(executionContext.denyNet);
+
+    if (executionContext.ready) {
+      // Starts the flowTimeout clock
+      console.log("__READY__ " + performance.now());
+    }
+    if (executionContext.heartbeat) {
+      startHeartbeat(executionContext.heartbeat);
+    }
+    const flowFunction = await loadFlow(job.entry, executionContext.fn);
+    heartbeat?.phase("run");
+    const result = await invokeFlow(flowFunction, executionContext, () => buildContext(job.payload, executionContext, job.env));
+    heartbeat?.stop();
 
     console.log("__RESULT_START__");
-    console.log(JSON.stringify(result || {}));
+    console.log(stringifyResult(result || {}));
     console.log("__RESULT_END__");
-    
-    if (isDeno) {
-      Deno.exit(0);
+  } catch (error) {
+    heartbeat?.stop();
+    console.log("__FLOW_ERROR__ " + JSON.stringify(error && error.stack ? error.stack : String(error)));
+  }
+  console.error("__JOB_DONE__");
+}
+
+// runThreadHost runs jobs on a pool of size worker_threads, each loading the
+// runner from runnerPath (Node.js only). It reads the same jobs as a worker,
+// wrapped as {"id": 1, "job": {...}}, and {"cancel": 1} to terminate the
+// thread running a job. Every line a thread prints is forwarded on the same
+// stream prefixed with the ID of its job, and jobs wait for a free thread.
+async function runThreadHost(size, runnerPath) {
+  const { Worker } = require("worker_threads");
+  const readline = require("readline");
+  const queue = [];
+  const threads = new Set();
+  let closing = false;
+
+  const finish = (thread) => {
+    if (!thread.job || !thread.outDone || !thread.errDone) {
+      return;
+    }
+    thread.job = null;
+    dispatch();
+  };
+
+  const spawn = () => {
+    const worker = new Worker(runnerPath, { workerData: { __k6_thread__: true }, stdout: true, stderr: true });
+    const thread = { worker, job: null, outDone: false, errDone: false };
+    threads.add(thread);
+
+    readline.createInterface({ input: worker.stdout, crlfDelay: Infinity }).on("line", (line) => {
+      if (!thread.job) {
+        return;
+      }
+      process.stdout.write(`${thread.job.id} ${line}\n`);
+      if (line === "__RESULT_END__" || line.startsWith("__FLOW_ERROR__ ")) {
+        thread.outDone = true;
+        finish(thread);
+      }
+    });
+    readline.createInterface({ input: worker.stderr, crlfDelay: Infinity }).on("line", (line) => {
+      if (!thread.job) {
+        return;
+      }
+      process.stderr.write(`${thread.job.id} ${line}\n`);
+      if (line === "__JOB_DONE__") {
+        thread.errDone = true;
+        finish(thread);
+      }
+    });
+
+    // A thread dies on uncaught errors or process.exit(), which fail its job
+    const fail = (message) => {
+      if (thread.job) {
+        process.stdout.write(`${thread.job.id} __FLOW_ERROR__ ${JSON.stringify(message)}\n`);
+        process.stderr.write(`${thread.job.id} __JOB_DONE__\n`);
+        thread.job = null;
+      }
+    };
+    worker.on("error", (error) => fail(error && error.stack ? error.stack : String(error)));
+    worker.on("exit", (code) => {
+      fail(`the flow exited its thread with code ${code}`);
+      // Cancelled threads are replaced right away
+      if (!threads.delete(thread)) {
+        return;
+      }
+      if (!closing) {
+        spawn();
+      }
+      dispatch();
+    });
+  };
+
+  const dispatch = () => {
+    for (const thread of threads) {
+      if (queue.length === 0) {
+        break;
+      }
+      if (!thread.job) {
+        thread.job = queue.shift();
+        thread.outDone = false;
+        thread.errDone = false;
+        thread.worker.postMessage(thread.job.job);
+      }
+    }
+    if (closing && queue.length === 0 && [...threads].every((thread) => !thread.job)) {
+      exit(0);
+    }
+  };
+
+  for (let i = 0; i < size; i++) {
+    spawn();
+  }
+  console.log("__WORKER_READY__");
+
+  for await (const line of readLines()) {
+    if (!line.trim()) {
+      continue;
+    }
+    const message = JSON.parse(line);
+    if (message.cancel !== undefined) {
+      const queued = queue.findIndex((job) => job.id === message.cancel);
+      if (queued >= 0) {
+        queue.splice(queued, 1);
+      }
+      for (const thread of threads) {
+        if (thread.job && thread.job.id === message.cancel) {
+          threads.delete(thread);
+          thread.job = null;
+          thread.worker.terminate();
+          spawn();
+          dispatch();
+        }
+      }
+      continue;
+    }
+    queue.push(message);
+    dispatch();
+  }
+
+  // Jobs already sent still run once stdin is closed
+  closing = true;
+  dispatch();
+}
+
+// runThread runs the jobs a thread host posts, one at a time
+function runThread() {
+  const { parentPort } = require("worker_threads");
+  parentPort.on("message", (job) => runJob(job));
+}
+
+(async () => {
+  try {
+    // Compiled executables get their own path as argv[1]
+    const args = isDeno ? Deno.args : process.argv.slice(globalThis.__k6_compiled_flow__ ? 2 : 1);
+    const [entryPath, payloadJson, execContextJson] = args;
+
+    if (isNode && !require("worker_threads").isMainThread) {
+      runThread();
+      return;
+    }
+    if (entryPath === "__worker__") {
+      await runWorker();
+      return;
+    }
+    if (entryPath === "__thread_host__") {
+      await runThreadHost(Number(payloadJson), execContextJson);
+      return;
+    }
+    // The startup probe only measures how long it takes to get here
+    if (entryPath === "__probe__") {
+      exit(0);
+      return;
+    }
+
+    if (!entryPath) {
+      throw new Error("Missing entry path argument");
+    }
+    if (!payloadJson) {
+      throw new Error("Missing payload JSON argument");
+    }
+
+    let executionContext = {};
+    if (execContextJson) {
+      executionContext = JSON.parse(execContextJson);
+    }
+
+    if (executionContext.autoInstrumentHttp) {
+      instrumentFetch();
+    }
+    if (executionContext.k6compat) {
+      installK6Compat();
+    }
+    if (executionContext.cookies) {
+      installCookieFetch();
+      jarCookies = executionContext.cookies;
+    }
+    if (executionContext.denyNet) {
+      await installNetworkGuard();
+      networkDenied = true;
+    }
+
+    const isCBOR = executionContext.format === "cbor";
+    let payload;
+    if (executionContext.compress) {
+      // The argument is the path of the gzipped payload
+      payload = await readCompressedPayload(payloadJson);
     } else {
-      process.exit(0);
+      payload = isCBOR ? cborDecode(base64ToBytes(payloadJson)) : JSON.parse(payloadJson);
     }
-  } catch (error) {
-    console.error("Error:", error && error.stack ? error.stack : String(error));
-    if (isDeno) {
-      Deno.exit(1);
+
+    if (executionContext.ready) {
+      // Starts the flowTimeout clock
+      console.log("__READY__ " + performance.now());
+    }
+    if (executionContext.heartbeat) {
+      startHeartbeat(executionContext.heartbeat);
+    }
+    const flowFunction = await loadFlow(entryPath, executionContext.fn);
+    heartbeat?.phase("run");
+    const result = await invokeFlow(flowFunction, executionContext, () => buildContext(payload, executionContext));
+    heartbeat?.stop();
+
+    if (executionContext.socket) {
+      await sendFrame(executionContext.socket, { type: "result", value: result || {} });
+    } else if (executionContext.protocol === "binary") {
+      await writeResultFrame(isCBOR ? cborEncode(result || {}) : new TextEncoder().encode(stringifyResult(result || {})));
     } else {
-      process.exit(1);
+      let encoded;
+      if (executionContext.compress) {
+        encoded = await compressResult(result || {});
+      } else {
+        encoded = isCBOR ? bytesToBase64(cborEncode(result || {})) : stringifyResult(result || {});
+      }
+      console.log("__RESULT_START__");
+      console.log(encoded);
+      console.log("__RESULT_END__");
+      await flushStdout();
     }
+
+    exit(0);
+  } catch (error) {
+    console.error("Error:", error && error.stack ? error.stack : String(error));
+    exit(1);
   }
 })();
-
@@ -0,0 +1,222 @@
+package js
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.k6.io/k6/metrics"
+)
+
+// logPrefix starts the stdout lines a flow prints with ctx.log
+const logPrefix = "__LOG__ "
+
+// logFrame is the JSON body of a __LOG__ line
+type logFrame struct {
+	Level  string                 `json:"level"`
+	Msg    string                 `json:"msg"`
+	Fields map[string]interface{} `json:"fields"`
+}
+
+// forwardLog returns the handler that writes the __LOG__ lines of entry to
+// k6's logger, so they reach the same outputs as the script's own logs
+// (--log-output, --log-format). Each entry gets the flow as a field.
+func (j *ExternalJS) forwardLog(opts *RunOptions) func([]byte) {
+	return func(body []byte) {
+		var frame logFrame
+		if err := json.Unmarshal(body, &frame); err != nil {
+			j.logger().Warnf("ignoring invalid log line from %s: %v", opts.Entry, err)
+			return
+		}
+		j.emitLog(opts, frame.Level, frame.Msg, frame.Fields)
+	}
+}
+
+// forwardJSONLog returns the handler for logFormat: "json", which writes
+// lines printed by logging libraries like pino and winston to k6's logger.
+// It reports whether the line was a log entry: a JSON object with a level
+// and a msg or message.
+func (j *ExternalJS) forwardJSONLog(opts *RunOptions) func([]byte) bool {
+	return func(line []byte) bool {
+		var fields map[string]interface{}
+		if err := json.Unmarshal(line, &fields); err != nil {
+			return false
+		}
+		level, ok := jsonLogLevel(fields["level"])
+		if !ok {
+			return false
+		}
+		msg, ok := fields["msg"].(string)
+		if !ok {
+			if msg, ok = fields["message"].(string); !ok {
+				return false
+			}
+		}
+
+		// The logger adds its own level, message and time
+		for _, key := range []string{"level", "msg", "message", "time", "timestamp"} {
+			delete(fields, key)
+		}
+		j.emitLog(opts, level, msg, fields)
+		return true
+	}
+}
+
+// jsonLogLevel maps the level of a JSON log line to debug, info, warn or
+// error. Levels are names, like winston's, or numbers, like pino's.
+func jsonLogLevel(raw interface{}) (string, bool) {
+	switch v := raw.(type) {
+	case float64:
+		// pino: trace 10, debug 20, info 30, warn 40, error 50, fatal 60
+		switch {
+		case v <= 20:
+			return "debug", true
+		case v < 40:
+			return "info", true
+		case v < 50:
+			return "warn", true
+		default:
+			return "error", true
+		}
+	case string:
+		switch strings.ToLower(v) {
+		case "trace", "debug", "verbose", "silly":
+			return "debug", true
+		case "info", "http", "notice":
+			return "info", true
+		case "warn", "warning":
+			return "warn", true
+		case "error", "fatal", "crit", "critical", "alert", "emerg":
+			return "error", true
+		}
+	}
+	return "", false
+}
+
+// emitLog writes a flow's log entry to k6's logger with the flow,
+// source=external_js and, with correlationId: true, the call's correlation_id
+// as fields. With logSamples, it's recorded as a sample too.
+func (j *ExternalJS) emitLog(opts *RunOptions, level, msg string, extra map[string]interface{}) {
+	if opts.LogSamples {
+		j.pushLog(opts, level, msg, extra)
+	}
+
+	fields := logrus.Fields{"source": "external_js", "flow": opts.Entry}
+	for k, v := range extra {
+		fields[k] = v
+	}
+	if opts.TagCorrelationID {
+		fields[correlationTag] = opts.correlationID
+	}
+	logger := j.logger().WithFields(fields)
+
+	switch level {
+	case "debug":
+		logger.Debug(msg)
+	case "warn":
+		logger.Warn(msg)
+	case "error":
+		logger.Error(msg)
+	default:
+		logger.Info(msg)
+	}
+}
+
+// pushLog records a flow's log entry as an external_js_logs sample tagged
+// with the flow, runtime and level, which are few, and the message and
+// fields as JSON in the sample's metadata, so outputs like --out json get
+// the entry without a time series per message
+func (j *ExternalJS) pushLog(opts *RunOptions, level, msg string, extra map[string]interface{}) {
+	state := j.metricsState()
+	if state == nil {
+		return
+	}
+	switch level {
+	case "debug", "warn", "error":
+	default:
+		level = "info"
+	}
+
+	metadata := map[string]string{"msg": msg}
+	if len(extra) > 0 {
+		if fields, err := json.Marshal(extra); err == nil {
+			metadata["fields"] = string(fields)
+		}
+	}
+	j.pushSample(state, metrics.Sample{
+		TimeSeries: metrics.TimeSeries{
+			Metric: j.flowLogs,
+			Tags: state.Tags.GetCurrentValues().Tags.WithTagsFromMap(
+				callTags(opts, map[string]string{"flow": opts.Entry, "runtime": opts.runtimeTag, "level": level}),
+			),
+		},
+		Time:     time.Now(),
+		Value:    1,
+		Metadata: metadata,
+	})
+}
+
+// jsonLogWriter removes the lines onLog accepts from the output passed to w.
+// Only lines starting with { are offered, and never the ones between the
+// result markers, since the result may look like a log entry too.
+type jsonLogWriter struct {
+	w     io.Writer
+	onLog func(line []byte) bool
+
+	line        []byte
+	passthrough bool
+	inResult    bool
+}
+
+func (l *jsonLogWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		chunk := p
+		if i := bytes.IndexByte(p, '\n'); i >= 0 {
+			chunk = p[:i+1]
+		}
+		p = p[len(chunk):]
+		complete := chunk[len(chunk)-1] == '\n'
+
+		if l.passthrough {
+			if _, err := l.w.Write(chunk); err != nil {
+				return n, err
+			}
+			l.passthrough = !complete
+			continue
+		}
+
+		l.line = append(l.line, chunk...)
+		// Markers and log entries are buffered until the line is complete
+		if first := l.line[0]; first != '{' && first != '_' {
+			if _, err := l.w.Write(l.line); err != nil {
+				return n, err
+			}
+			l.passthrough = !complete
+			l.line = l.line[:0]
+			continue
+		}
+		if !complete {
+			continue
+		}
+
+		trimmed := bytes.TrimRight(l.line, "\r\n")
+		switch {
+		case string(trimmed) == "__RESULT_START__":
+			l.inResult = true
+		case string(trimmed) == "__RESULT_END__":
+			l.inResult = false
+		case !l.inResult && trimmed[0] == '{' && l.onLog(trimmed):
+			l.line = l.line[:0]
+			continue
+		}
+		if _, err := l.w.Write(l.line); err != nil {
+			return n, err
+		}
+		l.line = l.line[:0]
+	}
+	return n, nil
+}
@@ -0,0 +1,40 @@
+package js
+
+import (
+	"testing"
+
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestForwardLogSamples(t *testing.T) {
+	for _, logSamples := range []bool{false, true} {
+		j, state, samples := newTestInstance(t)
+		logger, hook := logtest.NewNullLogger()
+		state.Logger = logger
+
+		opts := &RunOptions{Entry: "flow.js", runtimeTag: "node", LogSamples: logSamples}
+		j.forwardLog(opts)([]byte(`{"level":"warn","msg":"inventory is low","fields":{"sku":"A1"}}`))
+
+		if len(hook.AllEntries()) != 1 {
+			t.Errorf("logSamples %v: logged %d entries, want 1", logSamples, len(hook.AllEntries()))
+		}
+
+		var logs int
+		for _, sample := range drainSamples(samples) {
+			if sample.Metric.Name != "external_js_logs" {
+				continue
+			}
+			logs++
+			tags := sample.Tags.Map()
+			if tags["flow"] != "flow.js" || tags["runtime"] != "node" || tags["level"] != "warn" {
+				t.Errorf("unexpected tags %v", tags)
+			}
+			if sample.Metadata["msg"] != "inventory is low" || sample.Metadata["fields"] != `{"sku":"A1"}` {
+				t.Errorf("unexpected metadata %v", sample.Metadata)
+			}
+		}
+		if want := map[bool]int{false: 0, true: 1}[logSamples]; logs != want {
+			t.Errorf("logSamples %v: pushed %d log samples, want %d", logSamples, logs, want)
+		}
+	}
+}
@@ -1,53 +1,228 @@
 package js
 
 import (
+	"bytes"
 	"context"
 	_ "embed"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/grafana/sobek"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/sirupsen/logrus"
+	"go.k6.io/k6/errext"
+	"go.k6.io/k6/js/common"
 	"go.k6.io/k6/js/modules"
+	"go.k6.io/k6/js/promises"
+	"go.k6.io/k6/lib"
 	"go.k6.io/k6/metrics"
+	"golang.org/x/text/encoding"
 )
 
 //go:embed js_runner.js
 var runnerScript string
 
+// rootModule is the module instance registered with k6
+var rootModule = &ExternalJSModule{}
+
 // init is called by the Go runtime at application startup.
 func init() {
-	modules.Register("k6/x/external_js", &ExternalJSModule{})
+	modules.Register("k6/x/external_js", rootModule)
 }
 
 // ExternalJSModule is the root module for the external JavaScript runtime interop extension
-type ExternalJSModule struct{}
+type ExternalJSModule struct {
+	shared     sharedRegistry
+	versions   versionCache
+	pool       workerRegistry
+	limiters   rateLimiters
+	budgets    runBudgets
+	once       onceCache
+	dropped    droppedSamples
+	bun        bunArtifacts
+	sinks      statsdSinks
+	setup      setupStore
+	transform  resultTransform
+	probe      startupProbe
+	recordings recorder
+	remote     remoteFlows
+	archives   archiveFlows
+	processes  processTracker
+	threads    threadHosts
+	hooks      hookRegistry
+	events     flowEvents
+	metadata   runMetadata
+
+	exitOnce sync.Once
+}
 
 // NewModuleInstance creates a new instance of the module for each VU
-func (*ExternalJSModule) NewModuleInstance(vu modules.VU) modules.Instance {
+func (m *ExternalJSModule) NewModuleInstance(vu modules.VU) modules.Instance {
 	registry := vu.InitEnv().Registry
+	var k6Env map[string]string
+	if vu.InitEnv().TestPreInitState != nil {
+		k6Env = vu.InitEnv().RuntimeOptions.Env
+	}
+	m.processes.register(registry)
+	m.subscribeExit(vu)
 
 	return &ExternalJS{
+		module:              m,
 		vu:                  vu,
 		jsIterationDuration: registry.MustNewMetric("external_js_iteration_duration", metrics.Trend, metrics.Time),
 		jsIterations:        registry.MustNewMetric("external_js_iterations", metrics.Counter),
 		customMetrics:       make(map[string]*metrics.Metric),
+		resultSchemas:       make(map[string]*jsonschema.Schema),
+		workerSpawnDuration: registry.MustNewMetric("external_js_worker_spawn_duration", metrics.Trend, metrics.Time),
+		workers:             make(map[string]*worker),
+		throttleWait:        registry.MustNewMetric("external_js_throttle_wait", metrics.Trend, metrics.Time),
+		runsRemaining:       registry.MustNewMetric("external_js_runs_remaining", metrics.Gauge),
+		droppedSamples:      registry.MustNewMetric("external_js_dropped_samples", metrics.Counter),
+		runtimeStartup:      registry.MustNewMetric("external_js_runtime_startup", metrics.Trend, metrics.Time),
+		outputLimitHits:     registry.MustNewMetric("external_js_output_limit_exceeded", metrics.Counter),
+		success:             registry.MustNewMetric("external_js_success", metrics.Rate),
+		exitCode:            registry.MustNewMetric("external_js_exit_code", metrics.Trend),
+		importTime:          registry.MustNewMetric("external_js_import_time", metrics.Trend, metrics.Time),
+		flowStatus:          registry.MustNewMetric("external_js_status", metrics.Rate),
+		flowEvents:          registry.MustNewMetric("external_js_events", metrics.Counter),
+		metadataValues:      registry.MustNewMetric("external_js_run_metadata", metrics.Counter),
+		flowLogs:            registry.MustNewMetric("external_js_logs", metrics.Counter),
+		k6Env:               k6Env,
+		maxCustomMetrics:    defaultMaxCustomMetrics,
 		registry:            registry,
 	}
 }
 
+// subscribeExit runs the setup hooks when the test starts. When the test
+// ends, it runs the teardown hooks and kills the flow processes still
+// running. When k6 emits its exit event, it shuts the workers down, removes
+// compiled and fetched flows, flushes the metrics sinks and the recording,
+// and reports dropped samples. Only the first VU subscribes, since all of these
+// are shared by every VU.
+func (m *ExternalJSModule) subscribeExit(vu modules.VU) {
+	m.exitOnce.Do(func() {
+		events := vu.Events().Global
+		if events == nil {
+			return
+		}
+		logger := vu.InitEnv().Logger
+
+		subID, eventsCh := events.Subscribe(k6TestStartEvent, k6TestEndEvent, k6ExitEvent)
+		go func() {
+			for e := range eventsCh {
+				if e.Type == k6TestStartEvent {
+					m.hooks.runAll(false, logger)
+					e.Done()
+					continue
+				}
+				if e.Type == k6TestEndEvent {
+					// Before the sweep, which would kill their processes
+					m.hooks.runAll(true, logger)
+					// Samples can still be pushed until the exit event
+					if orphans := m.processes.sweep(); len(orphans) > 0 {
+						if logger != nil {
+							logger.Warnf("external_js: killed %d flow processes still running at the end of the test, "+
+								"see external_js_orphaned", len(orphans))
+						}
+						m.processes.report(orphans)
+					}
+					e.Done()
+					continue
+				}
+
+				// Processes started after the test ended, e.g. by handleSummary()
+				m.processes.sweep()
+				stopped, killed := m.pool.shutdown(workerShutdownGrace)
+				m.bun.cleanup()
+				m.remote.cleanup()
+				m.archives.cleanup()
+				m.shared.cleanup()
+				sinkDropped := m.sinks.close()
+				recordErr := m.recordings.close()
+				if logger != nil {
+					if killed > 0 {
+						logger.Warnf("external_js: %d of %d workers did not exit within %s and were killed",
+							killed, stopped+killed, workerShutdownGrace)
+					}
+					if sinkDropped > 0 {
+						logger.Warnf("external_js: %d samples weren't forwarded to the metricsSink because its queue was full",
+							sinkDropped)
+					}
+					if recordErr != nil {
+						logger.Warnf("external_js: failed to close the recording: %v", recordErr)
+					}
+					if metadata := m.metadata.String(); metadata != "" {
+						logger.Infof("external_js: run metadata: %s", metadata)
+					}
+					if dropped := m.dropped.total.Load(); dropped > 0 {
+						logger.Warnf("external_js: %d samples from flows were dropped because their VU "+
+							"was stopping, see external_js_dropped_samples", dropped)
+					}
+				}
+				e.Done()
+				events.Unsubscribe(subID)
+			}
+		}()
+	})
+}
+
 // ExternalJS is the type for our external JavaScript runtime interop API.
 type ExternalJS struct {
+	module              *ExternalJSModule
 	vu                  modules.VU
 	jsIterationDuration *metrics.Metric
 	jsIterations        *metrics.Metric
 	customMetrics       map[string]*metrics.Metric
 	registry            *metrics.Registry
+	resultSchemas       map[string]*jsonschema.Schema
+	workerSpawnDuration *metrics.Metric
+	workers             map[string]*worker
+	throttleWait        *metrics.Metric
+	runsRemaining       *metrics.Metric
+	droppedSamples      *metrics.Metric
+	runtimeStartup      *metrics.Metric
+	outputLimitHits     *metrics.Metric
+	success             *metrics.Metric
+	exitCode            *metrics.Metric
+	importTime          *metrics.Metric
+	flowStatus          *metrics.Metric
+	flowEvents          *metrics.Metric
+	metadataValues      *metrics.Metric
+	flowLogs            *metrics.Metric
+	// k6Env holds the variables of k6's __ENV, for entry templates
+	k6Env map[string]string
+	// clock is the "now" of the VU's current iteration
+	clock struct {
+		scenario  string
+		iteration int64
+		now       int64
+	}
+
+	// mu guards the caches and workers, since runAsync calls use them concurrently
+	mu sync.Mutex
+
+	// maxCustomMetrics caps the distinct custom metric names flows can create
+	maxCustomMetrics      int
+	customMetricsLimitHit bool
 }
 
+// defaultMaxCustomMetrics is the default cap on distinct custom metric names per VU
+const defaultMaxCustomMetrics = 1000
+
 // Exports returns the exports of the module
 func (j *ExternalJS) Exports() modules.Exports {
 	return modules.Exports{
@@ -55,6 +230,64 @@ func (j *ExternalJS) Exports() modules.Exports {
 	}
 }
 
+// CustomMetrics returns the names of the custom metrics flows have created in this VU
+func (j *ExternalJS) CustomMetrics() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	names := make([]string, 0, len(j.customMetrics))
+	for name := range j.customMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ClearCustomMetrics empties this VU's custom metric cache. The metrics stay
+// registered in k6, so flows can keep emitting them.
+func (j *ExternalJS) ClearCustomMetrics() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.customMetrics = make(map[string]*metrics.Metric)
+	j.customMetricsLimitHit = false
+}
+
+// SetMaxCustomMetrics changes how many distinct custom metric names flows can
+// create in this VU (1000 by default). Samples for new names past the limit
+// are dropped with a warning, protecting k6 from flows generating names dynamically.
+func (j *ExternalJS) SetMaxCustomMetrics(limit int) error {
+	if limit < 1 {
+		return fmt.Errorf("custom metric limit must be positive, got %d", limit)
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.maxCustomMetrics = limit
+	return nil
+}
+
+// logger returns the VU's logger, or the init logger outside of iterations
+func (j *ExternalJS) logger() logrus.FieldLogger {
+	if state := j.vu.State(); state != nil && state.Logger != nil {
+		return state.Logger
+	}
+	if initEnv := j.vu.InitEnv(); initEnv != nil && initEnv.Logger != nil {
+		return initEnv.Logger
+	}
+	return logrus.StandardLogger()
+}
+
+// metricsState returns the VU state if samples can be pushed, or nil in the
+// init context and anywhere else without a samples channel, since pushing to
+// a nil channel would block forever.
+func (j *ExternalJS) metricsState() *lib.State {
+	state := j.vu.State()
+	if state == nil || state.Samples == nil {
+		return nil
+	}
+	return state
+}
+
 // getExecutionContext extracts k6 execution context from VU state
 func (j *ExternalJS) getExecutionContext() map[string]interface{} {
 	state := j.vu.State()
@@ -64,6 +297,8 @@ func (j *ExternalJS) getExecutionContext() map[string]interface{} {
 				"id":        int64(0),
 				"iteration": int64(0),
 			},
+			"seed": iterationSeed(0, 0),
+			"now":  time.Now().UnixMilli(),
 		}
 	}
 
@@ -76,13 +311,42 @@ func (j *ExternalJS) getExecutionContext() map[string]interface{} {
 		}
 	}
 
+	// setup() and teardown() run on VU 0, outside of iterations
+	now := time.Now().UnixMilli()
+	if state.VUID != 0 {
+		now = j.iterationNow(scenario, state.Iteration)
+	}
+
 	return map[string]interface{}{
 		"vu": map[string]interface{}{
 			"id":        int64(state.VUID),
 			"iteration": int64(state.Iteration),
 			"scenario":  scenario,
 		},
+		"seed": iterationSeed(state.VUID, state.Iteration),
+		"now":  now,
+	}
+}
+
+// iterationNow returns the time of the VU's first call in the iteration, in
+// epoch milliseconds, so all flows of an iteration share the same "now"
+func (j *ExternalJS) iterationNow(scenario string, iteration int64) int64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.clock.scenario != scenario || j.clock.iteration != iteration || j.clock.now == 0 {
+		j.clock.scenario, j.clock.iteration, j.clock.now = scenario, iteration, time.Now().UnixMilli()
 	}
+	return j.clock.now
+}
+
+// iterationSeed derives a deterministic 32-bit seed from the VU id and
+// iteration, so randomness-driven flows can be reproduced when debugging.
+func iterationSeed(vuID uint64, iteration int64) uint32 {
+	h := fnv.New32a()
+	_ = binary.Write(h, binary.LittleEndian, vuID)
+	_ = binary.Write(h, binary.LittleEndian, iteration)
+	return h.Sum32()
 }
 
 // RunOptions represents the internal options we derive from ext.run(...)
@@ -90,10 +354,208 @@ type RunOptions struct {
 	Runtime string            `json:"runtime"`
 	Entry   string            `json:"entry"`
 	Payload interface{}       `json:"payload"`
+	Meta    interface{}       `json:"meta"`
 	Env     map[string]string `json:"env"`
 	Timeout string            `json:"timeout"`
+	LogDir  string            `json:"logDir"`
+	Shared  []string          `json:"shared"`
+	// Fn names the export to invoke instead of the handler or default export
+	Fn string `json:"fn"`
+	// Args and Kwargs are passed to the fn export as its parameters, the
+	// positional args followed by the kwargs object, instead of ctx
+	Args   []interface{}          `json:"args"`
+	Kwargs map[string]interface{} `json:"kwargs"`
+	// ResultSchema is an optional JSON Schema the result must conform to
+	ResultSchema interface{} `json:"resultSchema"`
+	// CaptureStderr adds __stderr__ and __had_stderr__ to the result
+	CaptureStderr bool `json:"captureStderr"`
+	// CommandWrapper is prepended to the runtime command, e.g. ["/usr/bin/time", "-v"]
+	CommandWrapper []string `json:"commandWrapper"`
+	// EnvStrip removes inherited variables whose names start with any of these prefixes
+	EnvStrip []string `json:"envStrip"`
+	// MinVersion is the minimum runtime version required, keyed by runtime.
+	// A plain string in the options object applies to the selected runtime.
+	MinVersion map[string]string `json:"minVersion"`
+	// SeedEnv names an env var that receives the iteration seed
+	SeedEnv string `json:"seedEnv"`
+	// Transport is how the result is sent back: "stdout" (default) or "socket"
+	Transport string `json:"transport"`
+	// Protocol is how the result is framed on stdout: "text" (default), between
+	// marker lines, or "binary", as a length-prefixed frame
+	Protocol string `json:"protocol"`
+	// Format is the payload and result encoding: "json" (default) or "cbor"
+	Format string `json:"format"`
+	// NumberMode is how numbers in the result reach the script: "number"
+	// (default) as float64, "string" with all their digits, or "bigint" with
+	// the integers past 2^53 as BigInt
+	NumberMode string `json:"numberMode"`
+	// AutoInstrumentHTTP records metrics for every fetch() call the flow makes
+	AutoInstrumentHTTP bool `json:"autoInstrumentHttp"`
+	// PersistPerVU runs the flow in a long-lived worker owned by the VU
+	PersistPerVU bool `json:"persistPerVU"`
+	// Threads runs the flow on a pool of this many worker_threads in a Node.js
+	// process shared by all VUs, instead of a worker per VU
+	Threads int `json:"threads"`
+	// Watch recycles the VU's worker when the entry file changes (dev only)
+	Watch bool `json:"watch"`
+	// EnvFile is a .env file merged into the env beneath the explicit Env map
+	EnvFile string `json:"envFile"`
+	// MaxResultBytes caps how much output is buffered for the result.
+	// Zero means defaultMaxResultBytes.
+	MaxResultBytes int64 `json:"maxResultBytes"`
+	// K6Compat provides a k6-like API (check, group, sleep, k6/metrics) to flows
+	K6Compat bool `json:"k6compat"`
+	// Stdin is piped to the runtime process as its standard input
+	Stdin []byte `json:"stdin"`
+	// Debug is the inspector address the runtime waits on for a debugger (dev only)
+	Debug string `json:"debug"`
+	// Files are written to a temp directory, keyed by relative path, before the flow runs
+	Files map[string][]byte `json:"files"`
+	// RateLimit caps how often the entry can be invoked across all VUs
+	RateLimit *RateLimitOptions `json:"rateLimit"`
+	// MaxRuns caps how many times the entry is run across all VUs and the
+	// whole test
+	MaxRuns *MaxRunsOptions `json:"maxRuns"`
+	// RuntimeFallback lists runtimes in order of preference; the first installed one is used
+	RuntimeFallback []string `json:"runtimeFallback"`
+	// TagRuntimeVersion appends the installed version to the runtime tag, e.g. node@20.11.0
+	TagRuntimeVersion bool `json:"tagRuntimeVersion"`
+
+	// Compress gzips the payload (passed in a temp file) and the result
+	Compress bool `json:"compress"`
+	// StrictStderr fails the call if the flow writes anything to stderr
+	StrictStderr bool `json:"strictStderr"`
+	// StderrIgnore matches stderr lines that don't count for StrictStderr
+	StderrIgnore *regexp.Regexp `json:"-"`
+	// Ack lets the flow return early with ctx.ack(value) while it keeps running
+	Ack bool `json:"ack"`
+	// MetricsSink also forwards custom metrics to statsd://host:port or dogstatsd://host:port
+	MetricsSink string `json:"metricsSink"`
+	// SetupData is delivered as ctx.setupData instead of the result of the
+	// last flow run in setup()
+	SetupData interface{} `json:"setupData"`
+	// Retries is how many more times a failing call is run
+	Retries int `json:"retries"`
+	// RetryBackoff is the wait before the first retry, doubling for every
+	// retry after that. Zero means defaultRetryBackoff.
+	RetryBackoff time.Duration `json:"retryBackoff"`
+	// RetryOn only retries failures that match it, instead of all of them
+	RetryOn *RetryOnOptions `json:"retryOn"`
+	// TotalTimeout caps the whole call, including all retries and backoff
+	TotalTimeout time.Duration `json:"totalTimeout"`
+	// StartupTimeout caps the time from starting the runtime to the runner
+	// being ready to load the flow
+	StartupTimeout time.Duration `json:"startupTimeout"`
+	// FlowTimeout caps the time from the runner being ready to the flow's
+	// result, including importing the flow
+	FlowTimeout time.Duration `json:"flowTimeout"`
+	// CPUAffinity pins the runtime process to these CPUs (Linux only)
+	CPUAffinity []int `json:"cpuAffinity"`
+	// Encoding is the character encoding of the flow's stdout and stderr, as a
+	// WHATWG label. Results are always UTF-8.
+	Encoding string `json:"encoding"`
+	// Heartbeat is how often the runner reports the flow's phase, for timeout errors
+	Heartbeat time.Duration `json:"heartbeat"`
+	// BunCompile runs the flow from an executable built once with bun build --compile
+	BunCompile bool `json:"bunCompile"`
+	// Integrity is the subresource integrity hash a remote entry must match,
+	// e.g. "sha256-<base64 digest>"
+	Integrity string `json:"integrity"`
+	// Install runs npm ci or bun install in an archive entry with a lockfile
+	// before its first call
+	Install bool `json:"install"`
+	// Container runs the runtime inside a container image instead of on the host
+	Container *ContainerOptions `json:"container"`
+	// OnlyVU runs the flow only on the VU with this ID
+	OnlyVU int `json:"onlyVU"`
+	// EveryNIterations runs the flow only on every Nth iteration of each VU,
+	// starting with the first
+	EveryNIterations int `json:"everyNIterations"`
+	// CookieJar lists URLs whose cookies in the VU's cookie jar are sent by
+	// the flow's fetch() calls
+	CookieJar []*url.URL `json:"cookieJar"`
+	// Select trims the result to these keys, which may be dotted paths
+	Select []string `json:"select"`
+	// MaxOutputBytes and MaxOutputLines cap stdout and stderr together. The
+	// process is killed once either is exceeded. Zero means no limit.
+	MaxOutputBytes int64 `json:"maxOutputBytes"`
+	MaxOutputLines int64 `json:"maxOutputLines"`
+	// DenyNetwork blocks the flow's network access, set with network: false
+	DenyNetwork bool `json:"-"`
+	// FailOnError makes calls whose result has a __k6_error__ return an error
+	FailOnError bool `json:"failOnError"`
+	// LogFormat is "json" to forward JSON log lines on stdout, like pino's
+	// and winston's, to k6's logger
+	LogFormat string `json:"logFormat"`
+	// LogSamples also records the flow's log entries as external_js_logs
+	// samples, so they reach k6's outputs
+	LogSamples bool `json:"logSamples"`
+	// OmitContext leaves the VU, seed and now out of the execution context,
+	// set with passContext: false
+	OmitContext bool `json:"-"`
+	// ArtifactsDir is where the files listed in a result's __attachments__
+	// are moved to
+	ArtifactsDir string `json:"artifactsDir"`
+	// Umask is applied to the runtime process, so the files it creates don't
+	// get broader permissions than that. Not supported on Windows.
+	Umask *uint32 `json:"umask"`
+	// TagCorrelationID tags every sample and log entry of a call with its
+	// correlation ID, set with correlationId: true
+	TagCorrelationID bool `json:"correlationId"`
+	// ReadOnlyFS limits the flow to reading these paths and denies writes,
+	// for node and deno. nil means no limit.
+	ReadOnlyFS []string `json:"readOnlyFS"`
+	// OTLPReceiver starts an OTLP/HTTP endpoint for the call and turns the
+	// metrics the flow exports to it into k6 samples
+	OTLPReceiver bool `json:"otlpReceiver"`
+	// Profile is the kind of profile to record of the flow, only "cpu" for
+	// now, saved to ArtifactsDir
+	Profile string `json:"profile"`
+
+	// runtimeTag is the value of the runtime tag on pushed metrics
+	runtimeTag string
+	// entryPath is the file the runtime loads, which is a local copy of Entry
+	// when it's a URL
+	entryPath string
+	// compiledFlow is the executable built for BunCompile
+	compiledFlow string
+	// outputEncoding decodes stdout and stderr when Encoding is set
+	outputEncoding encoding.Encoding
+	// deadline is when the TotalTimeout budget runs out
+	deadline time.Time
+	// onAck receives the acknowledgment printed by the flow when Ack is set
+	onAck func(string)
+	// softFailed is set when the result had a __k6_error__
+	softFailed bool
+	// thinkTime is how long the VU sleeps after the call, from __k6_sleep__
+	thinkTime time.Duration
+	// correlationID identifies the call, see callTags
+	correlationID string
+	// readPaths are the paths readable with readOnlyFS, which include the
+	// ones the runner needs, like the entry's directory
+	readPaths []string
+	// profileDir is the temporary directory the runtime writes the profile to
+	profileDir string
+	// stdinPipe is the read end of the pipe RunStreaming writes chunks to,
+	// the process's stdin instead of Stdin
+	stdinPipe *os.File
 }
 
+// defaultDebugAddress is the inspector address used for debug: true
+const defaultDebugAddress = "127.0.0.1:9229"
+
+// defaultMaxResultBytes is the default cap on buffered flow output
+const defaultMaxResultBytes = 256 << 20
+
+// optionMarkerKeys are the keys that mark the second argument to ext.run() as
+// an options object rather than a plain payload. The other options are only
+// read from an object that has one of them, so payloads can use keys like
+// debug or format.
+var optionMarkerKeys = []string{"payload", "env", "timeout", "runtime"}
+
+// runOptionKeys are the options ext.run() reads from an options object
+var runOptionKeys = []string{"payload", "env", "timeout", "runtime", "logDir", "shared", "resultSchema", "captureStderr", "commandWrapper", "envStrip", "minVersion", "seedEnv", "transport", "format", "autoInstrumentHttp", "persistPerVU", "watch", "envFile", "maxResultBytes", "k6compat", "stdin", "debug", "files", "rateLimit", "runtimeFallback", "tagRuntimeVersion", "ack", "compress", "strictStderr", "meta", "bunCompile", "metricsSink", "fn", "heartbeat", "encoding", "setupData", "cpuAffinity", "retries", "retryBackoff", "totalTimeout", "integrity", "container", "onlyVU", "everyNIterations", "cookieJar", "select", "maxOutputBytes", "maxOutputLines", "threads", "network", "failOnError", "logFormat", "passContext", "artifactsDir", "umask", "correlationId", "strictOptions", "readOnlyFS", "otlpReceiver", "args", "kwargs", "profile", "startupTimeout", "flowTimeout", "protocol", "retryOn", "install", "maxRuns", "numberMode", "logSamples"}
+
 // Run executes an external JavaScript flow and returns the result.
 //
 // Supports both:
@@ -106,7 +568,69 @@ type RunOptions struct {
 //	  payload: { user: "alice" },
 //	  env: { NODE_ENV: "production" },
 //	  timeout: "5s",
-//	  runtime: "node", // "node", "deno", or "bun"
+//	  runtime: "node", // "node", "deno", "bun", or "workerd"
+//	  logDir: "./js-logs", // optional, writes full output per invocation
+//	  shared: ["users"], // optional, datasets registered with ext.shared()
+//	  resultSchema: { type: "object", required: ["token"] }, // optional
+//	  captureStderr: true, // optional, adds __stderr__ and __had_stderr__
+//	  commandWrapper: ["/usr/bin/time", "-v"], // optional, prefix for the runtime command
+//	  envStrip: ["K6_", "AWS_"], // optional, inherited env prefixes to drop
+//	  minVersion: "18.0.0", // optional, or { node: "18.0.0", deno: "1.40.0" }
+//	  seedEnv: "SEED", // optional, env var that receives the iteration seed
+//	  transport: "socket", // optional, "stdout" (default) or "socket"
+//	  format: "cbor", // optional, "json" (default) or "cbor"
+//	  autoInstrumentHttp: true, // optional, records metrics for every fetch()
+//	  persistPerVU: true, // optional, reuses one runtime process per VU
+//	  threads: 8, // optional, node only, runs on 8 worker_threads shared by all VUs
+//	  envFile: "./flow.env", // optional, KEY=VALUE lines merged beneath env
+//	  maxResultBytes: 1048576, // optional, output limit (256 MiB by default)
+//	  k6compat: true, // optional, lets flows import a k6-like API
+//	  stdin: "line 1\nline 2\n", // optional, string or ArrayBuffer piped to the process
+//	  debug: true, // optional, waits for a debugger (or "host:port"), dev only
+//	  files: { "fixtures/data.json": "{}" }, // optional, written to ctx.filesDir
+//	  rateLimit: { rps: 50, mode: "wait" }, // optional, calls/s for the entry across all VUs
+//	  maxRuns: { limit: 1000, mode: "error" }, // optional, runs of the entry across all VUs and the whole test
+//	  runtimeFallback: ["bun", "deno", "node"], // optional, uses the first installed runtime
+//	  tagRuntimeVersion: true, // optional, tags metrics with runtime "node@20.11.0"
+//	  ack: true, // optional, returns the value of ctx.ack() while the flow keeps running
+//	  compress: true, // optional, gzips the payload and result
+//	  strictStderr: true, // optional, fails on any stderr output, or { ignore: "regex" }
+//	  meta: { traceId: "abc" }, // optional, control metadata delivered as ctx.meta
+//	  bunCompile: true, // optional, bun only, compiles the flow once with bun build --compile
+//	  metricsSink: "dogstatsd://127.0.0.1:8125", // optional, also forwards custom metrics
+//	  fn: "checkout", // optional, export to invoke, same as "lib.js#checkout"
+//	  heartbeat: true, // optional, or "500ms", reports the ctx.phase() a timeout hit
+//	  encoding: "latin1", // optional, encoding of stdout and stderr (UTF-8 by default)
+//	  setupData: data, // optional, ctx.setupData, defaults to the result of flows run in setup()
+//	  cpuAffinity: [0, 1, 2, 3], // optional, Linux only, pins the process to these CPUs
+//	  retries: 2, // optional, runs a failing call up to 2 more times
+//	  retryBackoff: "200ms", // optional, first wait between attempts, doubling after (100ms)
+//	  retryOn: { exitCodes: [75], stderrPattern: "ECONNRESET" }, // optional, only retries failures that match
+//	  totalTimeout: "10s", // optional, caps the whole call including retries
+//	  integrity: "sha256-...", // optional, hash a remote (https://) entry must match
+//	  install: true, // optional, runs npm ci or bun install in an archive (.tgz, .zip) entry first
+//	  container: "node:20-alpine", // optional, runs the runtime in this image with docker or podman
+//	  onlyVU: 1, // optional, runs the flow only on VU 1, other calls return { __skipped__: true }
+//	  everyNIterations: 10, // optional, runs the flow only on iterations 0, 10, 20, ... of each VU
+//	  cookieJar: "https://api.example.com", // optional, fetch() sends the VU's cookies for these URLs
+//	  select: ["userId", "order.status"], // optional, returns only these keys of the result
+//	  maxOutputBytes: 10485760, maxOutputLines: 10000, // optional, kill flows that print more than this
+//	  network: false, // optional, makes the flow's network calls fail
+//	  failOnError: true, // optional, throws if the result has a __k6_error__
+//	  logFormat: "json", // optional, forwards pino/winston JSON log lines to k6's logger
+//	  logSamples: true, // optional, also records the flow's logs as external_js_logs samples for --out
+//	  passContext: false, // optional, leaves ctx.vu, ctx.seed and ctx.now out
+//	  artifactsDir: "./artifacts", // optional, where __attachments__ files are moved to
+//	  umask: "077", // optional, not on Windows, umask of the runtime process
+//	  correlationId: true, // optional, tags the call's samples and logs with ctx.correlationId
+//	  strictOptions: true, // optional, rejects unknown options and values of the wrong type
+//	  readOnlyFS: ["./flows", "./fixtures"], // optional, node and deno only, the only paths the flow may read
+//	  otlpReceiver: true, // optional, k6 samples from the metrics the flow exports over OTLP/HTTP
+//	  args: ["alice", 30], kwargs: { admin: true }, // optional, the parameters of the fn export
+//	  profile: "cpu", // optional, node only, saves a CPU profile of the flow to artifactsDir
+//	  startupTimeout: "2s", flowTimeout: "5s", // optional, time the runtime's start and the flow separately
+//	  protocol: "binary", // optional, sends the result as a length-prefixed frame instead of between markers
+//	  numberMode: "bigint", // optional, "number" (default), "string" or "bigint", how the result's numbers are decoded
 //	})
 //
 // Runtime auto-detection: If runtime is not explicitly set, it will be
@@ -114,6 +638,7 @@ type RunOptions struct {
 //   - *.node.js or *.node.ts → "node"
 //   - *.deno.js or *.deno.ts → "deno"
 //   - *.bun.js or *.bun.ts → "bun"
+//   - *.worker.js or *.worker.ts → "workerd"
 //
 // If no pattern matches, defaults to "node".
 func (j *ExternalJS) Run(flowPath string, payloadOrOptions interface{}) (map[string]interface{}, error) {
@@ -121,6 +646,139 @@ func (j *ExternalJS) Run(flowPath string, payloadOrOptions interface{}) (map[str
 	if err != nil {
 		return nil, err
 	}
+	return j.execute(flowPath, opts)
+}
+
+// RunAsync is like Run but returns a promise, so the VU can do other work
+// (including other runAsync calls) while the flow runs:
+//
+//	const [a, b] = await Promise.all([
+//	  ext.runAsync("./a.js", { payload: {} }),
+//	  ext.runAsync("./b.js", { payload: {} }),
+//	]);
+//
+// The flow runs on its own goroutine and the promise is settled on k6's
+// event loop once it finishes. Calls using persistPerVU share the VU's worker,
+// so they still run one at a time.
+func (j *ExternalJS) RunAsync(flowPath string, payloadOrOptions interface{}) *sobek.Promise {
+	promise, resolve, reject := promises.New(j.vu)
+
+	// Options are read here, since JS values must not be used off the event loop
+	opts, err := parseRunOptionsFromArgs(flowPath, payloadOrOptions)
+	if err != nil {
+		reject(err)
+		return promise
+	}
+
+	go func() {
+		result, err := j.execute(flowPath, opts)
+		if err != nil {
+			reject(err)
+			return
+		}
+		resolve(result)
+	}()
+
+	return promise
+}
+
+// execute runs a flow with already parsed options
+func (j *ExternalJS) execute(flowPath string, opts *RunOptions) (map[string]interface{}, error) {
+	if skipCall(j.vu.State(), opts) {
+		return map[string]interface{}{skippedKey: true}, nil
+	}
+	if opts.Ack {
+		return j.runWithAck(flowPath, opts)
+	}
+	if opts.Retries > 0 || opts.TotalTimeout > 0 {
+		return j.runWithRetries(flowPath, opts)
+	}
+	return j.run(flowPath, opts)
+}
+
+// run executes a flow with already parsed options until it exits
+func (j *ExternalJS) run(flowPath string, opts *RunOptions) (result map[string]interface{}, err error) {
+	opts.correlationID = newCorrelationID()
+
+	if opts.EnvFile != "" {
+		fileEnv, err := loadEnvFile(opts.EnvFile)
+		if err != nil {
+			return nil, err
+		}
+		// Explicit env values win over the ones from the file
+		for k, v := range opts.Env {
+			fileEnv[k] = v
+		}
+		opts.Env = fileEnv
+	}
+
+	if opts.Entry != "" {
+		if opts.Entry, err = expandEntry(opts.Entry, opts.Env, j.k6Env); err != nil {
+			return nil, err
+		}
+	}
+
+	entry := opts.Entry
+	if entry == "" {
+		entry = flowPath
+	}
+	record, replay, err := j.module.recordings.begin(entry, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// The think time the flow returned starts once the call is recorded
+	defer func() { j.thinkTime(opts.thinkTime) }()
+
+	// Calls that got as far as running the flow count in external_js_success
+	var ran bool
+	defer func() {
+		if ran {
+			j.pushSuccess(opts, err == nil && !opts.softFailed)
+		}
+	}()
+
+	if replay != nil {
+		ran = true
+		// Served without the runtime, which may not even be installed
+		opts.Entry, opts.Runtime, opts.runtimeTag = entry, replay.Runtime, replay.RuntimeTag
+		result, err := replay.result()
+		if err != nil {
+			return nil, err
+		}
+		return j.processResult(opts, result, replay.Stderr)
+	}
+	if record != nil {
+		defer func() {
+			if saveErr := j.module.recordings.save(record, opts, err); saveErr != nil {
+				j.logger().Warnf("%v", saveErr)
+			}
+		}()
+	}
+
+	validRuntimes := map[string]bool{"node": true, "deno": true, "bun": true, "workerd": true}
+
+	if len(opts.RuntimeFallback) > 0 {
+		candidates := opts.RuntimeFallback
+		if opts.Runtime != "" {
+			// An explicit runtime is the first preference
+			candidates = append([]string{opts.Runtime}, candidates...)
+		}
+
+		opts.Runtime = ""
+		for _, runtime := range candidates {
+			if !validRuntimes[runtime] {
+				return nil, fmt.Errorf("unsupported runtime %q in runtimeFallback (supported: node, deno, bun, workerd)", runtime)
+			}
+			if j.module.versions.available(runtime) {
+				opts.Runtime = runtime
+				break
+			}
+		}
+		if opts.Runtime == "" {
+			return nil, fmt.Errorf("none of the runtimes %s is installed", strings.Join(candidates, ", "))
+		}
+	}
 
 	if opts.Runtime == "" {
 		filenameToCheck := opts.Entry
@@ -134,280 +792,1926 @@ func (j *ExternalJS) Run(flowPath string, payloadOrOptions interface{}) (map[str
 		opts.Runtime = "node"
 	}
 
-	validRuntimes := map[string]bool{"node": true, "deno": true, "bun": true}
 	if !validRuntimes[opts.Runtime] {
-		return nil, fmt.Errorf("unsupported runtime %q (supported: node, deno, bun)", opts.Runtime)
+		return nil, fmt.Errorf("unsupported runtime %q (supported: node, deno, bun, workerd)", opts.Runtime)
 	}
 
 	if opts.Entry == "" {
 		opts.Entry = flowPath
 	}
 
-	payloadBytes, err := json.Marshal(opts.Payload)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	opts.entryPath = opts.Entry
+	if isRemoteEntry(opts.Entry) {
+		ctx := j.vu.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		if opts.entryPath, err = j.module.remote.get(ctx, opts.Entry, opts.Integrity); err != nil {
+			return nil, err
+		}
+	} else if opts.Integrity != "" {
+		return nil, fmt.Errorf("integrity is only supported for http(s) entries, got %s", opts.Entry)
 	}
-
-	ctx := j.vu.Context()
-	if ctx == nil {
-		ctx = context.Background()
+	if isArchiveEntry(opts.entryPath) {
+		ctx := j.vu.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		if opts.entryPath, err = j.module.archives.get(ctx, opts.entryPath, opts.Install); err != nil {
+			return nil, err
+		}
+	} else if opts.Install {
+		return nil, fmt.Errorf("install is only supported for archive entries (.tgz, .tar.gz, .tar or .zip), got %s", opts.Entry)
 	}
 
-	if opts.Timeout != "" {
-		d, err := time.ParseDuration(opts.Timeout)
+	opts.runtimeTag = opts.Runtime
+	if opts.TagRuntimeVersion {
+		version, err := j.module.versions.get(opts.Runtime)
 		if err != nil {
-			return nil, fmt.Errorf("invalid timeout value %q: %w", opts.Timeout, err)
+			return nil, err
 		}
-		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, d)
-		defer cancel()
+		opts.runtimeTag = opts.Runtime + "@" + version
 	}
 
-	execContext := j.getExecutionContext()
-	execContextBytes, err := json.Marshal(execContext)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal execution context: %w", err)
+	if minVersion := opts.MinVersion[opts.Runtime]; minVersion != "" {
+		if err := j.module.versions.require(opts.Runtime, minVersion); err != nil {
+			return nil, err
+		}
 	}
 
-	var cmd *exec.Cmd
-	switch opts.Runtime {
-	case "node":
-		cmd = exec.CommandContext(ctx, "node", "-e", runnerScript, opts.Entry, string(payloadBytes), string(execContextBytes))
-	case "deno":
-		// --allow-all enables npm: specifier imports and all other permissions
-		// The script is piped via stdin, arguments come after -
-		cmd = exec.CommandContext(ctx, "deno", "run", "--allow-all", "-", opts.Entry, string(payloadBytes), string(execContextBytes))
-		cmd.Stdin = strings.NewReader(runnerScript)
-		// Set working directory to ensure relative imports and npm packages resolve correctly
-		if wd, err := os.Getwd(); err == nil {
-			cmd.Dir = wd
+	if opts.Threads > 0 {
+		if opts.Runtime != "node" {
+			return nil, fmt.Errorf("threads require the node runtime, got %s", opts.Runtime)
 		}
-	case "bun":
-		cmd = exec.CommandContext(ctx, "bun", "-e", runnerScript, opts.Entry, string(payloadBytes), string(execContextBytes))
-	default:
-		return nil, fmt.Errorf("unsupported runtime: %s", opts.Runtime)
+		if opts.Watch {
+			return nil, fmt.Errorf("watch is not supported with threads")
+		}
+		// Threads run jobs like a worker does, so the same options apply
+		opts.PersistPerVU = true
 	}
 
-	env := os.Environ()
-	for k, v := range opts.Env {
-		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	if opts.PersistPerVU && (opts.Runtime == "workerd" || opts.Transport == "socket" || opts.Format == "cbor") {
+		return nil, fmt.Errorf("persistPerVU is not supported with the workerd runtime, the socket transport or the cbor format")
 	}
-	cmd.Env = env
-
-	start := time.Now()
-	output, err := cmd.CombinedOutput()
-	duration := time.Since(start)
-
-	state := j.vu.State()
-	if state != nil {
-		metricTags := state.Tags.GetCurrentValues().Tags.WithTagsFromMap(
-			map[string]string{"flow": opts.Entry, "runtime": opts.Runtime},
-		)
 
-		metrics.PushIfNotDone(j.vu.Context(), state.Samples, metrics.Sample{
-			TimeSeries: metrics.TimeSeries{
-				Metric: j.jsIterationDuration,
-				Tags:   metricTags,
-			},
-			Time:  time.Now(),
-			Value: float64(duration.Milliseconds()),
-		})
+	if opts.Debug != "" {
+		if opts.PersistPerVU || opts.Runtime == "workerd" {
+			return nil, fmt.Errorf("debug is not supported with persistPerVU or the workerd runtime")
+		}
+		j.logger().Warnf("debug mode: %s waits for a debugger on %s before running %s, the VU is blocked "+
+			"and the timeout is disabled until it finishes. Don't use it in real tests.", opts.Runtime, opts.Debug, opts.Entry)
 	}
 
-	if ctx.Err() == context.DeadlineExceeded {
-		return nil, fmt.Errorf("%s runtime timed out after %s (entry=%s): %w\nOutput: %s",
-			opts.Runtime, opts.Timeout, opts.Entry, ctx.Err(), string(output))
+	if (opts.Stdin != nil || opts.stdinPipe != nil) && (opts.PersistPerVU || opts.Runtime == "workerd") {
+		return nil, fmt.Errorf("stdin and runStreaming are not supported with persistPerVU or the workerd runtime")
 	}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute %s flow (entry=%s): %w\nOutput: %s",
-			opts.Runtime, opts.Entry, err, string(output))
+	if opts.Container != nil && (opts.PersistPerVU || opts.Runtime == "workerd" || opts.BunCompile || opts.Debug != "") {
+		return nil, fmt.Errorf("container is not supported with persistPerVU, the workerd runtime, bunCompile or debug")
 	}
 
-	result, err := extractResult(string(output))
-	if err != nil {
-		return nil, fmt.Errorf("failed to extract result: %w\nOutput: %s", err, string(output))
+	if opts.ReadOnlyFS != nil {
+		if opts.Runtime == "bun" {
+			return nil, fmt.Errorf("readOnlyFS is not supported by the bun runtime")
+		}
+		if opts.PersistPerVU || opts.Container != nil {
+			return nil, fmt.Errorf("readOnlyFS is not supported with persistPerVU, threads or container")
+		}
+		// Deno needs write access to connect to a Unix socket
+		if opts.Runtime == "deno" && opts.Transport == "socket" {
+			return nil, fmt.Errorf("readOnlyFS is not supported with the socket transport on deno")
+		}
 	}
 
-	if state != nil {
-		metricTags := state.Tags.GetCurrentValues().Tags.WithTagsFromMap(
-			map[string]string{"flow": opts.Entry, "runtime": opts.Runtime},
-		)
-
-		metrics.PushIfNotDone(j.vu.Context(), state.Samples, metrics.Sample{
-			TimeSeries: metrics.TimeSeries{
-				Metric: j.jsIterations,
-				Tags:   metricTags,
-			},
-			Time:  time.Now(),
-			Value: 1,
-		})
+	if opts.OTLPReceiver && (opts.PersistPerVU || opts.Runtime == "workerd" || opts.Container != nil || opts.DenyNetwork) {
+		return nil, fmt.Errorf("otlpReceiver is not supported with persistPerVU, threads, the workerd runtime, container or network: false")
 	}
 
-	if metricsArray, ok := result["__k6_metrics__"].([]interface{}); ok {
-		if state != nil {
-			for _, metricEntry := range metricsArray {
-				metricData, ok := metricEntry.(map[string]interface{})
-				if !ok {
-					continue
-				}
-
-				metricName, _ := metricData["name"].(string)
-				metricType, _ := metricData["type"].(string)
-				metricValue := j.extractMetricValue(metricData["value"])
-				if metricValue == 0 && metricData["value"] != nil && metricData["value"] != 0.0 {
-					continue
-				}
-
-				metric, exists := j.customMetrics[metricName]
-				if !exists {
-					var metricKind metrics.MetricType
-					switch metricType {
-					case "counter":
-						metricKind = metrics.Counter
-					case "gauge":
-						metricKind = metrics.Gauge
-					case "trend":
-						metricKind = metrics.Trend
-					case "rate":
-						metricKind = metrics.Rate
-					default:
-						metricKind = metrics.Counter
-					}
-					metric = j.registry.MustNewMetric(metricName, metricKind)
-					j.customMetrics[metricName] = metric
-				}
-
-				tagsMap := make(map[string]string)
-				if tagsData, ok := metricData["tags"].(map[string]interface{}); ok {
-					for k, v := range tagsData {
-						if strVal, ok := v.(string); ok {
-							tagsMap[k] = strVal
-						}
-					}
-				}
-
-				metricTags := state.Tags.GetCurrentValues().Tags.WithTagsFromMap(tagsMap)
-
-				metrics.PushIfNotDone(j.vu.Context(), state.Samples, metrics.Sample{
-					TimeSeries: metrics.TimeSeries{
-						Metric: metric,
-						Tags:   metricTags,
-					},
-					Time:  time.Now(),
-					Value: metricValue,
-				})
-			}
+	if opts.Profile != "" {
+		if opts.Runtime != "node" {
+			return nil, fmt.Errorf("profile requires the node runtime, got %s", opts.Runtime)
+		}
+		// With readOnlyFS, node can't write the profile
+		if opts.PersistPerVU || opts.Container != nil || opts.ReadOnlyFS != nil {
+			return nil, fmt.Errorf("profile is not supported with persistPerVU, threads, container or readOnlyFS")
 		}
-
-		delete(result, "__k6_metrics__")
 	}
 
-	// Record checks as rate metrics (k6 checks are rate metrics under the hood)
-	if checksArray, ok := result["__k6_checks__"].([]interface{}); ok {
-		if state != nil {
-			checkMetric, exists := j.customMetrics["checks"]
-			if !exists {
-				checkMetric = j.registry.MustNewMetric("checks", metrics.Rate)
-				j.customMetrics["checks"] = checkMetric
-			}
-
-			for _, checkEntry := range checksArray {
-				checkData, ok := checkEntry.(map[string]interface{})
-				if !ok {
-					continue
-				}
-
-				checkName, _ := checkData["name"].(string)
-				checkOk, _ := checkData["ok"].(bool)
+	if opts.Umask != nil {
+		opts.CommandWrapper = append(umaskWrapper(*opts.Umask, j.logger().Warnf), opts.CommandWrapper...)
+	}
 
-				if checkName == "" {
-					continue
-				}
+	// Containers are pinned with --cpuset-cpus instead
+	if len(opts.CPUAffinity) > 0 && opts.Container == nil {
+		wrapper, err := affinityWrapper(opts.CPUAffinity, j.logger().Warnf)
+		if err != nil {
+			return nil, err
+		}
+		// Outermost, so commandWrapper tools are pinned as well
+		opts.CommandWrapper = append(wrapper, opts.CommandWrapper...)
+	}
 
-				// k6 checks use the check name as a tag
-				checkValue := 0.0
-				if checkOk {
-					checkValue = 1.0
-				}
+	if opts.Heartbeat > 0 && opts.Runtime == "workerd" {
+		return nil, fmt.Errorf("heartbeat is not supported with the workerd runtime")
+	}
 
-				metricTags := state.Tags.GetCurrentValues().Tags.WithTagsFromMap(
-					map[string]string{"check": checkName},
-				)
+	if opts.BunCompile {
+		if opts.Runtime != "bun" {
+			return nil, fmt.Errorf("bunCompile requires the bun runtime, got %s", opts.Runtime)
+		}
+		if opts.PersistPerVU || opts.K6Compat || opts.Debug != "" {
+			return nil, fmt.Errorf("bunCompile is not supported with persistPerVU, k6compat or debug")
+		}
+		path, err := j.module.bun.get(opts.entryPath)
+		if err != nil {
+			return nil, err
+		}
+		opts.compiledFlow = path
+	}
 
-				metrics.PushIfNotDone(j.vu.Context(), state.Samples, metrics.Sample{
-					TimeSeries: metrics.TimeSeries{
-						Metric: checkMetric,
-						Tags:   metricTags,
-					},
-					Time:  time.Now(),
-					Value: checkValue,
-				})
+	if opts.MaxRuns != nil {
+		remaining, ok := j.module.budgets.take(opts.Entry, opts.MaxRuns.Limit)
+		if state := j.metricsState(); state != nil {
+			j.pushSample(state, metrics.Sample{
+				TimeSeries: metrics.TimeSeries{
+					Metric: j.runsRemaining,
+					Tags:   state.Tags.GetCurrentValues().Tags.WithTagsFromMap(callTags(opts, map[string]string{"flow": opts.Entry})),
+				},
+				Time:  time.Now(),
+				Value: float64(remaining),
+			})
+		}
+		if !ok {
+			if opts.MaxRuns.Mode == "skip" {
+				return map[string]interface{}{skippedKey: true}, nil
 			}
+			return nil, fmt.Errorf("%w: %s already ran the %d times maxRuns allows", errMaxRuns, opts.Entry, opts.MaxRuns.Limit)
 		}
-
-		delete(result, "__k6_checks__")
 	}
 
-	return result, nil
-}
-
-// parseRunOptionsFromArgs interprets the second argument to ext.run().
-//
-// If the second argument is a plain value (e.g. { user: "alice" }),
-// it becomes the payload.
-//
-// If it's a map with any of the special keys (payload, env, timeout, runtime),
-// it's treated as an options object.
-func parseRunOptionsFromArgs(entry string, arg interface{}) (*RunOptions, error) {
-	opts := &RunOptions{
-		Runtime: "",
-		Entry:   entry,
-		Payload: arg,
-		Env:     make(map[string]string),
+	if opts.RateLimit != nil {
+		waited, err := j.module.limiters.throttle(j.vu.Context(), opts.Entry, opts.RateLimit)
+		if state := j.metricsState(); state != nil && opts.RateLimit.Mode == "wait" {
+			j.pushSample(state, metrics.Sample{
+				TimeSeries: metrics.TimeSeries{
+					Metric: j.throttleWait,
+					Tags:   state.Tags.GetCurrentValues().Tags.WithTagsFromMap(callTags(opts, map[string]string{"flow": opts.Entry})),
+				},
+				Time:  time.Now(),
+				Value: float64(waited.Milliseconds()),
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	rawMap, ok := arg.(map[string]interface{})
-	if !ok {
-		return opts, nil
+	maxResultBytes := opts.MaxResultBytes
+	if maxResultBytes == 0 {
+		maxResultBytes = defaultMaxResultBytes
+	}
+	tr, err := newTransport(opts, maxResultBytes)
+	if err != nil {
+		return nil, err
 	}
+	defer tr.close()
 
-	_, hasPayload := rawMap["payload"]
-	_, hasEnv := rawMap["env"]
-	_, hasTimeout := rawMap["timeout"]
-	_, hasRuntime := rawMap["runtime"]
+	ctx := j.vu.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
 
-	isOptions := hasPayload || hasEnv || hasTimeout || hasRuntime
-	if !isOptions {
-		return opts, nil
+	if opts.Timeout != "" && opts.Debug == "" {
+		d, err := time.ParseDuration(opts.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout value %q: %w", opts.Timeout, err)
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
 	}
-	if v, ok := rawMap["runtime"].(string); ok {
-		opts.Runtime = v
+	if !opts.deadline.IsZero() && opts.Debug == "" {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, opts.deadline)
+		defer cancel()
 	}
 
-	if v, ok := rawMap["entry"].(string); ok && v != "" {
-		opts.Entry = v
+	// Cancelled when the output limit is hit, which kills the process
+	ctx, cancelOutput := context.WithCancel(ctx)
+	defer cancelOutput()
+
+	var phases *phaseTimer
+	if (opts.StartupTimeout > 0 || opts.FlowTimeout > 0) && opts.Debug == "" {
+		var cancelPhase context.CancelCauseFunc
+		ctx, cancelPhase = context.WithCancelCause(ctx)
+		defer cancelPhase(nil)
+		phases = &phaseTimer{cancel: cancelPhase, startup: opts.StartupTimeout, flow: opts.FlowTimeout}
+		defer phases.stop()
 	}
 
-	if v, ok := rawMap["payload"]; ok {
-		opts.Payload = v
+	// Flows that don't read ctx.vu, ctx.seed or ctx.now can skip building them
+	execContext := make(map[string]interface{})
+	if !opts.OmitContext {
+		execContext = j.getExecutionContext()
+	} else if opts.SeedEnv != "" {
+		return nil, fmt.Errorf("seedEnv needs the execution context, it can't be used with passContext: false")
 	}
-
-	if v, ok := rawMap["timeout"].(string); ok {
-		opts.Timeout = v
+	execContext["correlationId"] = opts.correlationID
+	// Lets the flow wind down before it's killed, see ctx.signal
+	if deadline, ok := ctx.Deadline(); ok {
+		execContext["deadline"] = deadline.UnixMilli()
 	}
-
+	if phases != nil {
+		// The runner prints a ready line, which starts the flowTimeout clock
+		execContext["ready"] = true
+	}
+	if len(opts.Shared) > 0 {
+		sharedPaths, err := j.module.shared.paths(opts.Shared)
+		if err != nil {
+			return nil, err
+		}
+		execContext["shared"] = sharedPaths
+	}
+
+	if opts.Meta != nil {
+		execContext["meta"] = opts.Meta
+	}
+	if opts.SetupData != nil {
+		execContext["setupData"] = opts.SetupData
+	} else if data := j.module.setup.get(); data != nil {
+		execContext["setupData"] = data
+	}
+	if opts.Fn != "" {
+		execContext["fn"] = opts.Fn
+	}
+	if opts.Args != nil {
+		execContext["args"] = opts.Args
+	}
+	if opts.stdinPipe != nil {
+		execContext["stream"] = true
+	}
+	if opts.Kwargs != nil {
+		execContext["kwargs"] = opts.Kwargs
+	}
+	if opts.Heartbeat > 0 {
+		execContext["heartbeat"] = opts.Heartbeat.Milliseconds()
+	}
+	if opts.AutoInstrumentHTTP {
+		execContext["autoInstrumentHttp"] = true
+	}
+	if opts.Ack {
+		if opts.Runtime == "workerd" {
+			return nil, fmt.Errorf("ack is not supported by the workerd runtime")
+		}
+		execContext["ack"] = true
+	}
+	if opts.K6Compat {
+		if opts.Runtime == "workerd" {
+			return nil, fmt.Errorf("k6compat is not supported by the workerd runtime")
+		}
+		execContext["k6compat"] = true
+	}
+	if opts.DenyNetwork {
+		if opts.Runtime == "workerd" {
+			return nil, fmt.Errorf("network: false is not supported by the workerd runtime")
+		}
+		execContext["denyNet"] = true
+	}
+	if len(opts.CookieJar) > 0 {
+		if opts.Runtime == "workerd" {
+			return nil, fmt.Errorf("cookieJar is not supported by the workerd runtime")
+		}
+		cookies, err := jarCookies(j.vu.State(), opts.CookieJar)
+		if err != nil {
+			return nil, err
+		}
+		execContext["cookies"] = cookies
+	}
+
+	if len(opts.Files) > 0 {
+		if opts.Runtime == "workerd" {
+			return nil, fmt.Errorf("files are not supported by the workerd runtime")
+		}
+		filesDir, err := materializeFiles(opts.Files)
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(filesDir)
+		execContext["filesDir"] = filesDir
+	}
+
+	if opts.Profile != "" {
+		if opts.profileDir, err = os.MkdirTemp("", "xk6-external-js-profile-"); err != nil {
+			return nil, fmt.Errorf("failed to create profile directory: %w", err)
+		}
+		defer os.RemoveAll(opts.profileDir)
+	}
+
+	var otlp *otlpReceiver
+	if opts.OTLPReceiver {
+		if otlp, err = startOTLPReceiver(maxResultBytes); err != nil {
+			return nil, err
+		}
+		defer otlp.close()
+		execContext["otlpEndpoint"] = otlp.endpoint()
+	}
+
+	payloadBytes, err := tr.send(opts.Payload, execContext)
+	if err != nil {
+		return nil, err
+	}
+
+	// workerd has no filesystem access to begin with
+	if opts.ReadOnlyFS != nil && opts.Runtime != "workerd" {
+		opts.readPaths = append([]string{}, opts.ReadOnlyFS...)
+		if entryPath, err := filepath.Abs(opts.entryPath); err == nil {
+			opts.readPaths = append(opts.readPaths, filepath.Dir(entryPath))
+		}
+		if sharedPaths, ok := execContext["shared"].(map[string]string); ok {
+			for _, path := range sharedPaths {
+				opts.readPaths = append(opts.readPaths, path)
+			}
+		}
+		if filesDir, ok := execContext["filesDir"].(string); ok {
+			opts.readPaths = append(opts.readPaths, filesDir)
+		}
+		if compressed, ok := tr.(*compressedTransport); ok {
+			opts.readPaths = append(opts.readPaths, compressed.path)
+		}
+		if opts.Runtime == "deno" && (opts.Stdin != nil || opts.stdinPipe != nil) {
+			path, err := runnerFile()
+			if err != nil {
+				return nil, err
+			}
+			opts.readPaths = append(opts.readPaths, path)
+		}
+	}
+
+	execContextBytes, err := marshalJSON(execContext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal execution context: %w", err)
+	}
+	if record != nil {
+		record.Context = execContext
+	}
+
+	env := stripEnv(os.Environ(), opts.EnvStrip)
+	if opts.SeedEnv != "" {
+		env = append(env, fmt.Sprintf("%s=%d", opts.SeedEnv, execContext["seed"]))
+	}
+	if otlp != nil {
+		env = append(env, otlpMetricsEnvVar+"="+otlp.endpoint())
+	}
+	for k, v := range opts.Env {
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	var cmd *exec.Cmd
+	if !opts.PersistPerVU {
+		var cleanup func()
+		cmd, cleanup, err = buildCommand(ctx, opts, payloadBytes, execContextBytes)
+		if err != nil {
+			return nil, err
+		}
+		defer cleanup()
+		cmd.Env = env
+	}
+
+	// stdout and stderr are captured separately, and also interleaved into
+	// a combined output used for error messages and log files.
+	var stdoutBuf, stderrBuf, outputBuf bytes.Buffer
+	combined := &lockedWriter{w: &outputBuf}
+	if opts.LogDir != "" {
+		logFile, err := j.openLogFile(opts.LogDir, opts.Entry)
+		if err != nil {
+			return nil, err
+		}
+		defer logFile.Close()
+		combined.w = io.MultiWriter(&outputBuf, logFile)
+	}
+	limitedStdout := &limitWriter{w: &stdoutBuf, limit: maxResultBytes, onExceed: cancelOutput}
+	stdoutWriter := io.MultiWriter(limitedStdout, combined)
+	stderrWriter := io.MultiWriter(&stderrBuf, combined)
+	if opts.onAck != nil {
+		stdoutWriter = &ackWriter{w: stdoutWriter, onAck: opts.onAck}
+	}
+	stdoutWriter = newFrameWriter(stdoutWriter, logPrefix, j.forwardLog(opts))
+	imports := &importTimer{}
+	stdoutWriter = newFrameWriter(stdoutWriter, importTimePrefix, imports.record)
+	if opts.LogFormat == "json" {
+		stdoutWriter = &jsonLogWriter{w: stdoutWriter, onLog: j.forwardJSONLog(opts)}
+	}
+	if phases != nil {
+		stdoutWriter = newFrameWriter(stdoutWriter, readyPrefix, phases.markReady)
+	}
+	var heartbeats *heartbeatTracker
+	if opts.Heartbeat > 0 {
+		heartbeats = &heartbeatTracker{}
+		stdoutWriter = newFrameWriter(stdoutWriter, heartbeatPrefix, heartbeats.record)
+	}
+	binaryResult, _ := tr.(*binaryTransport)
+	if binaryResult != nil {
+		// Outside the writers above, which look for text lines
+		stdoutWriter = binaryResult.writer(stdoutWriter, cancelOutput)
+	}
+	var guard *outputGuard
+	if opts.MaxOutputBytes > 0 || opts.MaxOutputLines > 0 {
+		// Outermost, so the guard counts everything the flow prints, including
+		// ctx.log and heartbeat lines
+		guard = &outputGuard{maxBytes: opts.MaxOutputBytes, maxLines: opts.MaxOutputLines, onExceed: cancelOutput}
+		stdoutWriter = guard.writer(stdoutWriter)
+		stderrWriter = guard.writer(stderrWriter)
+	}
+	if opts.Debug != "" {
+		// Shows the inspector URL printed by the runtime as soon as it's up
+		stderrWriter = io.MultiWriter(stderrWriter, os.Stderr)
+	}
+
+	ran = true
+	start := time.Now()
+	if phases != nil {
+		phases.start()
+	}
+	if opts.PersistPerVU {
+		job := workerJob{
+			Entry:   opts.entryPath,
+			Payload: payloadBytes,
+			Context: execContextBytes,
+			Env:     opts.Env,
+		}
+		if opts.SeedEnv != "" {
+			// The worker's environment has the seed of the call that started
+			// it, so each job carries its own
+			job.Env = make(map[string]string, len(opts.Env)+1)
+			for k, v := range opts.Env {
+				job.Env[k] = v
+			}
+			job.Env[opts.SeedEnv] = fmt.Sprintf("%d", execContext["seed"])
+		}
+		if opts.Threads > 0 {
+			err = j.runInThreads(ctx, opts, env, job, stdoutWriter, stderrWriter)
+		} else {
+			err = j.runInWorker(ctx, opts, env, job, stdoutWriter, stderrWriter)
+		}
+	} else {
+		cmd.Stdout = stdoutWriter
+		cmd.Stderr = stderrWriter
+		err = j.module.processes.run(cmd, opts.Entry, j.metricsState())
+	}
+	duration := time.Since(start)
+	output := outputBuf.Bytes()
+
+	if otlp != nil {
+		// Pushed even if the flow failed, like the samples it pushed itself
+		j.pushOTLPMetrics(opts, otlp)
+	}
+
+	state := j.metricsState()
+	if state != nil {
+		metricTags := state.Tags.GetCurrentValues().Tags.WithTagsFromMap(
+			callTags(opts, map[string]string{"flow": opts.Entry, "runtime": opts.runtimeTag}),
+		)
+
+		j.pushSample(state, metrics.Sample{
+			TimeSeries: metrics.TimeSeries{
+				Metric: j.jsIterationDuration,
+				Tags:   metricTags,
+			},
+			Time:  time.Now(),
+			Value: float64(duration.Milliseconds()),
+		})
+
+		// Only processes that exited on their own have an exit code, not the
+		// ones killed on timeout or by a signal
+		if cmd != nil && cmd.ProcessState != nil && ctx.Err() == nil && cmd.ProcessState.ExitCode() >= 0 {
+			j.pushSample(state, metrics.Sample{
+				TimeSeries: metrics.TimeSeries{
+					Metric: j.exitCode,
+					Tags:   metricTags,
+				},
+				Time:  time.Now(),
+				Value: float64(cmd.ProcessState.ExitCode()),
+			})
+		}
+
+		// Calls a worker runs with an entry it imported before have none
+		if ms, ok := imports.get(); ok {
+			j.pushSample(state, metrics.Sample{
+				TimeSeries: metrics.TimeSeries{
+					Metric: j.importTime,
+					Tags:   metricTags,
+				},
+				Time:  time.Now(),
+				Value: ms,
+			})
+		}
+	}
+
+	if guard != nil && guard.tripped() {
+		if state != nil {
+			j.pushSample(state, metrics.Sample{
+				TimeSeries: metrics.TimeSeries{
+					Metric: j.outputLimitHits,
+					Tags: state.Tags.GetCurrentValues().Tags.WithTagsFromMap(
+						callTags(opts, map[string]string{"flow": opts.Entry, "runtime": opts.runtimeTag}),
+					),
+				},
+				Time:  time.Now(),
+				Value: 1,
+			})
+		}
+		return nil, fmt.Errorf("flow %s exceeded the output limit of %s and was stopped\nOutput: %s",
+			opts.Entry, outputLimitDescription(opts), decodeOutput(output, opts.outputEncoding))
+	}
+
+	exceeded := limitedStdout.exceeded
+	if socket, ok := tr.(*socketTransport); ok && err != nil {
+		// The runner fails when an oversized frame is rejected
+		_, socketErr := socket.receive("")
+		exceeded = exceeded || errors.Is(socketErr, errOutputLimit)
+	}
+	if binaryResult != nil {
+		exceeded = exceeded || binaryResult.frame.exceeded
+	}
+	if exceeded {
+		return nil, fmt.Errorf("result of %s exceeded %d bytes (raise maxResultBytes to allow more)",
+			opts.Entry, maxResultBytes)
+	}
+
+	var phaseErr *phaseTimeout
+	if errors.As(context.Cause(ctx), &phaseErr) {
+		what := "didn't start within"
+		if phaseErr.option == "flowTimeout" {
+			what = "ran out of"
+		}
+		return nil, &attemptError{
+			err: fmt.Errorf("%s runtime %s the %s of %s (entry=%s)\nOutput: %s",
+				opts.Runtime, what, phaseErr.option, phaseErr.limit, opts.Entry, decodeOutput(output, opts.outputEncoding)),
+			stderr: decodeOutput(stderrBuf.Bytes(), opts.outputEncoding),
+		}
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		var phase string
+		if heartbeats != nil {
+			phase = heartbeats.describe(time.Now())
+		}
+		if !opts.deadline.IsZero() && !time.Now().Before(opts.deadline) {
+			return nil, fmt.Errorf("%s runtime ran out of the totalTimeout of %s%s (entry=%s): %w\nOutput: %s",
+				opts.Runtime, opts.TotalTimeout, phase, opts.Entry, errTotalTimeout, decodeOutput(output, opts.outputEncoding))
+		}
+		return nil, &attemptError{
+			err: fmt.Errorf("%s runtime timed out after %s%s (entry=%s): %w\nOutput: %s",
+				opts.Runtime, opts.Timeout, phase, opts.Entry, ctx.Err(), decodeOutput(output, opts.outputEncoding)),
+			stderr: decodeOutput(stderrBuf.Bytes(), opts.outputEncoding),
+		}
+	}
+
+	if err != nil {
+		return nil, &attemptError{
+			err: fmt.Errorf("failed to execute %s flow (entry=%s): %w\nOutput: %s",
+				opts.Runtime, opts.Entry, err, decodeOutput(output, opts.outputEncoding)),
+			stderr: decodeOutput(stderrBuf.Bytes(), opts.outputEncoding),
+		}
+	}
+
+	if opts.StrictStderr {
+		if lines := unexpectedStderr(decodeOutput(stderrBuf.Bytes(), opts.outputEncoding), opts.StderrIgnore); len(lines) > 0 {
+			return nil, fmt.Errorf("%s wrote to stderr in strict mode:\n%s", opts.Entry, strings.Join(lines, "\n"))
+		}
+	}
+
+	result, err = tr.receive(stdoutBuf.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract result: %w\nOutput: %s", err, decodeOutput(output, opts.outputEncoding))
+	}
+
+	var profilePath string
+	if opts.profileDir != "" {
+		if profilePath, err = j.saveProfile(opts); err != nil {
+			return nil, err
+		}
+	}
+
+	var stderr string
+	if opts.CaptureStderr || record != nil {
+		stderr = decodeOutput(stderrBuf.Bytes(), opts.outputEncoding)
+	}
+	if record != nil {
+		// Encoded before processing, which removes the protocol fields
+		encoded, marshalErr := json.Marshal(result)
+		if marshalErr != nil {
+			j.logger().Warnf("not recording the result of %s: %v", opts.Entry, marshalErr)
+		}
+		record.Result = encoded
+		record.Stderr = stderr
+	}
+
+	result, err = j.processResult(opts, result, stderr)
+	if err == nil && result != nil && profilePath != "" {
+		// Added last, so select and transform don't drop it
+		result[profileKey] = profilePath
+	}
+	return result, err
+}
+
+// processResult records the metrics and checks a flow sent with its result
+// and turns the result into what's returned to the script. stderr is the
+// flow's decoded stderr, used for captureStderr.
+func (j *ExternalJS) processResult(opts *RunOptions, result map[string]interface{}, stderr string) (map[string]interface{}, error) {
+	// Numbers arrive with all their digits. The __-prefixed fields the
+	// extension reads get them as plain numbers right away, and the flow's
+	// own fields as numberMode says, the call's first and then the flow's,
+	// once resultSchema has checked them.
+	numberMode := opts.NumberMode
+	if raw, ok := result[numberModeKey]; ok {
+		if mode, err := parseNumberMode(raw); err != nil {
+			j.logger().Warnf("ignoring the number mode of %s: %v", opts.Entry, err)
+		} else if numberMode == "" {
+			numberMode = mode
+		}
+		delete(result, numberModeKey)
+	}
+	for key, value := range result {
+		// Sub-results are converted as they're merged into the result
+		if strings.HasPrefix(key, "__") && key != "__k6_results__" {
+			result[key] = convertNumbers(value, "number")
+		}
+	}
+
+	// Flows can report a failure while still returning a result
+	softMessage := softErrorMessage(result[softErrorKey])
+	delete(result, softErrorKey)
+	opts.softFailed = softMessage != ""
+
+	// Batch flows can count the items they processed as iterations
+	iterations := 1.0
+	if raw, ok := result["__k6_iterations__"]; ok {
+		if n, ok := raw.(float64); ok && n >= 0 {
+			iterations = n
+		} else {
+			j.logger().Warnf("ignoring __k6_iterations__ of %s, it must be a non-negative number, got %v", opts.Entry, raw)
+		}
+		delete(result, "__k6_iterations__")
+	}
+
+	// Flows can report a finer outcome than success or failure
+	var status string
+	if raw, ok := result[statusKey]; ok {
+		var err error
+		if status, err = parseStatus(raw); raw != nil && err != nil {
+			j.logger().Warnf("ignoring the status of %s: %v", opts.Entry, err)
+		}
+		delete(result, statusKey)
+	}
+
+	// Flows can pace the VU with think time, the way sleep() does in the script
+	var sleep time.Duration
+	if raw, ok := result[sleepKey]; ok {
+		var err error
+		if sleep, err = parseSleep(raw); err != nil {
+			j.logger().Warnf("ignoring the think time of %s: %v", opts.Entry, err)
+		}
+		delete(result, sleepKey)
+	}
+
+	// Flows can signal lifecycle or business events beyond metrics and checks
+	var events []FlowEvent
+	if raw, ok := result[eventKey]; ok {
+		var errs []error
+		events, errs = parseEvents(raw)
+		for _, err := range errs {
+			j.logger().Warnf("ignoring an event of %s: %v", opts.Entry, err)
+		}
+		delete(result, eventKey)
+	}
+
+	// Flows can report facts about the environment they discovered, once
+	// for the whole test
+	var metadata map[string]string
+	if raw, ok := result[metadataKey]; ok {
+		var err error
+		if metadata, err = parseMetadata(raw); err != nil {
+			j.logger().Warnf("ignoring the metadata of %s: %v", opts.Entry, err)
+		}
+		delete(result, metadataKey)
+	}
+
+	state := j.metricsState()
+	if state != nil {
+		metricTags := state.Tags.GetCurrentValues().Tags.WithTagsFromMap(
+			callTags(opts, map[string]string{"flow": opts.Entry, "runtime": opts.runtimeTag}),
+		)
+
+		j.pushSample(state, metrics.Sample{
+			TimeSeries: metrics.TimeSeries{
+				Metric: j.jsIterations,
+				Tags:   metricTags,
+			},
+			Time:  time.Now(),
+			Value: iterations,
+		})
+
+		if status != "" {
+			j.pushStatus(state, opts, status)
+		}
+	}
+	j.emitEvents(state, opts, events)
+	j.recordMetadata(state, opts, metadata)
+
+	sink := j.metricsSink(opts, state)
+
+	if metricsArray, ok := result["__k6_metrics__"].([]interface{}); ok {
+		if state != nil {
+			j.pushCustomMetrics(state, metricsArray, callTags(opts, nil), sink)
+		}
+
+		delete(result, "__k6_metrics__")
+	}
+
+	// Flows that already keep Prometheus metrics can hand over the exposition text
+	if text, ok := result["__k6_prometheus__"].(string); ok {
+		if state != nil {
+			entries, errs := parsePrometheusText(text)
+			for _, err := range errs {
+				state.Logger.Warnf("skipping invalid __k6_prometheus__ sample from %s: %v", opts.Entry, err)
+			}
+			j.pushCustomMetrics(state, entries, callTags(opts, nil), sink)
+		}
+
+		delete(result, "__k6_prometheus__")
+	}
+
+	// Web flows can pass on the Server-Timing data of the responses they got
+	if raw, ok := result[serverTimingKey]; ok {
+		if state != nil {
+			entries, errs := parseServerTiming(raw)
+			for _, err := range errs {
+				state.Logger.Warnf("skipping invalid %s timing from %s: %v", serverTimingKey, opts.Entry, err)
+			}
+			j.pushCustomMetrics(state, entries, callTags(opts, nil), sink)
+		}
+
+		delete(result, serverTimingKey)
+	}
+
+	// Record checks as rate metrics (k6 checks are rate metrics under the hood)
+	if checksArray, ok := result["__k6_checks__"].([]interface{}); ok {
+		if state != nil {
+			j.pushChecks(state, checksArray, callTags(opts, nil))
+		}
+
+		delete(result, "__k6_checks__")
+	}
+
+	// Multi-step flows can report named sub-results, each with its own metrics
+	// and checks tagged with the sub-result name. Their values are merged into
+	// the result under that name.
+	if subResults, ok := result["__k6_results__"].([]interface{}); ok {
+		for _, entry := range subResults {
+			subResult, ok := entry.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			name, _ := subResult["name"].(string)
+			if name == "" {
+				continue
+			}
+
+			if state != nil {
+				resultTags := callTags(opts, map[string]string{"result": name})
+				if metricsArray, ok := convertNumbers(subResult["metrics"], "number").([]interface{}); ok {
+					j.pushCustomMetrics(state, metricsArray, resultTags, sink)
+				}
+				if checksArray, ok := convertNumbers(subResult["checks"], "number").([]interface{}); ok {
+					j.pushChecks(state, checksArray, resultTags)
+				}
+			}
+
+			value := subResult["value"]
+			if strings.HasPrefix(name, "__") {
+				value = convertNumbers(value, "number")
+			}
+			result[name] = value
+		}
+
+		delete(result, "__k6_results__")
+	}
+
+	// A flow that detects an unrecoverable condition can stop the whole test,
+	// the way exec.test.abort() does
+	if rawAbort, ok := result["__k6_abort__"]; ok && rawAbort != nil && rawAbort != false {
+		reason := abortReason(rawAbort)
+		j.vu.Runtime().Interrupt(&errext.InterruptError{Reason: reason})
+		return nil, &abortError{entry: opts.Entry, reason: reason}
+	}
+
+	// Large files the flow produced are listed instead of inlined
+	if raw, ok := result[attachmentsKey]; ok {
+		if err := j.collectAttachments(opts, raw); err != nil {
+			return nil, fmt.Errorf("invalid %s from %s: %w", attachmentsKey, opts.Entry, err)
+		}
+	}
+
+	if opts.ResultSchema != nil {
+		if err := j.validateResult(opts.ResultSchema, result); err != nil {
+			return nil, fmt.Errorf("result of %s does not match resultSchema: %w", opts.Entry, err)
+		}
+	}
+	for key, value := range result {
+		if !strings.HasPrefix(key, "__") {
+			result[key] = convertNumbers(value, numberMode)
+		}
+	}
+
+	// A __k6_response__ object is merged into the result shaped like a k6
+	// http.Response, so the result can be checked like one
+	if rawResponse, ok := result["__k6_response__"]; ok {
+		responseMap, ok := rawResponse.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("__k6_response__ of %s must be an object, got %T", opts.Entry, rawResponse)
+		}
+		response, err := normalizeResponse(responseMap)
+		if err != nil {
+			return nil, fmt.Errorf("invalid __k6_response__ from %s: %w", opts.Entry, err)
+		}
+
+		delete(result, "__k6_response__")
+		for key, value := range response {
+			result[key] = value
+		}
+	}
+
+	// Only the selected part of the result is handed to the VU
+	if opts.Select != nil {
+		result = selectFields(result, opts.Select)
+	}
+
+	if opts.CaptureStderr {
+		result["__stderr__"] = stderr
+		result["__had_stderr__"] = stderr != ""
+	}
+
+	// The runtime that handled the call, e.g. to check what runtimeFallback picked
+	result["__runtime__"] = opts.runtimeTag
+	if status != "" {
+		result["__status__"] = status
+	}
+
+	result = j.module.transform.apply(result)
+
+	opts.thinkTime = sleep
+
+	if opts.softFailed && opts.FailOnError {
+		return nil, &softError{entry: opts.Entry, message: softMessage, Result: result}
+	}
+
+	// Flows run in setup() hand their result to the flows of later iterations
+	if inSetup(state) {
+		if err := j.module.setup.set(result); err != nil {
+			j.logger().Warnf("not using the result of %s as setup data: %v", opts.Entry, err)
+		}
+	}
+
+	return result, nil
+}
+
+// unexpectedStderr returns the non-empty stderr lines not matched by ignore
+func unexpectedStderr(stderr string, ignore *regexp.Regexp) []string {
+	var lines []string
+	for _, line := range strings.Split(stderr, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if ignore != nil && ignore.MatchString(line) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// buildCommand creates the one-shot runtime command for a flow invocation.
+// The returned cleanup function must be called once the command has finished.
+func buildCommand(ctx context.Context, opts *RunOptions, payloadBytes, execContextBytes []byte) (*exec.Cmd, func(), error) {
+	var cmd *exec.Cmd
+	cleanup := func() {}
+	switch opts.Runtime {
+	case "node":
+		args := []string{"-e", runnerScript, opts.entryPath, string(payloadBytes), string(execContextBytes)}
+		if opts.ReadOnlyFS != nil {
+			args = append(readOnlyFSArgs(opts), args...)
+		}
+		if opts.profileDir != "" {
+			args = append(profileArgs(opts), args...)
+		}
+		cmd = exec.CommandContext(ctx, "node", debugArgs(opts, args...)...)
+	case "deno":
+		// --allow-all enables npm: specifier imports and all other permissions
+		runArgs := []string{"run", "--allow-all"}
+		if opts.ReadOnlyFS != nil {
+			runArgs = append([]string{"run"}, readOnlyFSArgs(opts)...)
+		}
+		if opts.DenyNetwork {
+			runArgs = append(runArgs, "--deny-net")
+		}
+		if opts.Stdin != nil || opts.stdinPipe != nil {
+			// stdin belongs to the flow, so the script is read from a file
+			path, err := runnerFile()
+			if err != nil {
+				return nil, nil, err
+			}
+			cmd = exec.CommandContext(ctx, "deno", debugArgs(opts, append(runArgs, path, opts.entryPath, string(payloadBytes), string(execContextBytes))...)...)
+		} else {
+			// The script is piped via stdin, arguments come after -
+			cmd = exec.CommandContext(ctx, "deno", debugArgs(opts, append(runArgs, "-", opts.entryPath, string(payloadBytes), string(execContextBytes))...)...)
+			cmd.Stdin = strings.NewReader(runnerScript)
+		}
+		// Set working directory to ensure relative imports and npm packages resolve correctly
+		if wd, err := os.Getwd(); err == nil {
+			cmd.Dir = wd
+		}
+	case "bun":
+		if opts.compiledFlow != "" {
+			cmd = exec.CommandContext(ctx, opts.compiledFlow, opts.entryPath, string(payloadBytes), string(execContextBytes))
+			break
+		}
+		cmd = exec.CommandContext(ctx, "bun", debugArgs(opts, "-e", runnerScript, opts.entryPath, string(payloadBytes), string(execContextBytes))...)
+	case "workerd":
+		var err error
+		cmd, cleanup, err = workerdCommand(ctx, opts.entryPath, payloadBytes, execContextBytes, opts.Env)
+		if err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported runtime: %s", opts.Runtime)
+	}
+
+	if opts.Stdin != nil {
+		cmd.Stdin = bytes.NewReader(opts.Stdin)
+	} else if opts.stdinPipe != nil {
+		cmd.Stdin = opts.stdinPipe
+	}
+
+	if len(opts.CommandWrapper) > 0 {
+		cmd = wrapCommand(ctx, opts.CommandWrapper, cmd)
+	}
+
+	if opts.Container != nil {
+		envKeys := make([]string, 0, len(opts.Env)+1)
+		for key := range opts.Env {
+			envKeys = append(envKeys, key)
+		}
+		if opts.SeedEnv != "" {
+			envKeys = append(envKeys, opts.SeedEnv)
+		}
+		wrapped, removeContainer, err := containerCommand(ctx, opts.Container, cmd, envKeys, opts.CPUAffinity)
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		cmd = wrapped
+		runtimeCleanup := cleanup
+		cleanup = func() {
+			removeContainer()
+			runtimeCleanup()
+		}
+	}
+
+	return cmd, cleanup, nil
+}
+
+// abortError is returned by calls whose flow aborted the test
+type abortError struct {
+	entry  string
+	reason string
+}
+
+func (e *abortError) Error() string {
+	return fmt.Sprintf("%s aborted the test: %s", e.entry, e.reason)
+}
+
+// abortReason builds the interrupt reason for a __k6_abort__ frame, which is
+// true, a reason string, or an object with a reason field
+func abortReason(raw interface{}) string {
+	var message string
+	switch v := raw.(type) {
+	case string:
+		message = v
+	case map[string]interface{}:
+		message, _ = v["reason"].(string)
+	}
+
+	if message == "" {
+		return errext.AbortTest
+	}
+	return fmt.Sprintf("%s: %s", errext.AbortTest, message)
+}
+
+// metricsSink returns the StatsD sink of the metricsSink option, or nil
+func (j *ExternalJS) metricsSink(opts *RunOptions, state *lib.State) *statsdSink {
+	if opts.MetricsSink == "" || state == nil {
+		return nil
+	}
+	sink, err := j.module.sinks.get(opts.MetricsSink)
+	if err != nil {
+		state.Logger.Warnf("not forwarding metrics of %s: %v", opts.Entry, err)
+		return nil
+	}
+	return sink
+}
+
+// pushCustomMetrics records the entries of a __k6_metrics__ array as k6 samples.
+// An entry has either a value or, to record many observations at once, a
+// values array, which pushes one sample per value with the same tags and
+// time. extraTags are added to every sample. Samples are also forwarded to
+// sink, if it isn't nil.
+func (j *ExternalJS) pushCustomMetrics(state *lib.State, metricsArray []interface{}, extraTags map[string]string, sink *statsdSink) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, metricEntry := range metricsArray {
+		metricData, ok := metricEntry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		metricName, _ := metricData["name"].(string)
+		metricType, _ := metricData["type"].(string)
+		var metricValues []float64
+		if rawValues, ok := metricData["values"].([]interface{}); ok {
+			metricValues = make([]float64, 0, len(rawValues))
+			for _, rawValue := range rawValues {
+				if value := j.extractMetricValue(rawValue); value != 0 || rawValue == 0.0 {
+					metricValues = append(metricValues, value)
+				}
+			}
+		} else {
+			metricValue := j.extractMetricValue(metricData["value"])
+			if metricValue == 0 && metricData["value"] != nil && metricData["value"] != 0.0 {
+				continue
+			}
+			metricValues = []float64{metricValue}
+		}
+		if len(metricValues) == 0 {
+			continue
+		}
+
+		metric, exists := j.customMetrics[metricName]
+		if !exists {
+			if len(j.customMetrics) >= j.maxCustomMetrics {
+				if !j.customMetricsLimitHit {
+					state.Logger.Warnf("custom metric limit of %d reached, dropping samples for new metric names like %q",
+						j.maxCustomMetrics, metricName)
+					j.customMetricsLimitHit = true
+				}
+				continue
+			}
+
+			var metricKind metrics.MetricType
+			switch metricType {
+			case "counter":
+				metricKind = metrics.Counter
+			case "gauge":
+				metricKind = metrics.Gauge
+			case "trend":
+				metricKind = metrics.Trend
+			case "rate":
+				metricKind = metrics.Rate
+			default:
+				metricKind = metrics.Counter
+			}
+			var valueTypes []metrics.ValueType
+			if contains, _ := metricData["contains"].(string); contains == "time" {
+				valueTypes = append(valueTypes, metrics.Time)
+			}
+			metric = j.registry.MustNewMetric(metricName, metricKind, valueTypes...)
+			j.customMetrics[metricName] = metric
+		}
+
+		tagsMap := make(map[string]string)
+		if tagsData, ok := metricData["tags"].(map[string]interface{}); ok {
+			for k, v := range tagsData {
+				if strVal, ok := v.(string); ok {
+					tagsMap[k] = strVal
+				}
+			}
+		}
+
+		for k, v := range extraTags {
+			tagsMap[k] = v
+		}
+
+		metricTags := state.Tags.GetCurrentValues().Tags.WithTagsFromMap(tagsMap)
+
+		// Flows may stamp samples with the time the event happened (epoch ms)
+		sampleTime := time.Now()
+		if epochMs, ok := metricData["time"].(float64); ok && epochMs > 0 {
+			sampleTime = time.UnixMilli(int64(epochMs))
+		}
+
+		for _, metricValue := range metricValues {
+			// Counters only ever go up; gauges and trends accept any value,
+			// including negative ones.
+			if metric.Type == metrics.Counter && metricValue < 0 {
+				state.Logger.Warnf("skipping negative value %v for counter metric %q", metricValue, metricName)
+				continue
+			}
+
+			j.pushSample(state, metrics.Sample{
+				TimeSeries: metrics.TimeSeries{
+					Metric: metric,
+					Tags:   metricTags,
+				},
+				Time:  sampleTime,
+				Value: metricValue,
+			})
+			if sink != nil {
+				sink.send(metric, metricValue, tagsMap)
+			}
+		}
+	}
+}
+
+// pushChecks records the entries of a __k6_checks__ array on the checks metric.
+// extraTags are added to every sample.
+func (j *ExternalJS) pushChecks(state *lib.State, checksArray []interface{}, extraTags map[string]string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	checkMetric, exists := j.customMetrics["checks"]
+	if !exists {
+		checkMetric = j.registry.MustNewMetric("checks", metrics.Rate)
+		j.customMetrics["checks"] = checkMetric
+	}
+
+	for _, checkEntry := range checksArray {
+		checkData, ok := checkEntry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		checkName, _ := checkData["name"].(string)
+		checkOk, _ := checkData["ok"].(bool)
+
+		if checkName == "" {
+			continue
+		}
+
+		// k6 checks use the check name as a tag
+		checkValue := 0.0
+		if checkOk {
+			checkValue = 1.0
+		}
+
+		tagsMap := make(map[string]string)
+		if tagsData, ok := checkData["tags"].(map[string]interface{}); ok {
+			for k, v := range tagsData {
+				if strVal, ok := v.(string); ok {
+					tagsMap[k] = strVal
+				}
+			}
+		}
+		tagsMap["check"] = checkName
+		for k, v := range extraTags {
+			tagsMap[k] = v
+		}
+
+		metricTags := state.Tags.GetCurrentValues().Tags.WithTagsFromMap(tagsMap)
+
+		j.pushSample(state, metrics.Sample{
+			TimeSeries: metrics.TimeSeries{
+				Metric: checkMetric,
+				Tags:   metricTags,
+			},
+			Time:  time.Now(),
+			Value: checkValue,
+		})
+	}
+}
+
+// entryPlaceholderRegex matches {name} placeholders in entry paths
+var entryPlaceholderRegex = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEntry resolves {name} placeholders in an entry like
+// "flows/{env}/checkout.js", from the env option first and k6's __ENV second
+func expandEntry(entry string, env, k6Env map[string]string) (string, error) {
+	var missing []string
+	expanded := entryPlaceholderRegex.ReplaceAllStringFunc(entry, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		if value, ok := env[name]; ok {
+			return value
+		}
+		if value, ok := k6Env[name]; ok {
+			return value
+		}
+		missing = append(missing, name)
+		return placeholder
+	})
+
+	if len(missing) > 0 {
+		return "", fmt.Errorf("unresolved placeholders in entry %s: %s (set them in env or with k6 -e)",
+			entry, strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// splitEntry splits an entry like "flows/ops.js#checkout" into the module
+// path and the name of the export to invoke
+func splitEntry(entry string) (string, string) {
+	if i := strings.LastIndex(entry, "#"); i > 0 {
+		return entry[:i], entry[i+1:]
+	}
+	return entry, ""
+}
+
+// parseRunOptionsFromArgs interprets the second argument to ext.run().
+//
+// If the second argument is a plain value (e.g. { user: "alice" }),
+// it becomes the payload.
+//
+// If it's a map with any of the keys in optionMarkerKeys, it's treated as an
+// options object, and the other options in runOptionKeys are read from it.
+func parseRunOptionsFromArgs(entry string, arg interface{}) (*RunOptions, error) {
+	opts := &RunOptions{
+		Runtime: "",
+		Payload: arg,
+		Env:     make(map[string]string),
+	}
+	opts.Entry, opts.Fn = splitEntry(entry)
+
+	if err := encodeMultipart(arg); err != nil {
+		return nil, err
+	}
+
+	rawMap, ok := arg.(map[string]interface{})
+	if !ok {
+		return opts, nil
+	}
+
+	isOptions := false
+	for _, key := range optionMarkerKeys {
+		if _, ok := rawMap[key]; ok {
+			isOptions = true
+			break
+		}
+	}
+	if !isOptions {
+		return opts, nil
+	}
+	if strictOptions(rawMap) {
+		if err := checkRunOptions(rawMap); err != nil {
+			return nil, err
+		}
+	}
+
+	if v, ok := rawMap["runtime"].(string); ok {
+		opts.Runtime = v
+	}
+
+	if v, ok := rawMap["entry"].(string); ok && v != "" {
+		opts.Entry, opts.Fn = splitEntry(v)
+	}
+
+	if v, ok := rawMap["fn"].(string); ok && v != "" {
+		if opts.Fn != "" && opts.Fn != v {
+			return nil, fmt.Errorf("fn %q conflicts with the #%s export named in the entry", v, opts.Fn)
+		}
+		opts.Fn = v
+	}
+
+	if v, ok := rawMap["args"].([]interface{}); ok {
+		opts.Args = v
+	}
+	if v, ok := rawMap["kwargs"].(map[string]interface{}); ok {
+		opts.Kwargs = v
+	}
+	if (opts.Args != nil || opts.Kwargs != nil) && opts.Fn == "" {
+		return nil, fmt.Errorf("args and kwargs are passed to a named export, they require fn or an entry like ./lib.js#createUser")
+	}
+
+	if v, ok := rawMap["payload"]; ok {
+		opts.Payload = v
+	}
+
+	if v, ok := rawMap["meta"]; ok {
+		opts.Meta = v
+	}
+
+	if v, ok := rawMap["setupData"]; ok {
+		opts.SetupData = v
+	}
+
+	if v, ok := rawMap["timeout"].(string); ok {
+		opts.Timeout = v
+	}
+
+	if v, ok := rawMap["logDir"].(string); ok {
+		opts.LogDir = v
+	}
+
+	if rawWrapper, ok := rawMap["commandWrapper"].([]interface{}); ok {
+		for _, arg := range rawWrapper {
+			s, ok := arg.(string)
+			if !ok {
+				return nil, fmt.Errorf("commandWrapper must be an array of strings, got %T element", arg)
+			}
+			opts.CommandWrapper = append(opts.CommandWrapper, s)
+		}
+	}
+
+	if v, ok := rawMap["envFile"].(string); ok {
+		opts.EnvFile = v
+	}
+
+	switch v := rawMap["maxResultBytes"].(type) {
+	case int64:
+		opts.MaxResultBytes = v
+	case float64:
+		opts.MaxResultBytes = int64(v)
+	}
+	if opts.MaxResultBytes < 0 {
+		return nil, fmt.Errorf("maxResultBytes must not be negative, got %d", opts.MaxResultBytes)
+	}
+
+	if v, ok := rawMap["persistPerVU"].(bool); ok {
+		opts.PersistPerVU = v
+	}
+
+	switch v := rawMap["threads"].(type) {
+	case int64:
+		opts.Threads = int(v)
+	case float64:
+		opts.Threads = int(v)
+	}
+	if v, ok := rawMap["threads"]; ok && v != nil && opts.Threads < 1 {
+		return nil, fmt.Errorf("threads must be at least 1, got %v", v)
+	}
+
+	if v, ok := rawMap["watch"].(bool); ok {
+		opts.Watch = v
+	}
+
+	switch v := rawMap["debug"].(type) {
+	case bool:
+		if v {
+			opts.Debug = defaultDebugAddress
+		}
+	case string:
+		opts.Debug = v
+	}
+
+	if rawRateLimit, ok := rawMap["rateLimit"].(map[string]interface{}); ok {
+		rateLimit, err := parseRateLimitOptions(rawRateLimit)
+		if err != nil {
+			return nil, err
+		}
+		opts.RateLimit = rateLimit
+	}
+
+	if v, ok := rawMap["maxRuns"]; ok && v != nil {
+		maxRuns, err := parseMaxRunsOptions(v)
+		if err != nil {
+			return nil, err
+		}
+		opts.MaxRuns = maxRuns
+	}
+
+	switch v := rawMap["strictStderr"].(type) {
+	case bool:
+		opts.StrictStderr = v
+	case map[string]interface{}:
+		opts.StrictStderr = true
+		if pattern, ok := v["ignore"].(string); ok && pattern != "" {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid strictStderr.ignore pattern: %w", err)
+			}
+			opts.StderrIgnore = re
+		}
+	}
+
+	if v, ok := rawMap["compress"].(bool); ok {
+		opts.Compress = v
+	}
+
+	if v, ok := rawMap["ack"].(bool); ok {
+		opts.Ack = v
+	}
+
+	if v, ok := rawMap["bunCompile"].(bool); ok {
+		opts.BunCompile = v
+	}
+
+	switch v := rawMap["onlyVU"].(type) {
+	case int64:
+		opts.OnlyVU = int(v)
+	case float64:
+		opts.OnlyVU = int(v)
+	}
+	if v, ok := rawMap["onlyVU"]; ok && v != nil && opts.OnlyVU < 1 {
+		return nil, fmt.Errorf("onlyVU must be a VU ID of 1 or more, got %v", v)
+	}
+
+	switch v := rawMap["everyNIterations"].(type) {
+	case int64:
+		opts.EveryNIterations = int(v)
+	case float64:
+		opts.EveryNIterations = int(v)
+	}
+	if v, ok := rawMap["everyNIterations"]; ok && v != nil && opts.EveryNIterations < 1 {
+		return nil, fmt.Errorf("everyNIterations must be 1 or more, got %v", v)
+	}
+
+	switch v := rawMap["retries"].(type) {
+	case int64:
+		opts.Retries = int(v)
+	case float64:
+		opts.Retries = int(v)
+	}
+	if opts.Retries < 0 {
+		return nil, fmt.Errorf("retries must not be negative, got %d", opts.Retries)
+	}
+
+	if v, ok := rawMap["retryBackoff"].(string); ok && v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid retryBackoff value %q", v)
+		}
+		opts.RetryBackoff = d
+	}
+
+	if v, ok := rawMap["retryOn"].(map[string]interface{}); ok {
+		if opts.Retries == 0 {
+			return nil, fmt.Errorf("retryOn chooses which failures are retried, it requires retries")
+		}
+		retryOn, err := parseRetryOn(v)
+		if err != nil {
+			return nil, err
+		}
+		opts.RetryOn = retryOn
+	}
+
+	if v, ok := rawMap["totalTimeout"].(string); ok && v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid totalTimeout value %q", v)
+		}
+		opts.TotalTimeout = d
+	}
+
+	for key, limit := range map[string]*time.Duration{"startupTimeout": &opts.StartupTimeout, "flowTimeout": &opts.FlowTimeout} {
+		if v, ok := rawMap[key].(string); ok && v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid %s value %q", key, v)
+			}
+			*limit = d
+		}
+	}
+
+	if opts.Ack && (opts.Retries > 0 || opts.TotalTimeout > 0) {
+		return nil, fmt.Errorf("retries and totalTimeout are not supported with ack")
+	}
+
+	for key, limit := range map[string]*int64{"maxOutputBytes": &opts.MaxOutputBytes, "maxOutputLines": &opts.MaxOutputLines} {
+		switch v := rawMap[key].(type) {
+		case int64:
+			*limit = v
+		case float64:
+			*limit = int64(v)
+		}
+		if *limit < 0 {
+			return nil, fmt.Errorf("%s must not be negative, got %d", key, *limit)
+		}
+	}
+
+	if v, ok := rawMap["network"].(bool); ok {
+		opts.DenyNetwork = !v
+	}
+
+	if v, ok := rawMap["failOnError"].(bool); ok {
+		opts.FailOnError = v
+	}
+
+	if v, ok := rawMap["logFormat"].(string); ok {
+		if v != "text" && v != "json" {
+			return nil, fmt.Errorf("unsupported logFormat %q (supported: text, json)", v)
+		}
+		opts.LogFormat = v
+	}
+
+	if v, ok := rawMap["passContext"].(bool); ok {
+		opts.OmitContext = !v
+	}
+
+	if v, ok := rawMap["artifactsDir"].(string); ok {
+		opts.ArtifactsDir = v
+	}
+
+	if v, ok := rawMap["umask"]; ok && v != nil {
+		umask, err := parseUmask(v)
+		if err != nil {
+			return nil, err
+		}
+		opts.Umask = &umask
+	}
+
+	if v, ok := rawMap["correlationId"].(bool); ok {
+		opts.TagCorrelationID = v
+	}
+
+	if rawPaths, ok := rawMap["readOnlyFS"].([]interface{}); ok {
+		paths, err := parseReadOnlyFS(rawPaths)
+		if err != nil {
+			return nil, err
+		}
+		opts.ReadOnlyFS = paths
+	}
+
+	if v, ok := rawMap["otlpReceiver"].(bool); ok {
+		opts.OTLPReceiver = v
+	}
+
+	if v, ok := rawMap["profile"].(string); ok && v != "" {
+		if v != "cpu" {
+			return nil, fmt.Errorf("unsupported profile %q (supported: cpu)", v)
+		}
+		opts.Profile = v
+	}
+
+	if rawSelect, ok := rawMap["select"].([]interface{}); ok {
+		paths, err := parseSelect(rawSelect)
+		if err != nil {
+			return nil, err
+		}
+		opts.Select = paths
+	}
+
+	if v, ok := rawMap["cookieJar"]; ok && v != nil {
+		urls, err := parseCookieJarURLs(v)
+		if err != nil {
+			return nil, err
+		}
+		opts.CookieJar = urls
+	}
+
+	if v, ok := rawMap["container"]; ok && v != nil {
+		container, err := parseContainerOptions(v)
+		if err != nil {
+			return nil, err
+		}
+		opts.Container = container
+	}
+
+	if v, ok := rawMap["integrity"].(string); ok && v != "" {
+		if err := parseIntegrity(v); err != nil {
+			return nil, err
+		}
+		opts.Integrity = v
+	}
+
+	if v, ok := rawMap["install"].(bool); ok {
+		opts.Install = v
+	}
+
+	if v, ok := rawMap["logSamples"].(bool); ok {
+		opts.LogSamples = v
+	}
+
+	if rawCPUs, ok := rawMap["cpuAffinity"].([]interface{}); ok && len(rawCPUs) > 0 {
+		cpus, err := parseCPUAffinity(rawCPUs)
+		if err != nil {
+			return nil, err
+		}
+		opts.CPUAffinity = cpus
+	}
+
+	if v, ok := rawMap["encoding"].(string); ok && v != "" {
+		enc, err := parseEncoding(v)
+		if err != nil {
+			return nil, err
+		}
+		opts.Encoding = v
+		opts.outputEncoding = enc
+	}
+
+	if v, ok := rawMap["heartbeat"]; ok && v != nil {
+		interval, err := parseHeartbeat(v)
+		if err != nil {
+			return nil, err
+		}
+		opts.Heartbeat = interval
+	}
+
+	if v, ok := rawMap["metricsSink"].(string); ok && v != "" {
+		if _, err := parseMetricsSink(v); err != nil {
+			return nil, err
+		}
+		opts.MetricsSink = v
+	}
+
+	if v, ok := rawMap["tagRuntimeVersion"].(bool); ok {
+		opts.TagRuntimeVersion = v
+	}
+
+	if rawFallback, ok := rawMap["runtimeFallback"].([]interface{}); ok {
+		for _, runtime := range rawFallback {
+			s, ok := runtime.(string)
+			if !ok {
+				return nil, fmt.Errorf("runtimeFallback must be an array of strings, got %T element", runtime)
+			}
+			opts.RuntimeFallback = append(opts.RuntimeFallback, s)
+		}
+	}
+
+	if rawFiles, ok := rawMap["files"].(map[string]interface{}); ok {
+		opts.Files = make(map[string][]byte, len(rawFiles))
+		for name, content := range rawFiles {
+			data, err := common.ToBytes(content)
+			if err != nil {
+				return nil, fmt.Errorf("content of file %q must be a string or ArrayBuffer: %w", name, err)
+			}
+			opts.Files[name] = data
+		}
+	}
+
+	if v, ok := rawMap["stdin"]; ok && v != nil {
+		data, err := common.ToBytes(v)
+		if err != nil {
+			return nil, fmt.Errorf("stdin must be a string or ArrayBuffer: %w", err)
+		}
+		opts.Stdin = data
+	}
+
+	if v, ok := rawMap["k6compat"].(bool); ok {
+		opts.K6Compat = v
+	}
+
+	if v, ok := rawMap["autoInstrumentHttp"].(bool); ok {
+		opts.AutoInstrumentHTTP = v
+	}
+
+	if v, ok := rawMap["format"].(string); ok {
+		if v != "json" && v != "cbor" {
+			return nil, fmt.Errorf("unsupported format %q (supported: json, cbor)", v)
+		}
+		opts.Format = v
+	}
+
+	if v, ok := rawMap["transport"].(string); ok {
+		if v != "stdout" && v != "socket" {
+			return nil, fmt.Errorf("unsupported transport %q (supported: stdout, socket)", v)
+		}
+		opts.Transport = v
+	}
+
+	if v, ok := rawMap["protocol"].(string); ok && v != "" {
+		if v != "text" && v != "binary" {
+			return nil, fmt.Errorf("unsupported protocol %q (supported: text, binary)", v)
+		}
+		opts.Protocol = v
+	}
+
+	if v, ok := rawMap["numberMode"]; ok && v != nil {
+		mode, err := parseNumberMode(v)
+		if err != nil {
+			return nil, err
+		}
+		opts.NumberMode = mode
+	}
+
+	if v, ok := rawMap["seedEnv"].(string); ok {
+		opts.SeedEnv = v
+	}
+
+	switch v := rawMap["minVersion"].(type) {
+	case string:
+		// Applies to whichever runtime ends up being selected
+		opts.MinVersion = map[string]string{"node": v, "deno": v, "bun": v, "workerd": v}
+	case map[string]interface{}:
+		opts.MinVersion = make(map[string]string, len(v))
+		for runtime, version := range v {
+			if s, ok := version.(string); ok {
+				opts.MinVersion[runtime] = s
+			}
+		}
+	}
+
+	if rawStrip, ok := rawMap["envStrip"].([]interface{}); ok {
+		for _, prefix := range rawStrip {
+			if s, ok := prefix.(string); ok && s != "" {
+				opts.EnvStrip = append(opts.EnvStrip, s)
+			}
+		}
+	}
+
+	if v, ok := rawMap["captureStderr"].(bool); ok {
+		opts.CaptureStderr = v
+	}
+
+	if v, ok := rawMap["resultSchema"]; ok {
+		opts.ResultSchema = v
+	}
+
+	switch v := rawMap["shared"].(type) {
+	case string:
+		opts.Shared = []string{v}
+	case []interface{}:
+		for _, name := range v {
+			if s, ok := name.(string); ok {
+				opts.Shared = append(opts.Shared, s)
+			}
+		}
+	}
+
 	if rawEnv, ok := rawMap["env"].(map[string]interface{}); ok {
 		for k, v := range rawEnv {
-			if s, ok := v.(string); ok {
-				opts.Env[k] = s
+			// Unset values, like __ENV entries that don't exist, are skipped
+			if v == nil {
+				continue
 			}
+			value, err := envValue(k, v)
+			if err != nil {
+				return nil, err
+			}
+			opts.Env[k] = value
 		}
 	}
 
 	return opts, nil
 }
 
+// envValue validates an entry of the env option. Keys must be valid variable
+// names, since a key like "A=B" would produce a different variable. Numbers
+// and booleans are converted to strings.
+func envValue(key string, value interface{}) (string, error) {
+	if !envKeyRegex.MatchString(key) {
+		return "", fmt.Errorf("invalid env key %q: names must start with a letter or underscore "+
+			"and contain only letters, digits and underscores", key)
+	}
+
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		s = strconv.FormatBool(v)
+	default:
+		return "", fmt.Errorf("env value of %s must be a string, number or boolean, got %T", key, value)
+	}
+	if strings.ContainsRune(s, 0) {
+		return "", fmt.Errorf("env value of %s must not contain null bytes", key)
+	}
+	return s, nil
+}
+
+// stripEnv returns the entries of env whose names don't start with any of prefixes
+func stripEnv(env []string, prefixes []string) []string {
+	if len(prefixes) == 0 {
+		return env
+	}
+
+	kept := make([]string, 0, len(env))
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		stripped := false
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(name, prefix) {
+				stripped = true
+				break
+			}
+		}
+		if !stripped {
+			kept = append(kept, kv)
+		}
+	}
+	return kept
+}
+
+// debugArgs adds the flag that makes the runtime wait for a debugger when
+// opts.Debug is set. Deno takes it after the run subcommand.
+func debugArgs(opts *RunOptions, args ...string) []string {
+	if opts.Debug == "" {
+		return args
+	}
+	flag := "--inspect-brk=" + opts.Debug
+	if opts.Runtime == "deno" {
+		return append([]string{args[0], flag}, args[1:]...)
+	}
+	return append([]string{flag}, args...)
+}
+
+// wrapCommand returns a copy of cmd prefixed with wrapper, so `node -e ...`
+// becomes `<wrapper...> node -e ...`. Stdin and the working directory are kept.
+func wrapCommand(ctx context.Context, wrapper []string, cmd *exec.Cmd) *exec.Cmd {
+	args := append(append([]string{}, wrapper[1:]...), cmd.Args...)
+	wrapped := exec.CommandContext(ctx, wrapper[0], args...)
+	wrapped.Stdin = cmd.Stdin
+	wrapped.Dir = cmd.Dir
+	return wrapped
+}
+
+// lockedWriter serializes writes so stdout and stderr can share a writer
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// limitWriter fails writes once more than limit bytes were written, calling
+// onExceed the first time so the process producing the output can be stopped.
+type limitWriter struct {
+	w        io.Writer
+	limit    int64
+	written  int64
+	exceeded bool
+	onExceed func()
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if l.exceeded {
+		return 0, errOutputLimit
+	}
+	if l.written+int64(len(p)) > l.limit {
+		l.exceeded = true
+		l.onExceed()
+		return 0, errOutputLimit
+	}
+	l.written += int64(len(p))
+	return l.w.Write(p)
+}
+
+// errOutputLimit is returned by limitWriter once its limit is exceeded
+var errOutputLimit = errors.New("output limit exceeded")
+
+// logFileNameRegex matches characters that are not safe in log file names
+var logFileNameRegex = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// openLogFile opens the per-invocation log file <entry>-<vu>-<iter>.log inside dir.
+// Output from repeated calls within the same iteration is appended.
+func (j *ExternalJS) openLogFile(dir, entry string) (*os.File, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory %q: %w", dir, err)
+	}
+
+	var vuID, iteration int64
+	if state := j.vu.State(); state != nil {
+		vuID = int64(state.VUID)
+		iteration = state.Iteration
+	}
+
+	name := strings.Trim(logFileNameRegex.ReplaceAllString(entry, "_"), "._")
+	path := filepath.Join(dir, fmt.Sprintf("%s-%d-%d.log", name, vuID, iteration))
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file %q: %w", path, err)
+	}
+	return f, nil
+}
+
+// validateResult validates result against a JSON Schema. Compiled schemas are
+// cached by their JSON encoding so each one is compiled once per VU.
+func (j *ExternalJS) validateResult(schema interface{}, result map[string]interface{}) error {
+	schemaBytes, err := json.Marshal(schema)
+	if err != nil {
+		return fmt.Errorf("failed to marshal resultSchema: %w", err)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	compiled, exists := j.resultSchemas[string(schemaBytes)]
+	if !exists {
+		doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(schemaBytes))
+		if err != nil {
+			return fmt.Errorf("invalid resultSchema: %w", err)
+		}
+		compiler := jsonschema.NewCompiler()
+		if err := compiler.AddResource("mem:///resultSchema.json", doc); err != nil {
+			return fmt.Errorf("invalid resultSchema: %w", err)
+		}
+		compiled, err = compiler.Compile("mem:///resultSchema.json")
+		if err != nil {
+			return fmt.Errorf("invalid resultSchema: %w", err)
+		}
+		j.resultSchemas[string(schemaBytes)] = compiled
+	}
+
+	return compiled.Validate(result)
+}
+
 // extractMetricValue converts interface{} to float64 for metrics
 func (j *ExternalJS) extractMetricValue(value interface{}) float64 {
 	switch v := value.(type) {
@@ -417,6 +2721,8 @@ func (j *ExternalJS) extractMetricValue(value interface{}) float64 {
 		return float64(v)
 	case int64:
 		return float64(v)
+	case uint64:
+		return float64(v)
 	case json.Number:
 		f, _ := v.Float64()
 		return f
@@ -425,7 +2731,7 @@ func (j *ExternalJS) extractMetricValue(value interface{}) float64 {
 	}
 }
 
-// detectRuntimeFromFilename detects the runtime from filename patterns like *.node.js, *.deno.ts, *.bun.js.
+// detectRuntimeFromFilename detects the runtime from filename patterns like *.node.js, *.deno.ts, *.bun.js, *.worker.js.
 // The runtime identifier must appear immediately before the file extension.
 func detectRuntimeFromFilename(filename string) string {
 	if filename == "" {
@@ -433,32 +2739,63 @@ func detectRuntimeFromFilename(filename string) string {
 	}
 
 	lower := strings.ToLower(filename)
-	runtimeRegex := regexp.MustCompile(`\.(node|deno|bun)\.(js|ts|mjs|cjs)$`)
+	runtimeRegex := regexp.MustCompile(`\.(node|deno|bun|worker)\.(js|ts|mjs|cjs)$`)
 	matches := runtimeRegex.FindStringSubmatch(lower)
 
 	if len(matches) >= 2 {
+		if matches[1] == "worker" {
+			return "workerd"
+		}
 		return matches[1]
 	}
 
 	return ""
 }
 
+// marshalJSON encodes v like json.Marshal, except that <, > and & are kept
+// as is instead of being escaped to \u003c and the like, so flows that handle
+// markup or URLs in their payload see the same text the script passed.
+func marshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	// Encode terminates the value with a newline
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
 // extractResult parses the JSON result from external JavaScript runtime output
 func extractResult(output string) (map[string]interface{}, error) {
-	// Find content between __RESULT_START__ and __RESULT_END__
-	re := regexp.MustCompile(`__RESULT_START__\s*([\s\S]*?)\s*__RESULT_END__`)
-	matches := re.FindStringSubmatch(output)
-
-	if len(matches) < 2 {
-		return nil, fmt.Errorf("result markers not found in output")
+	resultJSON, err := extractMarkedResult(output)
+	if err != nil {
+		return nil, err
 	}
 
-	resultJSON := strings.TrimSpace(matches[1])
-
-	var result map[string]interface{}
-	if err := json.Unmarshal([]byte(resultJSON), &result); err != nil {
+	result, err := unmarshalResult([]byte(resultJSON))
+	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal result: %w", err)
 	}
 
 	return result, nil
 }
+
+// ansiEscapeRegex matches ANSI escape sequences: CSI sequences like colors,
+// OSC sequences like hyperlinks, and two-character escapes
+var ansiEscapeRegex = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+// extractMarkedResult returns the text between the __RESULT_START__ and __RESULT_END__ markers.
+// ANSI escape sequences are removed, since tools that color their output can
+// leave them around the markers. The encoded result never contains raw escape
+// characters, so this can't change it.
+func extractMarkedResult(output string) (string, error) {
+	re := regexp.MustCompile(`__RESULT_START__\s*([\s\S]*?)\s*__RESULT_END__`)
+	matches := re.FindStringSubmatch(output)
+
+	if len(matches) < 2 {
+		return "", fmt.Errorf("result markers not found in output")
+	}
+
+	return strings.TrimSpace(ansiEscapeRegex.ReplaceAllString(matches[1], "")), nil
+}
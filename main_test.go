@@ -0,0 +1,229 @@
+package js
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/grafana/sobek"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"go.k6.io/k6/js/common"
+	"go.k6.io/k6/js/modulestest"
+	"go.k6.io/k6/lib"
+	"go.k6.io/k6/metrics"
+)
+
+// newTestInstance returns a module instance for a VU that's running an
+// iteration, and the channel its samples are pushed to
+func newTestInstance(t *testing.T) (*ExternalJS, *lib.State, chan metrics.SampleContainer) {
+	t.Helper()
+
+	registry := metrics.NewRegistry()
+	vu := &modulestest.VU{
+		CtxField:     context.Background(),
+		RuntimeField: sobek.New(),
+		InitEnvField: &common.InitEnvironment{TestPreInitState: &lib.TestPreInitState{Registry: registry}},
+	}
+	module := &ExternalJSModule{}
+	j := module.NewModuleInstance(vu).(*ExternalJS)
+	t.Cleanup(func() { module.pool.shutdown(workerShutdownGrace) })
+
+	samples := make(chan metrics.SampleContainer, 1000)
+	state := &lib.State{
+		VUID:    1,
+		Samples: samples,
+		Logger:  logrus.New(),
+		Tags:    lib.NewVUStateTags(registry.RootTagSet().With("scenario", "default")),
+	}
+	vu.InitEnvField = nil
+	vu.StateField = state
+	return j, state, samples
+}
+
+// writeFlow writes a node flow to a temp dir, skipping the test when node
+// isn't installed
+func writeFlow(t *testing.T, source string) string {
+	t.Helper()
+
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node is not installed")
+	}
+	path := filepath.Join(t.TempDir(), "flow.node.js")
+	if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// drainSamples returns the samples pushed so far
+func drainSamples(samples chan metrics.SampleContainer) []metrics.Sample {
+	var all []metrics.Sample
+	for {
+		select {
+		case container := <-samples:
+			all = append(all, container.GetSamples()...)
+		default:
+			return all
+		}
+	}
+}
+
+func TestPushCustomMetrics(t *testing.T) {
+	tests := []struct {
+		name        string
+		metric      map[string]interface{}
+		wantValues  []float64
+		wantWarning bool
+	}{
+		{
+			name:       "counter step above 1",
+			metric:     map[string]interface{}{"name": "orders", "type": "counter", "value": 5.0},
+			wantValues: []float64{5},
+		},
+		{
+			name:        "counter negative skipped",
+			metric:      map[string]interface{}{"name": "orders", "type": "counter", "value": -3.0},
+			wantWarning: true,
+		},
+		{
+			name:       "gauge negative",
+			metric:     map[string]interface{}{"name": "temperature", "type": "gauge", "value": -12.5},
+			wantValues: []float64{-12.5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j, state, samples := newTestInstance(t)
+			logger, hook := logtest.NewNullLogger()
+			state.Logger = logger
+
+			j.pushCustomMetrics(state, []interface{}{tt.metric}, nil, nil)
+
+			var values []float64
+			for _, sample := range drainSamples(samples) {
+				if sample.Metric.Name == tt.metric["name"] {
+					values = append(values, sample.Value)
+				}
+			}
+			if !slices.Equal(values, tt.wantValues) {
+				t.Errorf("pushed %v, want %v", values, tt.wantValues)
+			}
+
+			warned := false
+			for _, entry := range hook.AllEntries() {
+				if entry.Level == logrus.WarnLevel && strings.Contains(entry.Message, "negative value") {
+					warned = true
+				}
+			}
+			if warned != tt.wantWarning {
+				t.Errorf("warned about a negative value: %v, want %v", warned, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestFnMetricsAndChecks(t *testing.T) {
+	entry := writeFlow(t, `
+module.exports.handler = async () => ({});
+module.exports.order = async (ctx) => {
+  metrics.counter("orders").add(3);
+  checks.check("order placed", true);
+  return { placed: true };
+};
+module.exports.total = async (qty) => {
+  metrics.counter("orders").add(qty);
+  checks.check("order placed", qty > 0);
+  return qty * 10;
+};
+`)
+	tests := []struct {
+		name       string
+		options    map[string]interface{}
+		wantKey    string
+		wantValue  interface{}
+		wantOrders float64
+	}{
+		{
+			name:       "ctx",
+			options:    map[string]interface{}{"payload": nil, "fn": "order"},
+			wantKey:    "placed",
+			wantValue:  true,
+			wantOrders: 3,
+		},
+		{
+			name:       "args",
+			options:    map[string]interface{}{"payload": nil, "fn": "total", "args": []interface{}{2}},
+			wantKey:    "value",
+			wantValue:  float64(20),
+			wantOrders: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j, _, samples := newTestInstance(t)
+
+			result, err := j.Run(entry, tt.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result[tt.wantKey] != tt.wantValue {
+				t.Errorf("result[%q] = %v, want %v", tt.wantKey, result[tt.wantKey], tt.wantValue)
+			}
+
+			var orders, checks []float64
+			for _, sample := range drainSamples(samples) {
+				switch sample.Metric.Name {
+				case "orders":
+					orders = append(orders, sample.Value)
+				case "checks":
+					if check, _ := sample.Tags.Get("check"); check == "order placed" {
+						checks = append(checks, sample.Value)
+					}
+				}
+			}
+			if !slices.Equal(orders, []float64{tt.wantOrders}) {
+				t.Errorf("pushed orders %v, want [%v]", orders, tt.wantOrders)
+			}
+			if !slices.Equal(checks, []float64{1}) {
+				t.Errorf("pushed checks %v, want [1]", checks)
+			}
+		})
+	}
+}
+
+func TestFnValueResult(t *testing.T) {
+	entry := writeFlow(t, `
+module.exports.handler = async () => ({});
+module.exports.answer = async () => 42;
+module.exports.list = async (ctx) => [ctx.payload.n, 2];
+`)
+	tests := []struct {
+		name    string
+		entry   string
+		payload interface{}
+		want    interface{}
+	}{
+		{name: "primitive", entry: entry + "#answer", want: float64(42)},
+		{name: "array", entry: entry + "#list", payload: map[string]interface{}{"n": 1.0}, want: []interface{}{float64(1), float64(2)}},
+		{name: "args", entry: entry + "#answer", payload: map[string]interface{}{"payload": nil, "args": []interface{}{}}, want: float64(42)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j, _, _ := newTestInstance(t)
+
+			result, err := j.Run(tt.entry, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result["value"], tt.want) {
+				t.Errorf("result is %v, want value %v", result, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package js
+
+import (
+	"fmt"
+	"sync"
+)
+
+// matrixIndexKey is added to each result of RunMatrix with the index of its payload
+const matrixIndexKey = "__matrix_index__"
+
+// RunMatrix runs a flow once per payload and returns the results in the order
+// of the payloads, for data-driven tests where the flow is fixed and the data
+// varies:
+//
+//	const results = ext.runMatrix("./lib.js", [{ user: "alice" }, { user: "bob" }], {
+//	  timeout: "5s",
+//	  concurrency: 4, // optional, how many runs may be in flight at once (1 by default)
+//	});
+//
+// The options are the same as for Run, without payload, and apply to every
+// run. Each result has __matrix_index__ set to the index of its payload. The
+// first failing run stops new runs from starting and its error is returned
+// once the ones in flight finish.
+func (j *ExternalJS) RunMatrix(flowPath string, payloads []interface{}, options map[string]interface{}) ([]map[string]interface{}, error) {
+	concurrency := 1
+	switch v := options["concurrency"].(type) {
+	case nil:
+	case int64:
+		concurrency = int(v)
+	case float64:
+		concurrency = int(v)
+	default:
+		return nil, fmt.Errorf("matrix concurrency must be a number, got %T", v)
+	}
+	if concurrency < 1 {
+		return nil, fmt.Errorf("matrix concurrency must be at least 1, got %d", concurrency)
+	}
+	if _, ok := options["payload"]; ok {
+		return nil, fmt.Errorf("matrix options must not set payload, it comes from the payloads")
+	}
+
+	// Options are parsed up front, since JS values must not be used off the
+	// event loop
+	runs := make([]*RunOptions, len(payloads))
+	for i, payload := range payloads {
+		runOptions := make(map[string]interface{}, len(options)+1)
+		for key, value := range options {
+			if key != "concurrency" {
+				runOptions[key] = value
+			}
+		}
+		runOptions["payload"] = payload
+		opts, err := parseRunOptionsFromArgs(flowPath, runOptions)
+		if err != nil {
+			return nil, fmt.Errorf("matrix payload %d of %s: %w", i, flowPath, err)
+		}
+		runs[i] = opts
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		slots    = make(chan struct{}, concurrency)
+		results  = make([]map[string]interface{}, len(runs))
+	)
+	for i, opts := range runs {
+		slots <- struct{}{}
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			result, err := j.execute(flowPath, opts)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("matrix run %d of %s failed: %w", i, flowPath, err)
+				}
+				return
+			}
+			if result == nil {
+				result = make(map[string]interface{})
+			}
+			result[matrixIndexKey] = i
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return results, nil
+}
@@ -0,0 +1,68 @@
+package js
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// errMaxRuns marks calls refused because their entry used up maxRuns
+var errMaxRuns = errors.New("max runs reached")
+
+// MaxRunsOptions caps how many times a flow is run across the whole test
+type MaxRunsOptions struct {
+	// Limit is the number of runs of the entry allowed across all VUs
+	Limit int64 `json:"limit"`
+	// Mode is what happens to calls once the limit is reached: "error"
+	// (default) fails them, "skip" returns { __skipped__: true }
+	Mode string `json:"mode"`
+}
+
+// runBudgets counts the runs of each entry across all VUs, for maxRuns
+type runBudgets struct {
+	mu   sync.Mutex
+	runs map[string]int64
+}
+
+// take counts a run of entry if it's within limit, and returns how many runs
+// are left after it
+func (b *runBudgets) take(entry string, limit int64) (remaining int64, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.runs == nil {
+		b.runs = make(map[string]int64)
+	}
+	if b.runs[entry] >= limit {
+		return 0, false
+	}
+	b.runs[entry]++
+	return limit - b.runs[entry], true
+}
+
+// parseMaxRunsOptions interprets the maxRuns option, either the limit or an
+// object with limit and mode
+func parseMaxRunsOptions(raw interface{}) (*MaxRunsOptions, error) {
+	opts := &MaxRunsOptions{Mode: "error"}
+
+	rawLimit := raw
+	if m, ok := raw.(map[string]interface{}); ok {
+		rawLimit = m["limit"]
+		if v, ok := m["mode"].(string); ok {
+			if v != "error" && v != "skip" {
+				return nil, fmt.Errorf("unsupported maxRuns.mode %q (supported: error, skip)", v)
+			}
+			opts.Mode = v
+		}
+	}
+	switch v := rawLimit.(type) {
+	case int64:
+		opts.Limit = v
+	case float64:
+		opts.Limit = int64(v)
+	}
+	if opts.Limit < 1 {
+		return nil, fmt.Errorf("maxRuns must be a limit of 1 or more, got %v", rawLimit)
+	}
+	return opts, nil
+}
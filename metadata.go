@@ -0,0 +1,138 @@
+package js
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.k6.io/k6/lib"
+	"go.k6.io/k6/metrics"
+)
+
+// metadataKey is how flows report facts about the environment under test,
+// like the backend's version, as an object of keys and values
+const metadataKey = "__k6_metadata__"
+
+// runMetadata is what flows reported in __k6_metadata__ over the whole test,
+// shared by all VUs. A key keeps the last value reported for it.
+type runMetadata struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+// record stores values and returns the ones that are new or changed
+func (r *runMetadata) record(values map[string]string) map[string]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.values == nil {
+		r.values = make(map[string]string)
+	}
+	changed := make(map[string]string)
+	for key, value := range values {
+		if old, ok := r.values[key]; ok && old == value {
+			continue
+		}
+		r.values[key] = value
+		changed[key] = value
+	}
+	return changed
+}
+
+// snapshot returns a copy of the recorded values
+func (r *runMetadata) snapshot() map[string]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	values := make(map[string]string, len(r.values))
+	for key, value := range r.values {
+		values[key] = value
+	}
+	return values
+}
+
+// String lists the recorded values as key=value, sorted by key
+func (r *runMetadata) String() string {
+	values := r.snapshot()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + "=" + values[key]
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// parseMetadata reads a __k6_metadata__ value. Strings are kept as they are,
+// other values as JSON.
+func parseMetadata(raw interface{}) (map[string]string, error) {
+	fields, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be an object, got %T", metadataKey, raw)
+	}
+
+	values := make(map[string]string, len(fields))
+	for key, value := range fields {
+		if s, ok := value.(string); ok {
+			values[key] = s
+			continue
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value for %s: %w", metadataKey, key, err)
+		}
+		values[key] = string(data)
+	}
+	return values, nil
+}
+
+// recordMetadata adds values to the run's metadata. Each value that's new or
+// changed is logged and recorded as an external_js_run_metadata sample tagged
+// with its key, with the value in the sample's metadata, so outputs get it
+// once instead of on every sample.
+func (j *ExternalJS) recordMetadata(state *lib.State, opts *RunOptions, values map[string]string) {
+	changed := j.module.metadata.record(values)
+	now := time.Now()
+	for key, value := range changed {
+		j.logger().Infof("external_js: %s reported %s=%s", opts.Entry, key, value)
+		if state == nil {
+			continue
+		}
+		j.pushSample(state, metrics.Sample{
+			TimeSeries: metrics.TimeSeries{
+				Metric: j.metadataValues,
+				Tags: state.Tags.GetCurrentValues().Tags.WithTagsFromMap(
+					map[string]string{"flow": opts.Entry, "key": key},
+				),
+			},
+			Time:     now,
+			Value:    1,
+			Metadata: map[string]string{"value": value},
+		})
+	}
+}
+
+// RunMetadata returns what flows reported in __k6_metadata__ so far, across
+// all VUs, e.g. for handleSummary() to include in the report
+func (j *ExternalJS) RunMetadata() map[string]string {
+	return j.module.metadata.snapshot()
+}
+
+// RunMetadata returns what flows reported in __k6_metadata__ so far, for Go
+// code embedding the extension
+func (m *ExternalJSModule) RunMetadata() map[string]string {
+	return m.metadata.snapshot()
+}
+
+// RunMetadata returns the run metadata of the module registered as
+// k6/x/external_js. See ExternalJSModule.RunMetadata.
+func RunMetadata() map[string]string {
+	return rootModule.RunMetadata()
+}
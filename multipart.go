@@ -0,0 +1,94 @@
+package js
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"go.k6.io/k6/js/common"
+)
+
+// multipartKey marks a payload object the runner turns into a FormData
+const multipartKey = "__multipart__"
+
+// encodeMultipart prepares every { __multipart__: [...] } object in v for the
+// runner, in place. Each part has a name, an optional filename and
+// contentType, and data given as a string or ArrayBuffer:
+//
+//	{ __multipart__: [{ name: "avatar", filename: "a.png", contentType: "image/png", data: open("./a.png", "b") }] }
+//
+// Binary data can't be sent as JSON, so it's base64-encoded and marked with
+// encoding: "base64" for the runner to decode into a Blob. Strings already
+// marked that way are passed through.
+func encodeMultipart(v interface{}) error {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		if rawParts, ok := val[multipartKey]; ok {
+			parts, ok := rawParts.([]interface{})
+			if !ok {
+				return fmt.Errorf("%s must be an array of parts, got %T", multipartKey, rawParts)
+			}
+			for i, rawPart := range parts {
+				part, err := encodeMultipartPart(rawPart)
+				if err != nil {
+					return fmt.Errorf("%s part %d: %w", multipartKey, i, err)
+				}
+				parts[i] = part
+			}
+			return nil
+		}
+		for _, item := range val {
+			if err := encodeMultipart(item); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range val {
+			if err := encodeMultipart(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// encodeMultipartPart validates a part and encodes its data
+func encodeMultipartPart(raw interface{}) (map[string]interface{}, error) {
+	part, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("must be an object, got %T", raw)
+	}
+
+	name, _ := part["name"].(string)
+	if name == "" {
+		return nil, fmt.Errorf("name must be a non-empty string")
+	}
+	encoded := map[string]interface{}{"name": name}
+	for _, key := range []string{"filename", "contentType"} {
+		if value, ok := part[key]; ok && value != nil {
+			s, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s of %s must be a string, got %T", key, name, value)
+			}
+			encoded[key] = s
+		}
+	}
+
+	switch data := part["data"].(type) {
+	case string:
+		encoded["data"] = data
+		// Already encoded, e.g. by the script or an earlier call with the same payload
+		if part["encoding"] == "base64" {
+			encoded["encoding"] = "base64"
+		}
+	case nil:
+		return nil, fmt.Errorf("data of %s is missing", name)
+	default:
+		bytes, err := common.ToBytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("data of %s must be a string or ArrayBuffer: %w", name, err)
+		}
+		encoded["data"] = base64.StdEncoding.EncodeToString(bytes)
+		encoded["encoding"] = "base64"
+	}
+	return encoded, nil
+}
@@ -0,0 +1,101 @@
+package js
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strconv"
+)
+
+// numberModeKey is how a flow declares the numberMode of its result, for
+// calls that don't pass one
+const numberModeKey = "__k6_number_mode__"
+
+// numberModes are how the numbers in a flow's result can reach the script
+var numberModes = []string{"number", "string", "bigint"}
+
+// maxSafeInteger is the largest integer JS numbers hold exactly, 2^53-1
+var maxSafeInteger = big.NewInt(1<<53 - 1)
+
+// parseNumberMode checks a numberMode value
+func parseNumberMode(raw interface{}) (string, error) {
+	mode, ok := raw.(string)
+	if !ok || !slices.Contains(numberModes, mode) {
+		return "", fmt.Errorf("numberMode must be one of number, string or bigint, got %v", raw)
+	}
+	return mode, nil
+}
+
+// unmarshalResult decodes a JSON result, keeping its numbers as json.Number
+// so convertNumbers can decode them without losing digits
+func unmarshalResult(data []byte) (map[string]interface{}, error) {
+	var result map[string]interface{}
+	if err := unmarshalNumbers(data, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// unmarshalNumbers is json.Unmarshal with numbers kept as json.Number
+func unmarshalNumbers(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if decoder.More() {
+		return fmt.Errorf("invalid character after the top-level value")
+	}
+	return nil
+}
+
+// convertNumbers replaces the numbers in v, as decoded by unmarshalResult or
+// from CBOR, as mode says:
+//   - "number" (or "") decodes them as float64, like encoding/json does
+//   - "string" keeps their digits as a string
+//   - "bigint" decodes integers JS numbers can't hold exactly as *big.Int,
+//     which the script gets as a BigInt, and the others as float64
+func convertNumbers(v interface{}, mode string) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, item := range val {
+			val[k] = convertNumbers(item, mode)
+		}
+		return val
+	case []interface{}:
+		for i, item := range val {
+			val[i] = convertNumbers(item, mode)
+		}
+		return val
+	case json.Number:
+		switch mode {
+		case "string":
+			return val.String()
+		case "bigint":
+			if n, ok := new(big.Int).SetString(val.String(), 10); ok && n.CmpAbs(maxSafeInteger) > 0 {
+				return n
+			}
+		}
+		f, _ := strconv.ParseFloat(val.String(), 64)
+		return f
+	case float64:
+		if mode == "string" {
+			return formatNumber(val)
+		}
+		return val
+	default:
+		return v
+	}
+}
+
+// formatNumber formats f without an exponent, unless it's too large or
+// small for that to be readable
+func formatNumber(f float64) string {
+	if abs := math.Abs(f); abs != 0 && (abs >= 1e21 || abs < 1e-6) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,79 @@
+package js
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestNumberModeWithResultSchema(t *testing.T) {
+	schema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"id":    map[string]interface{}{"type": "integer"},
+			"price": map[string]interface{}{"type": "number"},
+		},
+		"required": []interface{}{"id", "price"},
+	}
+	raw := []byte(`{"id": 12345678901234567890, "price": 1.5, "__k6_iterations__": 2}`)
+
+	tests := []struct {
+		mode      string
+		wantID    interface{}
+		wantPrice interface{}
+	}{
+		{mode: "", wantID: 12345678901234567890.0, wantPrice: 1.5},
+		{mode: "number", wantID: 12345678901234567890.0, wantPrice: 1.5},
+		{mode: "string", wantID: "12345678901234567890", wantPrice: "1.5"},
+		{mode: "bigint", wantID: "12345678901234567890", wantPrice: 1.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			j, _, _ := newTestInstance(t)
+			result, err := unmarshalResult(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			opts := &RunOptions{Entry: "flow.js", ResultSchema: schema, NumberMode: tt.mode}
+			result, err = j.processResult(opts, result, "")
+			if err != nil {
+				t.Fatalf("processResult: %v", err)
+			}
+
+			id := result["id"]
+			if n, ok := id.(*big.Int); ok {
+				id = n.String()
+			} else if tt.mode == "bigint" {
+				t.Errorf("id is %T, want *big.Int", result["id"])
+			}
+			if id != tt.wantID {
+				t.Errorf("id is %T %v, want %T %v", result["id"], result["id"], tt.wantID, tt.wantID)
+			}
+			if result["price"] != tt.wantPrice {
+				t.Errorf("price is %T %v, want %T %v", result["price"], result["price"], tt.wantPrice, tt.wantPrice)
+			}
+		})
+	}
+}
+
+func TestNumberModeSchemaMismatch(t *testing.T) {
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"id": map[string]interface{}{"type": "string"}},
+	}
+	for _, mode := range numberModes {
+		t.Run(mode, func(t *testing.T) {
+			j, _, _ := newTestInstance(t)
+			result, err := unmarshalResult([]byte(`{"id": 12345678901234567890}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The schema checks the numbers the flow returned, whatever the script gets
+			opts := &RunOptions{Entry: "flow.js", ResultSchema: schema, NumberMode: mode}
+			if _, err := j.processResult(opts, result, ""); err == nil {
+				t.Error("expected a resultSchema error")
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package js
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// onceCache holds the results of RunOnce calls, shared by all VUs
+type onceCache struct {
+	mu      sync.Mutex
+	entries map[string]*onceEntry
+}
+
+// onceEntry is a single RunOnce result. Its own lock makes concurrent
+// callers wait for the first run instead of starting their own.
+type onceEntry struct {
+	mu     sync.Mutex
+	done   bool
+	result map[string]interface{}
+}
+
+// entry returns the entry for key, creating it if needed
+func (c *onceCache) entry(key string) *onceEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]*onceEntry)
+	}
+	e, ok := c.entries[key]
+	if !ok {
+		e = &onceEntry{}
+		c.entries[key] = e
+	}
+	return e
+}
+
+// RunOnce runs a flow at most once per test for the same flow and arguments,
+// and returns a copy of that result to every later call. It's meant for
+// one-time work like seeding data or authenticating:
+//
+//	export function setup() {
+//	  return ext.runOnce("./seed.js", { payload: { users: 10 } });
+//	}
+//
+// It is safe in setup() and in the init context, where there's no VU state:
+// the flow runs without emitting any metrics there. Failed runs aren't
+// cached, so a later call tries again.
+func (j *ExternalJS) RunOnce(flowPath string, payloadOrOptions interface{}) (map[string]interface{}, error) {
+	args, err := json.Marshal(payloadOrOptions)
+	if err != nil {
+		return nil, fmt.Errorf("runOnce arguments must be serializable to JSON: %w", err)
+	}
+
+	e := j.module.once.entry(flowPath + "\x00" + string(args))
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.done {
+		result, err := j.Run(flowPath, payloadOrOptions)
+		if err != nil {
+			return nil, err
+		}
+		e.result = result
+		e.done = true
+	}
+
+	// Every caller gets its own copy, since VUs may modify their result
+	return copyValue(e.result).(map[string]interface{}), nil
+}
+
+// copyValue deep copies maps and slices produced by result decoding. Other
+// values are immutable and returned as is.
+func copyValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			copied[k] = copyValue(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(val))
+		for i, item := range val {
+			copied[i] = copyValue(item)
+		}
+		return copied
+	default:
+		return val
+	}
+}
@@ -0,0 +1,216 @@
+package js
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// strictOptionsEnvVar turns on strictOptions for every call when set to "true"
+const strictOptionsEnvVar = "XK6_EXTERNAL_JS_STRICT_OPTIONS"
+
+// optionKind is a set of JS types an option accepts
+type optionKind int
+
+const (
+	kindString optionKind = 1 << iota
+	kindBool
+	kindNumber
+	kindArray
+	kindObject
+)
+
+// runOptionKinds are the types each option accepts. Options missing here,
+// like payload and meta, accept any value. null and undefined are accepted
+// for every option and leave it unset.
+var runOptionKinds = map[string]optionKind{
+	"runtime":            kindString,
+	"entry":              kindString,
+	"fn":                 kindString,
+	"timeout":            kindString,
+	"logDir":             kindString,
+	"commandWrapper":     kindArray,
+	"envFile":            kindString,
+	"maxResultBytes":     kindNumber,
+	"persistPerVU":       kindBool,
+	"threads":            kindNumber,
+	"watch":              kindBool,
+	"debug":              kindBool | kindString,
+	"rateLimit":          kindObject,
+	"strictStderr":       kindBool | kindObject,
+	"compress":           kindBool,
+	"ack":                kindBool,
+	"bunCompile":         kindBool,
+	"onlyVU":             kindNumber,
+	"everyNIterations":   kindNumber,
+	"retries":            kindNumber,
+	"retryBackoff":       kindString,
+	"totalTimeout":       kindString,
+	"maxOutputBytes":     kindNumber,
+	"maxOutputLines":     kindNumber,
+	"network":            kindBool,
+	"failOnError":        kindBool,
+	"logFormat":          kindString,
+	"passContext":        kindBool,
+	"artifactsDir":       kindString,
+	"umask":              kindString | kindNumber,
+	"correlationId":      kindBool,
+	"select":             kindArray,
+	"cookieJar":          kindString | kindArray,
+	"container":          kindString | kindObject,
+	"integrity":          kindString,
+	"cpuAffinity":        kindArray,
+	"encoding":           kindString,
+	"heartbeat":          kindBool | kindString,
+	"metricsSink":        kindString,
+	"tagRuntimeVersion":  kindBool,
+	"runtimeFallback":    kindArray,
+	"files":              kindObject,
+	"autoInstrumentHttp": kindBool,
+	"format":             kindString,
+	"transport":          kindString,
+	"seedEnv":            kindString,
+	"minVersion":         kindString | kindObject,
+	"envStrip":           kindArray,
+	"captureStderr":      kindBool,
+	"shared":             kindString | kindArray,
+	"env":                kindObject,
+	"k6compat":           kindBool,
+	"strictOptions":      kindBool,
+	"readOnlyFS":         kindArray,
+	"otlpReceiver":       kindBool,
+	"args":               kindArray,
+	"kwargs":             kindObject,
+	"profile":            kindString,
+	"startupTimeout":     kindString,
+	"flowTimeout":        kindString,
+	"protocol":           kindString,
+	"retryOn":            kindObject,
+	"install":            kindBool,
+	"maxRuns":            kindNumber | kindObject,
+	"numberMode":         kindString,
+	"logSamples":         kindBool,
+}
+
+// strictOptions reports whether the options in rawMap must be checked with
+// checkRunOptions
+func strictOptions(rawMap map[string]interface{}) bool {
+	if v, ok := rawMap["strictOptions"].(bool); ok {
+		return v
+	}
+	return os.Getenv(strictOptionsEnvVar) == "true"
+}
+
+// checkRunOptions rejects unknown keys in an options object, e.g. a timout
+// typo, and values of the wrong type, which are otherwise ignored
+func checkRunOptions(rawMap map[string]interface{}) error {
+	known := make(map[string]bool, len(runOptionKeys)+1)
+	for _, key := range runOptionKeys {
+		known[key] = true
+	}
+	// entry is accepted, but doesn't make an object options on its own
+	known["entry"] = true
+
+	keys := make([]string, 0, len(rawMap))
+	for key := range rawMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if !known[key] {
+			if suggestion := closestOption(key, known); suggestion != "" {
+				return fmt.Errorf("unknown option %q, did you mean %q?", key, suggestion)
+			}
+			return fmt.Errorf("unknown option %q", key)
+		}
+
+		kind, ok := runOptionKinds[key]
+		value := rawMap[key]
+		if !ok || value == nil {
+			continue
+		}
+		if got := valueKind(value); got&kind == 0 {
+			return fmt.Errorf("option %s must be %s, got %s", key, describeKind(kind), describeKind(got))
+		}
+	}
+	return nil
+}
+
+// valueKind returns the kind of an exported JS value, or 0 for values like
+// ArrayBuffers that aren't one of the kinds
+func valueKind(value interface{}) optionKind {
+	switch value.(type) {
+	case string:
+		return kindString
+	case bool:
+		return kindBool
+	case int64, float64:
+		return kindNumber
+	case []interface{}:
+		return kindArray
+	case map[string]interface{}:
+		return kindObject
+	default:
+		return 0
+	}
+}
+
+// describeKind names the types in kind, e.g. "a boolean or a string"
+func describeKind(kind optionKind) string {
+	var names []string
+	for _, k := range []struct {
+		kind optionKind
+		name string
+	}{
+		{kindBool, "a boolean"},
+		{kindNumber, "a number"},
+		{kindString, "a string"},
+		{kindArray, "an array"},
+		{kindObject, "an object"},
+	} {
+		if kind&k.kind != 0 {
+			names = append(names, k.name)
+		}
+	}
+	if len(names) == 0 {
+		return "an unsupported value"
+	}
+	return strings.Join(names, " or ")
+}
+
+// closestOption returns the known option within two edits of key, if any
+func closestOption(key string, known map[string]bool) string {
+	best, bestDistance := "", 3
+	for option := range known {
+		if d := editDistance(strings.ToLower(key), strings.ToLower(option)); d < bestDistance || d == bestDistance && option < best {
+			best, bestDistance = option, d
+		}
+	}
+	if bestDistance > 2 {
+		return ""
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
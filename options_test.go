@@ -0,0 +1,52 @@
+package js
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPayloadWithOptionNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload map[string]interface{}
+	}{
+		{name: "debug", payload: map[string]interface{}{"user": "alice", "debug": true}},
+		{name: "format", payload: map[string]interface{}{"format": "csv"}},
+		{name: "args", payload: map[string]interface{}{"args": []interface{}{1, 2}}},
+		{name: "select", payload: map[string]interface{}{"select": []interface{}{1.0}}},
+		{name: "retries", payload: map[string]interface{}{"retries": 3.0}},
+		{name: "network", payload: map[string]interface{}{"network": false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := parseRunOptionsFromArgs("flow.js", tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(opts.Payload, tt.payload) {
+				t.Errorf("payload is %v, want %v", opts.Payload, tt.payload)
+			}
+			if opts.Debug != "" || opts.Format != "" || opts.Args != nil || opts.Select != nil || opts.Retries != 0 || opts.DenyNetwork {
+				t.Errorf("options were read from the payload: %+v", opts)
+			}
+		})
+	}
+}
+
+func TestOptionsWithMarkerKey(t *testing.T) {
+	opts, err := parseRunOptionsFromArgs("flow.js", map[string]interface{}{
+		"payload": map[string]interface{}{"user": "alice"},
+		"debug":   true,
+		"retries": 3.0,
+		"network": false,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]interface{}{"user": "alice"}; !reflect.DeepEqual(opts.Payload, want) {
+		t.Errorf("payload is %v, want %v", opts.Payload, want)
+	}
+	if opts.Debug != defaultDebugAddress || opts.Retries != 3 || !opts.DenyNetwork {
+		t.Errorf("options weren't read: debug %q, retries %d, deny network %v", opts.Debug, opts.Retries, opts.DenyNetwork)
+	}
+}
@@ -0,0 +1,119 @@
+package js
+
+import (
+	"context"
+	"os/exec"
+	"sync"
+	"time"
+
+	"go.k6.io/k6/lib"
+	"go.k6.io/k6/metrics"
+)
+
+// k6TestEndEvent is k6's event.TestEnd type, see k6ExitEvent
+const k6TestEndEvent = 3
+
+// orphanPushTimeout bounds how long reporting orphans may wait on the samples
+// channel at test end
+const orphanPushTimeout = time.Second
+
+// processTracker tracks the one-shot runtime processes of every VU, so the
+// ones still running when the test ends can be found and killed. Those are
+// usually flows that kept running after ctx.ack() or calls made without a VU
+// context, which nothing else would stop. Workers are tracked separately by
+// workerRegistry.
+type processTracker struct {
+	mu        sync.Mutex
+	processes map[*exec.Cmd]string
+
+	// samples is the channel of the last VU that started a process, since
+	// there's no VU to push with when the test ends
+	samples chan<- metrics.SampleContainer
+	metric  *metrics.Metric
+	tags    *metrics.TagSet
+}
+
+// register creates the external_js_orphaned metric in registry
+func (t *processTracker) register(registry *metrics.Registry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.metric == nil {
+		t.metric = registry.MustNewMetric("external_js_orphaned", metrics.Counter)
+		t.tags = registry.RootTagSet()
+	}
+}
+
+// run starts cmd, tracks it until it exits and returns its exit error, like
+// cmd.Run
+func (t *processTracker) run(cmd *exec.Cmd, entry string, state *lib.State) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	if t.processes == nil {
+		t.processes = make(map[*exec.Cmd]string)
+	}
+	t.processes[cmd] = entry
+	if state != nil {
+		t.samples = state.Samples
+	}
+	t.mu.Unlock()
+
+	err := cmd.Wait()
+
+	t.mu.Lock()
+	delete(t.processes, cmd)
+	t.mu.Unlock()
+	return err
+}
+
+// sweep kills every process still running and returns the entries they ran,
+// with one item per process
+func (t *processTracker) sweep() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entries := make([]string, 0, len(t.processes))
+	for cmd, entry := range t.processes {
+		if cmd.Process != nil {
+			_ = cmd.Process.Kill()
+		}
+		entries = append(entries, entry)
+	}
+	// The goroutines in run remove them once Wait returns
+	return entries
+}
+
+// report pushes an external_js_orphaned sample per entry with the number of
+// its processes that were killed. Samples are dropped if k6 has stopped
+// reading them.
+func (t *processTracker) report(entries []string) {
+	t.mu.Lock()
+	samples, tags, metric := t.samples, t.tags, t.metric
+	t.mu.Unlock()
+
+	if samples == nil || tags == nil || metric == nil || len(entries) == 0 {
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, entry := range entries {
+		counts[entry]++
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), orphanPushTimeout)
+	defer cancel()
+	now := time.Now()
+	for entry, count := range counts {
+		metrics.PushIfNotDone(ctx, samples, metrics.Sample{
+			TimeSeries: metrics.TimeSeries{
+				Metric: metric,
+				Tags:   tags.With("flow", entry),
+			},
+			Time:  now,
+			Value: float64(count),
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package js
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpMetricsEnvVar is where OpenTelemetry SDKs look for the endpoint of
+// their OTLP/HTTP metrics exporter
+const otlpMetricsEnvVar = "OTEL_EXPORTER_OTLP_METRICS_ENDPOINT"
+
+// metricNameRegex is the rule k6 applies to metric names, which OTel names
+// like "1xx" can still break after sanitizeMetricName
+var metricNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]{1,128}$`)
+
+// otlpReceiver is an OTLP/HTTP metrics endpoint on localhost for a single
+// call. It accepts protobuf and JSON exports and keeps the latest value of
+// each series, which become __k6_metrics__ entries once the flow is done:
+//   - monotonic sums → counters
+//   - non-monotonic sums and gauges → gauges
+//   - histograms → _count and _sum counters, and _bucket gauges tagged with
+//     le, like Prometheus histograms
+//
+// Exponential histograms and summaries are skipped.
+type otlpReceiver struct {
+	listener net.Listener
+	server   *http.Server
+	// maxBytes limits the size of an export, after decompression
+	maxBytes int64
+
+	mu     sync.Mutex
+	series map[string]*otlpSeries
+	errs   []error
+}
+
+// otlpSeries is the value of one metric and set of attributes
+type otlpSeries struct {
+	kind  string
+	name  string
+	tags  map[string]interface{}
+	value float64
+	// timeUnixNano is when the value was last recorded by the flow
+	timeUnixNano uint64
+}
+
+// startOTLPReceiver starts a receiver on a random localhost port
+func startOTLPReceiver(maxBytes int64) (*otlpReceiver, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start the OTLP receiver: %w", err)
+	}
+
+	r := &otlpReceiver{
+		listener: listener,
+		maxBytes: maxBytes,
+		series:   make(map[string]*otlpSeries),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/metrics", r.handleMetrics)
+	r.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go r.server.Serve(listener)
+
+	return r, nil
+}
+
+// endpoint is the URL flows export metrics to
+func (r *otlpReceiver) endpoint() string {
+	return "http://" + r.listener.Addr().String() + "/v1/metrics"
+}
+
+// close stops the receiver. It's safe to call more than once.
+func (r *otlpReceiver) close() {
+	r.server.Close()
+}
+
+func (r *otlpReceiver) handleMetrics(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body io.Reader = http.MaxBytesReader(w, req.Body, r.maxBytes)
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = io.LimitReader(gz, r.maxBytes+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if int64(len(data)) > r.maxBytes {
+		http.Error(w, fmt.Sprintf("export exceeds %d bytes", r.maxBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	isJSON := mediaType == "application/json"
+
+	var request colmetricspb.ExportMetricsServiceRequest
+	if isJSON {
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, &request)
+	} else {
+		err = proto.Unmarshal(data, &request)
+	}
+	if err != nil {
+		http.Error(w, "invalid OTLP metrics export: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.record(&request)
+
+	response := &colmetricspb.ExportMetricsServiceResponse{}
+	var out []byte
+	if isJSON {
+		w.Header().Set("Content-Type", "application/json")
+		out, _ = protojson.Marshal(response)
+	} else {
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		out, _ = proto.Marshal(response)
+	}
+	w.Write(out)
+}
+
+// record merges an export into the series
+func (r *otlpReceiver) record(request *colmetricspb.ExportMetricsServiceRequest) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, resourceMetrics := range request.GetResourceMetrics() {
+		for _, scopeMetrics := range resourceMetrics.GetScopeMetrics() {
+			for _, metric := range scopeMetrics.GetMetrics() {
+				r.recordMetric(metric)
+			}
+		}
+	}
+}
+
+func (r *otlpReceiver) recordMetric(metric *metricspb.Metric) {
+	name := metric.GetName()
+	if name == "" {
+		r.errs = append(r.errs, fmt.Errorf("metric without a name"))
+		return
+	}
+
+	switch data := metric.GetData().(type) {
+	case *metricspb.Metric_Sum:
+		kind := "gauge"
+		if data.Sum.GetIsMonotonic() {
+			kind = "counter"
+		}
+		delta := isDelta(data.Sum.GetAggregationTemporality())
+		for _, point := range data.Sum.GetDataPoints() {
+			r.add(kind, name, otlpTags(point.GetAttributes()), numberValue(point), point.GetTimeUnixNano(), delta)
+		}
+	case *metricspb.Metric_Gauge:
+		for _, point := range data.Gauge.GetDataPoints() {
+			r.add("gauge", name, otlpTags(point.GetAttributes()), numberValue(point), point.GetTimeUnixNano(), false)
+		}
+	case *metricspb.Metric_Histogram:
+		delta := isDelta(data.Histogram.GetAggregationTemporality())
+		for _, point := range data.Histogram.GetDataPoints() {
+			tags := otlpTags(point.GetAttributes())
+			timeUnixNano := point.GetTimeUnixNano()
+			r.add("counter", name+"_count", tags, float64(point.GetCount()), timeUnixNano, delta)
+			r.add("counter", name+"_sum", tags, point.GetSum(), timeUnixNano, delta)
+
+			bounds := point.GetExplicitBounds()
+			var cumulative uint64
+			for i, count := range point.GetBucketCounts() {
+				cumulative += count
+				le := "+Inf"
+				if i < len(bounds) {
+					le = strconv.FormatFloat(bounds[i], 'g', -1, 64)
+				}
+				bucketTags := make(map[string]interface{}, len(tags)+1)
+				for k, v := range tags {
+					bucketTags[k] = v
+				}
+				bucketTags["le"] = le
+				r.add("gauge", name+"_bucket", bucketTags, float64(cumulative), timeUnixNano, delta)
+			}
+		}
+	default:
+		r.errs = append(r.errs, fmt.Errorf("metric %s: %T is not supported", name, data))
+	}
+}
+
+// add sets the series of name and tags to value, or adds value to it for
+// delta temporality, where each export only has what changed since the last
+func (r *otlpReceiver) add(kind, name string, tags map[string]interface{}, value float64, timeUnixNano uint64, delta bool) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return
+	}
+	name = sanitizeMetricName(name)
+
+	key := seriesKey(name, tags)
+	series, ok := r.series[key]
+	if !ok {
+		r.series[key] = &otlpSeries{kind: kind, name: name, tags: tags, value: value, timeUnixNano: timeUnixNano}
+		return
+	}
+	if delta {
+		series.value += value
+	} else {
+		series.value = value
+	}
+	series.timeUnixNano = max(series.timeUnixNano, timeUnixNano)
+}
+
+// entries returns the series as __k6_metrics__ entries, along with the
+// metrics that were skipped
+func (r *otlpReceiver) entries() ([]interface{}, []error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := make([]string, 0, len(r.series))
+	for key := range r.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	entries := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		series := r.series[key]
+		if !metricNameRegex.MatchString(series.name) {
+			r.errs = append(r.errs, fmt.Errorf("%q is not a valid k6 metric name", series.name))
+			continue
+		}
+		entry := map[string]interface{}{
+			"type":  series.kind,
+			"name":  series.name,
+			"value": series.value,
+			"tags":  series.tags,
+		}
+		if series.timeUnixNano > 0 {
+			entry["time"] = float64(series.timeUnixNano / uint64(time.Millisecond))
+		}
+		entries = append(entries, entry)
+	}
+	return entries, r.errs
+}
+
+// pushOTLPMetrics stops the receiver and pushes what the flow exported
+func (j *ExternalJS) pushOTLPMetrics(opts *RunOptions, receiver *otlpReceiver) {
+	receiver.close()
+
+	state := j.metricsState()
+	if state == nil {
+		return
+	}
+	entries, errs := receiver.entries()
+	for _, err := range errs {
+		state.Logger.Warnf("skipping OTLP metric from %s: %v", opts.Entry, err)
+	}
+	j.pushCustomMetrics(state, entries, callTags(opts, nil), j.metricsSink(opts, state))
+}
+
+func isDelta(temporality metricspb.AggregationTemporality) bool {
+	return temporality == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+}
+
+func numberValue(point *metricspb.NumberDataPoint) float64 {
+	if v, ok := point.GetValue().(*metricspb.NumberDataPoint_AsInt); ok {
+		return float64(v.AsInt)
+	}
+	return point.GetAsDouble()
+}
+
+// otlpTags converts data point attributes to tags. Arrays and maps are
+// written as JSON-like text.
+func otlpTags(attributes []*commonpb.KeyValue) map[string]interface{} {
+	tags := make(map[string]interface{}, len(attributes))
+	for _, attribute := range attributes {
+		value := attribute.GetValue()
+		switch v := value.GetValue().(type) {
+		case *commonpb.AnyValue_StringValue:
+			tags[attribute.GetKey()] = v.StringValue
+		case *commonpb.AnyValue_BoolValue:
+			tags[attribute.GetKey()] = strconv.FormatBool(v.BoolValue)
+		case *commonpb.AnyValue_IntValue:
+			tags[attribute.GetKey()] = strconv.FormatInt(v.IntValue, 10)
+		case *commonpb.AnyValue_DoubleValue:
+			tags[attribute.GetKey()] = strconv.FormatFloat(v.DoubleValue, 'g', -1, 64)
+		case nil:
+		default:
+			tags[attribute.GetKey()] = protojson.Format(value)
+		}
+	}
+	return tags
+}
+
+// seriesKey identifies a series by its name and tags
+func seriesKey(name string, tags map[string]interface{}) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", k, v))
+	}
+	sort.Strings(pairs)
+	return name + "\x00" + strings.Join(pairs, "\x00")
+}
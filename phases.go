@@ -0,0 +1,72 @@
+package js
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// readyPrefix starts the line the runner prints once the runtime is up,
+// right before it loads the flow
+const readyPrefix = "__READY__ "
+
+// phaseTimeout is the cause of a call cancelled by startupTimeout or
+// flowTimeout
+type phaseTimeout struct {
+	option string
+	limit  time.Duration
+}
+
+func (e *phaseTimeout) Error() string {
+	return fmt.Sprintf("%s of %s exceeded", e.option, e.limit)
+}
+
+// phaseTimer enforces startupTimeout until the runner's ready line, then
+// flowTimeout, by cancelling the call's context with a *phaseTimeout
+type phaseTimer struct {
+	mu            sync.Mutex
+	cancel        context.CancelCauseFunc
+	startup, flow time.Duration
+	timer         *time.Timer
+	ready         bool
+}
+
+// start starts the startup clock, when the process is started
+func (t *phaseTimer) start() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.startup > 0 && !t.ready {
+		t.timer = time.AfterFunc(t.startup, func() {
+			t.cancel(&phaseTimeout{option: "startupTimeout", limit: t.startup})
+		})
+	}
+}
+
+// markReady stops the startup clock and starts the flow clock. It's the
+// handler of ready lines, only the first one counts.
+func (t *phaseTimer) markReady(_ []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ready {
+		return
+	}
+	t.ready = true
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	if t.flow > 0 {
+		t.timer = time.AfterFunc(t.flow, func() {
+			t.cancel(&phaseTimeout{option: "flowTimeout", limit: t.flow})
+		})
+	}
+}
+
+// stop stops whichever clock is running
+func (t *phaseTimer) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+}
@@ -0,0 +1,100 @@
+package js
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.k6.io/k6/metrics"
+)
+
+// probeEntry makes the runner exit right after it has loaded
+const probeEntry = "__probe__"
+
+// startupProbeRuns is how many times each runtime is started by the probe.
+// The median is reported, so one slow start doesn't skew the result.
+const startupProbeRuns = 3
+
+// probedRuntimes are the runtimes the startup probe measures. workerd needs a
+// flow to build its config from, so it isn't probed.
+var probedRuntimes = []string{"node", "deno", "bun"}
+
+// startupProbe measures runtime startup once per test
+type startupProbe struct {
+	once  sync.Once
+	times map[string]float64
+	err   error
+}
+
+// RuntimeStartupTimes starts every installed runtime with a no-op runner a
+// few times and returns the median startup time in milliseconds, keyed by
+// runtime:
+//
+//	const times = ext.runtimeStartupTimes(); // { node: 41.2, deno: 28.7 }
+//
+// The probe runs once per test, on the first call, and later calls return the
+// same numbers. The times are also recorded in the external_js_runtime_startup
+// metric, tagged with runtime. Runtimes that aren't installed are left out.
+func (j *ExternalJS) RuntimeStartupTimes() (map[string]interface{}, error) {
+	probe := &j.module.probe
+	first := false
+	probe.once.Do(func() {
+		first = true
+		probe.times, probe.err = j.probeStartup()
+	})
+	if probe.err != nil {
+		return nil, probe.err
+	}
+
+	times := make(map[string]interface{}, len(probe.times))
+	for runtime, ms := range probe.times {
+		times[runtime] = ms
+	}
+
+	if state := j.metricsState(); first && state != nil {
+		for runtime, ms := range probe.times {
+			j.pushSample(state, metrics.Sample{
+				TimeSeries: metrics.TimeSeries{
+					Metric: j.runtimeStartup,
+					Tags:   state.Tags.GetCurrentValues().Tags.With("runtime", runtime),
+				},
+				Time:  time.Now(),
+				Value: ms,
+			})
+		}
+	}
+
+	return times, nil
+}
+
+// probeStartup times startupProbeRuns starts of every installed runtime
+func (j *ExternalJS) probeStartup() (map[string]float64, error) {
+	times := make(map[string]float64)
+	for _, runtime := range probedRuntimes {
+		if !j.module.versions.available(runtime) {
+			continue
+		}
+
+		runs := make([]float64, 0, startupProbeRuns)
+		for i := 0; i < startupProbeRuns; i++ {
+			cmd, cleanup, err := buildCommand(context.Background(), &RunOptions{Runtime: runtime, Entry: probeEntry, entryPath: probeEntry}, nil, nil)
+			if err != nil {
+				return nil, err
+			}
+			start := time.Now()
+			out, err := cmd.CombinedOutput()
+			elapsed := time.Since(start)
+			cleanup()
+			if err != nil {
+				return nil, fmt.Errorf("startup probe of %s failed: %w\nOutput: %s", runtime, err, decodeOutput(out, nil))
+			}
+			runs = append(runs, float64(elapsed.Microseconds())/1000)
+		}
+
+		sort.Float64s(runs)
+		times[runtime] = runs[len(runs)/2]
+	}
+	return times, nil
+}
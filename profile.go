@@ -0,0 +1,47 @@
+package js
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// profileKey holds the path of the call's profile in the result
+const profileKey = "__profile__"
+
+// defaultProfileDir is where profiles are saved without artifactsDir,
+// relative to the working directory
+const defaultProfileDir = "profiles"
+
+// profileArgs returns the node flags that write a CPU profile of the flow to
+// opts.profileDir when the process exits
+func profileArgs(opts *RunOptions) []string {
+	return []string{"--cpu-prof", "--cpu-prof-dir=" + opts.profileDir}
+}
+
+// saveProfile moves the CPU profile node wrote to opts.profileDir into the
+// artifacts dir as <flow>-<vu>-<iteration>.cpuprofile and returns its
+// absolute path
+func (j *ExternalJS) saveProfile(opts *RunOptions) (string, error) {
+	profiles, err := filepath.Glob(filepath.Join(opts.profileDir, "*.cpuprofile"))
+	if err != nil || len(profiles) == 0 {
+		return "", fmt.Errorf("node didn't write a CPU profile of %s", opts.Entry)
+	}
+	// Flows that start worker threads get a profile per thread, the main
+	// thread's sorts first
+	sort.Strings(profiles)
+
+	dir := opts.ArtifactsDir
+	if dir == "" {
+		dir = defaultProfileDir
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create profile directory %q: %w", dir, err)
+	}
+	path, err := moveAttachment(profiles[0], dir, j.artifactPrefix(opts), ".cpuprofile")
+	if err != nil {
+		return "", fmt.Errorf("failed to save the profile of %s: %w", opts.Entry, err)
+	}
+	return filepath.Abs(path)
+}
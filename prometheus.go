@@ -0,0 +1,184 @@
+package js
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// parsePrometheusText converts a Prometheus text exposition block into
+// entries in the __k6_metrics__ format. Metric kinds come from # TYPE lines:
+//   - counter → counter
+//   - gauge and untyped → gauge
+//   - histogram and summary → their _sum and _count series become counters,
+//     buckets and quantiles become gauges tagged with le or quantile
+//
+// Malformed lines are skipped and reported in the returned errors. Samples
+// with NaN or infinite values are dropped, since k6 can't aggregate them.
+func parsePrometheusText(text string) ([]interface{}, []error) {
+	var (
+		entries []interface{}
+		errs    []error
+	)
+	types := make(map[string]string)
+
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			fields := strings.Fields(line)
+			if len(fields) >= 4 && fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			}
+			continue
+		}
+
+		name, labels, value, timestamp, err := parsePrometheusSample(line)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", i+1, err))
+			continue
+		}
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			continue
+		}
+
+		entry := map[string]interface{}{
+			"type":  prometheusSampleKind(name, types),
+			"name":  strings.ReplaceAll(name, ":", "_"),
+			"value": value,
+			"tags":  labels,
+		}
+		if timestamp != 0 {
+			entry["time"] = timestamp
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, errs
+}
+
+// prometheusSampleKind maps a sample to a k6 metric type based on the
+// declared type of its family
+func prometheusSampleKind(name string, types map[string]string) string {
+	if kind, ok := types[name]; ok {
+		if kind == "counter" {
+			return "counter"
+		}
+		// gauge, untyped, and summary quantiles
+		return "gauge"
+	}
+
+	for _, suffix := range []string{"_sum", "_count", "_bucket"} {
+		family, found := strings.CutSuffix(name, suffix)
+		if !found {
+			continue
+		}
+		if kind := types[family]; kind == "histogram" || kind == "summary" {
+			if suffix == "_bucket" {
+				return "gauge"
+			}
+			return "counter"
+		}
+	}
+
+	return "gauge"
+}
+
+// parsePrometheusSample parses `name{label="value",...} value [timestamp]`
+func parsePrometheusSample(line string) (string, map[string]interface{}, float64, float64, error) {
+	labels := make(map[string]interface{})
+
+	nameEnd := strings.IndexAny(line, "{ \t")
+	if nameEnd <= 0 {
+		return "", nil, 0, 0, fmt.Errorf("expected a metric name and value in %q", line)
+	}
+	name := line[:nameEnd]
+	rest := line[nameEnd:]
+
+	if strings.HasPrefix(rest, "{") {
+		end, err := parsePrometheusLabels(rest, labels)
+		if err != nil {
+			return "", nil, 0, 0, err
+		}
+		rest = rest[end:]
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return "", nil, 0, 0, fmt.Errorf("expected a value and optional timestamp in %q", line)
+	}
+
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return "", nil, 0, 0, fmt.Errorf("invalid value %q for %s", fields[0], name)
+	}
+
+	var timestamp float64
+	if len(fields) == 2 {
+		ts, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return "", nil, 0, 0, fmt.Errorf("invalid timestamp %q for %s", fields[1], name)
+		}
+		timestamp = float64(ts)
+	}
+
+	return name, labels, value, timestamp, nil
+}
+
+// parsePrometheusLabels parses a {label="value",...} block at the start of s
+// into labels, returning the index just past the closing brace
+func parsePrometheusLabels(s string, labels map[string]interface{}) (int, error) {
+	i := 1
+	for {
+		for i < len(s) && (s[i] == ' ' || s[i] == ',') {
+			i++
+		}
+		if i >= len(s) {
+			return 0, fmt.Errorf("unterminated label set in %q", s)
+		}
+		if s[i] == '}' {
+			return i + 1, nil
+		}
+
+		eq := strings.IndexByte(s[i:], '=')
+		if eq <= 0 {
+			return 0, fmt.Errorf("expected label=\"value\" in %q", s)
+		}
+		key := strings.TrimSpace(s[i : i+eq])
+		i += eq + 1
+		if i >= len(s) || s[i] != '"' {
+			return 0, fmt.Errorf("label %s must have a quoted value", key)
+		}
+		i++
+
+		var value strings.Builder
+		for {
+			if i >= len(s) {
+				return 0, fmt.Errorf("unterminated value for label %s", key)
+			}
+			c := s[i]
+			if c == '"' {
+				i++
+				break
+			}
+			if c == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(s[i])
+				}
+				i++
+				continue
+			}
+			value.WriteByte(c)
+			i++
+		}
+		labels[key] = value.String()
+	}
+}
@@ -0,0 +1,106 @@
+package js
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePrometheusText(t *testing.T) {
+	tests := []struct {
+		name        string
+		text        string
+		want        []interface{}
+		wantErrLine []string
+	}{
+		{
+			name: "counter and gauge",
+			text: `# HELP jobs_total Jobs processed.
+# TYPE jobs_total counter
+jobs_total{queue="fast"} 3
+# TYPE temperature gauge
+temperature -4.5 1700000000000
+job:latency:seconds 0.2`,
+			want: []interface{}{
+				map[string]interface{}{"type": "counter", "name": "jobs_total", "value": 3.0, "tags": map[string]interface{}{"queue": "fast"}},
+				map[string]interface{}{"type": "gauge", "name": "temperature", "value": -4.5, "tags": map[string]interface{}{}, "time": 1700000000000.0},
+				map[string]interface{}{"type": "gauge", "name": "job_latency_seconds", "value": 0.2, "tags": map[string]interface{}{}},
+			},
+		},
+		{
+			name: "histogram",
+			text: `# TYPE req_seconds histogram
+req_seconds_bucket{le="0.1"} 2
+req_seconds_bucket{le="+Inf"} 5
+req_seconds_sum 1.5
+req_seconds_count 5`,
+			want: []interface{}{
+				map[string]interface{}{"type": "gauge", "name": "req_seconds_bucket", "value": 2.0, "tags": map[string]interface{}{"le": "0.1"}},
+				map[string]interface{}{"type": "gauge", "name": "req_seconds_bucket", "value": 5.0, "tags": map[string]interface{}{"le": "+Inf"}},
+				map[string]interface{}{"type": "counter", "name": "req_seconds_sum", "value": 1.5, "tags": map[string]interface{}{}},
+				map[string]interface{}{"type": "counter", "name": "req_seconds_count", "value": 5.0, "tags": map[string]interface{}{}},
+			},
+		},
+		{
+			name: "summary",
+			text: `# TYPE rpc_seconds summary
+rpc_seconds{quantile="0.99"} 0.3
+rpc_seconds_sum 12
+rpc_seconds_count 40
+other_count 7`,
+			want: []interface{}{
+				map[string]interface{}{"type": "gauge", "name": "rpc_seconds", "value": 0.3, "tags": map[string]interface{}{"quantile": "0.99"}},
+				map[string]interface{}{"type": "counter", "name": "rpc_seconds_sum", "value": 12.0, "tags": map[string]interface{}{}},
+				map[string]interface{}{"type": "counter", "name": "rpc_seconds_count", "value": 40.0, "tags": map[string]interface{}{}},
+				map[string]interface{}{"type": "gauge", "name": "other_count", "value": 7.0, "tags": map[string]interface{}{}},
+			},
+		},
+		{
+			name: "escaped labels",
+			text: `requests{path="/a\"b\\c\nd",method="GET" , empty=""} 1`,
+			want: []interface{}{
+				map[string]interface{}{"type": "gauge", "name": "requests", "value": 1.0, "tags": map[string]interface{}{
+					"path": "/a\"b\\c\nd", "method": "GET", "empty": "",
+				}},
+			},
+		},
+		{
+			name: "NaN and Inf dropped",
+			text: "a NaN\nb +Inf\nc -Inf\nd 1",
+			want: []interface{}{
+				map[string]interface{}{"type": "gauge", "name": "d", "value": 1.0, "tags": map[string]interface{}{}},
+			},
+		},
+		{
+			name: "malformed lines",
+			text: `{a="b"} 1
+no_value
+too_many 1 2 3
+unterminated{a="b" 1
+unquoted{a=b} 1
+not_a_number abc
+bad_timestamp 1 soon
+ok 2`,
+			want: []interface{}{
+				map[string]interface{}{"type": "gauge", "name": "ok", "value": 2.0, "tags": map[string]interface{}{}},
+			},
+			wantErrLine: []string{"line 1:", "line 2:", "line 3:", "line 4:", "line 5:", "line 6:", "line 7:"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, errs := parsePrometheusText(tt.text)
+			if !reflect.DeepEqual(entries, tt.want) {
+				t.Errorf("entries are\n%v\nwant\n%v", entries, tt.want)
+			}
+			if len(errs) != len(tt.wantErrLine) {
+				t.Fatalf("got errors %v, want %d", errs, len(tt.wantErrLine))
+			}
+			for i, err := range errs {
+				if !strings.HasPrefix(err.Error(), tt.wantErrLine[i]) {
+					t.Errorf("error %d is %q, want it on %s", i, err, tt.wantErrLine[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package js
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimitOptions caps how often a flow can be invoked across all VUs
+type RateLimitOptions struct {
+	// RPS is the maximum number of calls per second
+	RPS float64 `json:"rps"`
+	// Burst is how many calls may start at once, 1 by default
+	Burst int `json:"burst"`
+	// Mode is what happens over the limit: "wait" (default) or "error"
+	Mode string `json:"mode"`
+}
+
+// rateLimiters holds one token bucket per entry, shared by all VUs
+type rateLimiters struct {
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// get returns the limiter for entry, creating it or updating its limits
+func (r *rateLimiters) get(entry string, opts *RateLimitOptions) *rate.Limiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.limiters == nil {
+		r.limiters = make(map[string]*rate.Limiter)
+	}
+
+	limit := rate.Limit(opts.RPS)
+	limiter, exists := r.limiters[entry]
+	if !exists {
+		limiter = rate.NewLimiter(limit, opts.Burst)
+		r.limiters[entry] = limiter
+		return limiter
+	}
+
+	if limiter.Limit() != limit {
+		limiter.SetLimit(limit)
+	}
+	if limiter.Burst() != opts.Burst {
+		limiter.SetBurst(opts.Burst)
+	}
+	return limiter
+}
+
+// throttle blocks until entry may run again under opts, or fails right away
+// in "error" mode. It returns how long the call was held back.
+func (r *rateLimiters) throttle(ctx context.Context, entry string, opts *RateLimitOptions) (time.Duration, error) {
+	limiter := r.get(entry, opts)
+
+	if opts.Mode == "error" {
+		if !limiter.Allow() {
+			return 0, fmt.Errorf("rate limit of %g calls/s for %s exceeded", opts.RPS, entry)
+		}
+		return 0, nil
+	}
+
+	start := time.Now()
+	if err := limiter.Wait(ctx); err != nil {
+		return time.Since(start), fmt.Errorf("waiting for the rate limit of %s: %w", entry, err)
+	}
+	return time.Since(start), nil
+}
+
+// parseRateLimitOptions interprets the rateLimit option
+func parseRateLimitOptions(raw map[string]interface{}) (*RateLimitOptions, error) {
+	opts := &RateLimitOptions{Burst: 1, Mode: "wait"}
+
+	switch v := raw["rps"].(type) {
+	case int64:
+		opts.RPS = float64(v)
+	case float64:
+		opts.RPS = v
+	}
+	if opts.RPS <= 0 {
+		return nil, fmt.Errorf("rateLimit.rps must be a positive number")
+	}
+
+	switch v := raw["burst"].(type) {
+	case int64:
+		opts.Burst = int(v)
+	case float64:
+		opts.Burst = int(v)
+	}
+	if opts.Burst < 1 {
+		return nil, fmt.Errorf("rateLimit.burst must be at least 1, got %d", opts.Burst)
+	}
+
+	if v, ok := raw["mode"].(string); ok {
+		if v != "wait" && v != "error" {
+			return nil, fmt.Errorf("unsupported rateLimit.mode %q (supported: wait, error)", v)
+		}
+		opts.Mode = v
+	}
+
+	return opts, nil
+}
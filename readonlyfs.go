@@ -0,0 +1,53 @@
+package js
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// parseReadOnlyFS reads the readOnlyFS option, an array of the files and
+// directories the flow may read, which are made absolute
+func parseReadOnlyFS(value []interface{}) ([]string, error) {
+	paths := make([]string, 0, len(value))
+	for _, item := range value {
+		path, ok := item.(string)
+		if !ok || path == "" {
+			return nil, fmt.Errorf("readOnlyFS must be an array of non-empty paths, got %v", item)
+		}
+		// Deno takes the paths as a comma-separated list
+		if strings.Contains(path, ",") {
+			return nil, fmt.Errorf("readOnlyFS paths must not contain commas, got %q", path)
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid readOnlyFS path %q: %w", path, err)
+		}
+		paths = append(paths, abs)
+	}
+	return paths, nil
+}
+
+// readOnlyFSArgs returns the runtime flags that limit the flow to reading
+// opts.ReadOnlyFS and the paths the runner itself needs, and deny writes:
+//   - node: the permission model, which also denies child processes and
+//     worker threads
+//   - deno: --allow-read for the paths, instead of --allow-all
+//
+// Other runtimes are rejected before getting here. The flags go before the
+// script.
+func readOnlyFSArgs(opts *RunOptions) []string {
+	switch opts.Runtime {
+	case "node":
+		args := []string{"--experimental-permission", "--disable-warning=ExperimentalWarning"}
+		for _, path := range opts.readPaths {
+			args = append(args, "--allow-fs-read="+path)
+		}
+		return args
+	case "deno":
+		// network: false still adds --deny-net, which wins over --allow-net
+		return []string{"--allow-env", "--allow-sys", "--allow-net", "--allow-read=" + strings.Join(opts.readPaths, ",")}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,241 @@
+package js
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// allowedHostsEnvVar lists the hosts remote flows may be fetched from
+const allowedHostsEnvVar = "XK6_EXTERNAL_JS_ALLOWED_HOSTS"
+
+const (
+	// remoteFetchTimeout bounds how long fetching a remote flow may take
+	remoteFetchTimeout = 30 * time.Second
+	// maxRemoteFlowBytes caps the size of a remote flow
+	maxRemoteFlowBytes = 10 << 20
+)
+
+// integrityHashes are the algorithms accepted in integrity strings
+var integrityHashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// remoteClient fetches remote flows. It only follows redirects to allowed
+// hosts, so an allowed host can't hand out code from any other.
+var remoteClient = &http.Client{CheckRedirect: checkRemoteRedirect}
+
+// remoteFlows caches flows whose entry is an http(s) URL. Each URL is fetched
+// once per test and written to a temp directory shared by all VUs.
+type remoteFlows struct {
+	mu    sync.Mutex
+	dir   string
+	flows map[string]*remoteFlow
+}
+
+type remoteFlow struct {
+	mu      sync.Mutex
+	path    string
+	content []byte
+}
+
+// isRemoteEntry reports whether entry is a URL to fetch the flow from
+func isRemoteEntry(entry string) bool {
+	return strings.HasPrefix(entry, "https://") || strings.HasPrefix(entry, "http://")
+}
+
+// get returns the local path of the flow at rawURL, fetching it on first use.
+// Calls for the same URL wait for the one fetching it, and failed fetches
+// aren't cached. The content is checked against integrity on every call,
+// since calls may pass different values.
+func (r *remoteFlows) get(ctx context.Context, rawURL, integrity string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid flow URL %q: %w", rawURL, err)
+	}
+	if !hostAllowed(u.Hostname(), os.Getenv(allowedHostsEnvVar)) {
+		return "", fmt.Errorf("fetching flows from %s is not allowed (add it to %s)", u.Hostname(), allowedHostsEnvVar)
+	}
+
+	r.mu.Lock()
+	if r.flows == nil {
+		r.flows = make(map[string]*remoteFlow)
+	}
+	if r.dir == "" {
+		dir, err := os.MkdirTemp("", "xk6-external-js-remote-*")
+		if err != nil {
+			r.mu.Unlock()
+			return "", fmt.Errorf("failed to create remote flow directory: %w", err)
+		}
+		r.dir = dir
+	}
+	flow, ok := r.flows[rawURL]
+	if !ok {
+		flow = &remoteFlow{}
+		r.flows[rawURL] = flow
+	}
+	dir := r.dir
+	r.mu.Unlock()
+
+	flow.mu.Lock()
+	defer flow.mu.Unlock()
+
+	if flow.path == "" {
+		content, err := fetchFlow(ctx, rawURL)
+		if err != nil {
+			return "", err
+		}
+		// Each flow gets its own directory so it keeps its file name, which
+		// selects the runtime and module type
+		flowDir, err := os.MkdirTemp(dir, "flow-*")
+		if err != nil {
+			return "", fmt.Errorf("failed to create directory for %s: %w", rawURL, err)
+		}
+		localPath := filepath.Join(flowDir, remoteFlowName(u))
+		if err := os.WriteFile(localPath, content, 0o600); err != nil {
+			return "", fmt.Errorf("failed to write %s: %w", rawURL, err)
+		}
+		flow.path, flow.content = localPath, content
+	}
+
+	if integrity != "" {
+		if err := verifyIntegrity(flow.content, integrity); err != nil {
+			return "", fmt.Errorf("%s failed integrity verification: %w", rawURL, err)
+		}
+	}
+	return flow.path, nil
+}
+
+// cleanup removes the fetched flows
+func (r *remoteFlows) cleanup() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.dir != "" {
+		_ = os.RemoveAll(r.dir)
+		r.dir = ""
+		r.flows = nil
+	}
+}
+
+// fetchFlow downloads the flow at rawURL
+func fetchFlow(ctx context.Context, rawURL string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, remoteFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid flow URL %q: %w", rawURL, err)
+	}
+	resp, err := remoteClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: %s", rawURL, resp.Status)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteFlowBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", rawURL, err)
+	}
+	if len(content) > maxRemoteFlowBytes {
+		return nil, fmt.Errorf("%s is larger than %d bytes", rawURL, maxRemoteFlowBytes)
+	}
+	return content, nil
+}
+
+// checkRemoteRedirect rejects redirects to hosts that aren't allowed, and
+// otherwise stops after 10 redirects like the default client
+func checkRemoteRedirect(req *http.Request, via []*http.Request) error {
+	if !hostAllowed(req.URL.Hostname(), os.Getenv(allowedHostsEnvVar)) {
+		return fmt.Errorf("redirect to %s is not allowed (add it to %s)", req.URL.Hostname(), allowedHostsEnvVar)
+	}
+	if len(via) >= 10 {
+		return fmt.Errorf("stopped after 10 redirects")
+	}
+	return nil
+}
+
+// hostAllowed reports whether host is in the comma-separated allowlist.
+// An entry like *.example.com matches any subdomain of example.com.
+func hostAllowed(host, allowlist string) bool {
+	host = strings.ToLower(host)
+	for _, allowed := range strings.Split(allowlist, ",") {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed == "" {
+			continue
+		}
+		if suffix, ok := strings.CutPrefix(allowed, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteFlowName is the file name a fetched flow is written to: the last
+// segment of the URL path, or flow.js if there's none
+func remoteFlowName(u *url.URL) string {
+	name := logFileNameRegex.ReplaceAllString(path.Base(u.Path), "_")
+	if name == "" || name == "." || name == "_" {
+		return "flow.js"
+	}
+	return name
+}
+
+// parseIntegrity checks that integrity is a space-separated list of
+// subresource integrity hashes like "sha256-<base64 digest>"
+func parseIntegrity(integrity string) error {
+	hashes := strings.Fields(integrity)
+	if len(hashes) == 0 {
+		return fmt.Errorf("integrity must not be empty")
+	}
+	for _, h := range hashes {
+		algorithm, digest, _ := strings.Cut(h, "-")
+		if _, ok := integrityHashes[algorithm]; !ok {
+			return fmt.Errorf("unsupported integrity hash %q (supported: sha256, sha384, sha512)", h)
+		}
+		if _, err := base64.StdEncoding.DecodeString(digest); err != nil {
+			return fmt.Errorf("integrity hash %q is not valid base64: %w", h, err)
+		}
+	}
+	return nil
+}
+
+// verifyIntegrity checks content against integrity, which passes if any of
+// its hashes matches
+func verifyIntegrity(content []byte, integrity string) error {
+	for _, h := range strings.Fields(integrity) {
+		algorithm, digest, _ := strings.Cut(h, "-")
+		expected, _ := base64.StdEncoding.DecodeString(digest)
+		hasher := integrityHashes[algorithm]()
+		hasher.Write(content)
+		if subtle.ConstantTimeCompare(hasher.Sum(nil), expected) == 1 {
+			return nil
+		}
+	}
+	sum := sha256.Sum256(content)
+	return fmt.Errorf("no hash in %q matches, its content has sha256-%s", integrity, base64.StdEncoding.EncodeToString(sum[:]))
+}
@@ -0,0 +1,63 @@
+package js
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRemoteFlowRedirects(t *testing.T) {
+	flow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("module.exports = async () => ({});"))
+	}))
+	defer flow.Close()
+	// The same server under a host name that isn't allowed
+	unlisted := strings.Replace(flow.URL, "127.0.0.1", "localhost", 1)
+
+	redirects := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := flow.URL
+		if r.URL.Path == "/unlisted.js" {
+			target = unlisted
+		}
+		http.Redirect(w, r, target+"/flow.js", http.StatusFound)
+	}))
+	defer redirects.Close()
+
+	t.Setenv(allowedHostsEnvVar, "127.0.0.1")
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr string
+	}{
+		{name: "allowed host", path: "/allowed.js"},
+		{name: "unlisted host", path: "/unlisted.js", wantErr: "redirect to localhost is not allowed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remote := &remoteFlows{}
+			defer remote.cleanup()
+
+			path, err := remote.get(context.Background(), redirects.URL+tt.path, "")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(content), "module.exports") {
+				t.Errorf("fetched %q", content)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package js
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Environment variables that turn on recording or replaying for a test run
+const (
+	recordEnvVar = "XK6_EXTERNAL_JS_RECORD"
+	replayEnvVar = "XK6_EXTERNAL_JS_REPLAY"
+)
+
+// recordedRun is one line of a recording: an invocation and what it returned.
+// Result is the raw result as the flow sent it, before metrics, checks and
+// the other __k6_*__ fields are processed, so replaying it has the same
+// effects as the original run.
+type recordedRun struct {
+	Key        string            `json:"key"`
+	Entry      string            `json:"entry"`
+	Fn         string            `json:"fn,omitempty"`
+	Runtime    string            `json:"runtime"`
+	RuntimeTag string            `json:"runtimeTag"`
+	Payload    interface{}       `json:"payload"`
+	Env        map[string]string `json:"env,omitempty"`
+	Meta       interface{}       `json:"meta,omitempty"`
+	Context    interface{}       `json:"context,omitempty"`
+	Result     json.RawMessage   `json:"result,omitempty"`
+	Stderr     string            `json:"stderr,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	Duration   float64           `json:"duration"`
+
+	start time.Time
+}
+
+// recorder writes invocations to the file named by XK6_EXTERNAL_JS_RECORD,
+// or serves the ones read from XK6_EXTERNAL_JS_REPLAY instead of running the
+// flow. It's shared by all VUs.
+type recorder struct {
+	once    sync.Once
+	initErr error
+
+	mu     sync.Mutex
+	file   *os.File
+	runs   map[string][]*recordedRun
+	served map[string]int
+}
+
+// init reads the environment and opens or loads the recording
+func (r *recorder) init() error {
+	r.once.Do(func() {
+		recordPath, replayPath := os.Getenv(recordEnvVar), os.Getenv(replayEnvVar)
+		switch {
+		case recordPath != "" && replayPath != "":
+			r.initErr = fmt.Errorf("%s and %s can't be used together", recordEnvVar, replayEnvVar)
+		case recordPath != "":
+			r.file, r.initErr = os.Create(recordPath)
+			if r.initErr != nil {
+				r.initErr = fmt.Errorf("failed to create recording: %w", r.initErr)
+			}
+		case replayPath != "":
+			r.runs, r.initErr = loadRecording(replayPath)
+			r.served = make(map[string]int)
+		}
+	})
+	return r.initErr
+}
+
+// loadRecording reads a recording, grouping its runs by invocation key in
+// the order they were recorded
+func loadRecording(path string) (map[string][]*recordedRun, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording: %w", err)
+	}
+	defer f.Close()
+
+	runs := make(map[string][]*recordedRun)
+	decoder := json.NewDecoder(f)
+	for line := 1; ; line++ {
+		run := &recordedRun{}
+		if err := decoder.Decode(run); err != nil {
+			if errors.Is(err, io.EOF) {
+				return runs, nil
+			}
+			return nil, fmt.Errorf("invalid recording %s at entry %d: %w", path, line, err)
+		}
+		runs[run.Key] = append(runs[run.Key], run)
+	}
+}
+
+// invocationKey identifies invocations that should get the same result: the
+// same entry and export called with the same payload, env and meta. The
+// execution context isn't part of it, since the VU and iteration differ
+// between test runs.
+func invocationKey(entry string, opts *RunOptions) (string, error) {
+	signature, err := json.Marshal([]interface{}{entry, opts.Fn, opts.Payload, opts.Env, opts.Meta})
+	if err != nil {
+		return "", fmt.Errorf("failed to compute the invocation key of %s: %w", entry, err)
+	}
+	sum := sha256.Sum256(signature)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// begin prepares a call for recording or replaying. When recording, it
+// returns the run to fill in and pass to save. When replaying, it returns
+// the recorded run to serve instead, or nil if there's none for this
+// invocation and the flow should run live. Calls with the same key are
+// served in the order they were recorded, repeating the last one once
+// they're used up.
+func (r *recorder) begin(entry string, opts *RunOptions) (record, replay *recordedRun, err error) {
+	if err := r.init(); err != nil {
+		return nil, nil, err
+	}
+	if r.file == nil && r.runs == nil {
+		return nil, nil, nil
+	}
+
+	key, err := invocationKey(entry, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if r.file != nil {
+		return &recordedRun{
+			Key:     key,
+			Entry:   entry,
+			Fn:      opts.Fn,
+			Payload: opts.Payload,
+			Env:     opts.Env,
+			Meta:    opts.Meta,
+			start:   time.Now(),
+		}, nil, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	runs := r.runs[key]
+	if len(runs) == 0 {
+		return nil, nil, nil
+	}
+	i := min(r.served[key], len(runs)-1)
+	r.served[key]++
+	return nil, runs[i], nil
+}
+
+// save appends a finished run to the recording. Calls that failed before
+// the flow sent a result are recorded with their error.
+func (r *recorder) save(run *recordedRun, opts *RunOptions, err error) error {
+	run.Runtime = opts.Runtime
+	run.RuntimeTag = opts.runtimeTag
+	run.Duration = float64(time.Since(run.start).Milliseconds())
+	if run.Result == nil {
+		if err == nil {
+			return nil
+		}
+		run.Error = err.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := json.NewEncoder(r.file).Encode(run); err != nil {
+		return fmt.Errorf("failed to record %s: %w", run.Entry, err)
+	}
+	return nil
+}
+
+// close closes the recording, if one is being written
+func (r *recorder) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// result decodes the recorded result, or returns the recorded error
+func (run *recordedRun) result() (map[string]interface{}, error) {
+	if run.Result == nil {
+		return nil, fmt.Errorf("replayed from recording: %s", run.Error)
+	}
+	result, err := unmarshalResult(run.Result)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recorded result of %s: %w", run.Entry, err)
+	}
+	return result, nil
+}
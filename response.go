@@ -0,0 +1,114 @@
+package js
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// normalizeResponse turns a flow's __k6_response__ object into fields shaped
+// like k6's http.Response, so checks written against http.get() results work
+// on flow results unchanged:
+//
+//	return { __k6_response__: { status: res.status, body: await res.text(), headers: Object.fromEntries(res.headers) } };
+//
+// Header names are canonicalized the way k6 does (content-type becomes
+// Content-Type) and non-string bodies are JSON-encoded.
+func normalizeResponse(raw map[string]interface{}) (map[string]interface{}, error) {
+	status := 0
+	if value, ok := raw["status"]; ok {
+		number, ok := value.(float64)
+		if !ok {
+			return nil, fmt.Errorf("status must be a number, got %T", value)
+		}
+		status = int(number)
+	}
+
+	statusText, _ := raw["status_text"].(string)
+	if statusText == "" && status != 0 {
+		statusText = strings.TrimSpace(fmt.Sprintf("%d %s", status, http.StatusText(status)))
+	}
+
+	body := ""
+	switch value := raw["body"].(type) {
+	case nil:
+	case string:
+		body = value
+	default:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode body: %w", err)
+		}
+		body = string(encoded)
+	}
+
+	headers := make(map[string]interface{})
+	if rawHeaders, ok := raw["headers"].(map[string]interface{}); ok {
+		// Sorted so repeated names merge in a stable order
+		names := make([]string, 0, len(rawHeaders))
+		for name := range rawHeaders {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			value := headerValue(rawHeaders[name])
+			key := http.CanonicalHeaderKey(name)
+			if existing, ok := headers[key].(string); ok {
+				value = existing + ", " + value
+			}
+			headers[key] = value
+		}
+	}
+
+	timings := map[string]interface{}{"duration": float64(0)}
+	if rawTimings, ok := raw["timings"].(map[string]interface{}); ok {
+		for name, value := range rawTimings {
+			timings[name] = value
+		}
+	}
+
+	url, _ := raw["url"].(string)
+	proto, _ := raw["proto"].(string)
+	errorMessage, _ := raw["error"].(string)
+	errorCode, _ := raw["error_code"].(float64)
+
+	return map[string]interface{}{
+		"status":      status,
+		"status_text": statusText,
+		"url":         url,
+		"proto":       proto,
+		"headers":     headers,
+		"body":        body,
+		"timings":     timings,
+		"error":       errorMessage,
+		"error_code":  int(errorCode),
+		"json": func() (interface{}, error) {
+			var parsed interface{}
+			if err := json.Unmarshal([]byte(body), &parsed); err != nil {
+				return nil, fmt.Errorf("cannot parse response body as JSON: %w", err)
+			}
+			return parsed, nil
+		},
+	}, nil
+}
+
+// headerValue renders a header value as a string, joining arrays with ", "
+func headerValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, part := range v {
+			parts = append(parts, headerValue(part))
+		}
+		return strings.Join(parts, ", ")
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
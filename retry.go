@@ -0,0 +1,161 @@
+package js
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"slices"
+	"time"
+)
+
+// defaultRetryBackoff is the wait before the first retry. It doubles for
+// every retry after that.
+const defaultRetryBackoff = 100 * time.Millisecond
+
+// errTotalTimeout marks failures caused by the totalTimeout budget running out
+var errTotalTimeout = errors.New("totalTimeout exceeded")
+
+// RetryOnOptions limits retries to failures that look transient, so retries
+// aren't spent on deterministic ones. A failed attempt is retried if it
+// matches any of the conditions that are set.
+type RetryOnOptions struct {
+	// ExitCodes are the exit codes of the runtime worth retrying, e.g. 75
+	// (EX_TEMPFAIL)
+	ExitCodes []int `json:"exitCodes"`
+	// StderrPattern matches the stderr of attempts worth retrying
+	StderrPattern *regexp.Regexp `json:"-"`
+}
+
+// parseRetryOn reads the retryOn option, an object with exitCodes and
+// stderrPattern
+func parseRetryOn(raw map[string]interface{}) (*RetryOnOptions, error) {
+	retryOn := &RetryOnOptions{}
+	if rawCodes, ok := raw["exitCodes"]; ok && rawCodes != nil {
+		codes, ok := rawCodes.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("retryOn.exitCodes must be an array of integers, got %T", rawCodes)
+		}
+		for _, rawCode := range codes {
+			var code int
+			switch c := rawCode.(type) {
+			case int64:
+				code = int(c)
+			case float64:
+				if c != float64(int(c)) {
+					return nil, fmt.Errorf("retryOn.exitCodes must be integers, got %v", c)
+				}
+				code = int(c)
+			default:
+				return nil, fmt.Errorf("retryOn.exitCodes must be an array of integers, got %T element", rawCode)
+			}
+			if code < 1 || code > 255 {
+				return nil, fmt.Errorf("retryOn.exitCodes must be between 1 and 255, got %d", code)
+			}
+			retryOn.ExitCodes = append(retryOn.ExitCodes, code)
+		}
+	}
+	if pattern, ok := raw["stderrPattern"].(string); ok && pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retryOn.stderrPattern: %w", err)
+		}
+		retryOn.StderrPattern = re
+	}
+	if len(retryOn.ExitCodes) == 0 && retryOn.StderrPattern == nil {
+		return nil, fmt.Errorf("retryOn needs exitCodes or a stderrPattern")
+	}
+	return retryOn, nil
+}
+
+// matches reports whether the failed attempt err is worth retrying
+func (r *RetryOnOptions) matches(err error) bool {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && slices.Contains(r.ExitCodes, exitErr.ExitCode()) {
+		return true
+	}
+	var failed *attemptError
+	return r.StderrPattern != nil && errors.As(err, &failed) && r.StderrPattern.MatchString(failed.stderr)
+}
+
+// attemptError is an attempt that failed before it had a result, along with
+// what it wrote to stderr, which retryOn may match
+type attemptError struct {
+	err    error
+	stderr string
+}
+
+func (e *attemptError) Error() string { return e.err.Error() }
+
+func (e *attemptError) Unwrap() error { return e.err }
+
+// runWithRetries runs a flow up to opts.Retries more times while it fails,
+// waiting between attempts. With opts.TotalTimeout, the whole call including
+// the waits gets that budget: each attempt's deadline is the earlier of its
+// own timeout and the end of the budget, and no attempt is started that
+// couldn't begin before the budget runs out.
+func (j *ExternalJS) runWithRetries(flowPath string, opts *RunOptions) (map[string]interface{}, error) {
+	ctx := j.vu.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if opts.TotalTimeout > 0 {
+		opts.deadline = time.Now().Add(opts.TotalTimeout)
+	}
+	backoff := opts.RetryBackoff
+	if backoff == 0 {
+		backoff = defaultRetryBackoff
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		// run fills in its options, so every attempt starts from a copy
+		attemptOpts := *opts
+		var result map[string]interface{}
+		result, err = j.run(flowPath, &attemptOpts)
+		if err == nil {
+			return result, nil
+		}
+
+		var (
+			aborted *abortError
+			soft    *softError
+		)
+		switch {
+		case errors.As(err, &aborted):
+			return nil, err
+		case errors.As(err, &soft):
+			// The flow finished and chose to fail, running it again won't help
+			return nil, err
+		case errors.Is(err, errMaxRuns):
+			return nil, err
+		case errors.Is(err, errTotalTimeout):
+			return nil, fmt.Errorf("%s failed on attempt %d of %d: %w", opts.Entry, attempt, opts.Retries+1, err)
+		case opts.RetryOn != nil && attempt <= opts.Retries && !opts.RetryOn.matches(err):
+			return nil, fmt.Errorf("%s failed on attempt %d of %d, not retrying an error retryOn doesn't match: %w",
+				opts.Entry, attempt, opts.Retries+1, err)
+		case attempt > opts.Retries:
+			if opts.Retries == 0 {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%s failed after %d attempts: %w", opts.Entry, attempt, err)
+		}
+
+		if !opts.deadline.IsZero() && time.Until(opts.deadline) <= backoff {
+			return nil, fmt.Errorf("%s failed on attempt %d of %d, no time left in the totalTimeout of %s to retry: %w",
+				opts.Entry, attempt, opts.Retries+1, opts.TotalTimeout, err)
+		}
+
+		j.logger().Debugf("retrying %s in %s after attempt %d failed: %v", opts.Entry, backoff, attempt, err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,54 @@
+package js
+
+import (
+	"sync/atomic"
+	"time"
+
+	"go.k6.io/k6/lib"
+	"go.k6.io/k6/metrics"
+)
+
+// droppedSamples counts samples that couldn't be delivered to k6. Pushes
+// already block while the samples channel is full, so a sample is only lost
+// when the VU's context is done before it could be sent, e.g. for an
+// iteration interrupted by gracefulStop.
+type droppedSamples struct {
+	// total is reported in the warning at the end of the test
+	total atomic.Int64
+	// pending is what external_js_dropped_samples hasn't reported yet
+	pending atomic.Int64
+}
+
+// pushSample sends sample to k6, waiting while the samples channel is full.
+// Drops are counted and reported through external_js_dropped_samples with
+// the next sample that does get through.
+func (j *ExternalJS) pushSample(state *lib.State, sample metrics.Sample) {
+	dropped := &j.module.dropped
+	if !metrics.PushIfNotDone(j.vu.Context(), state.Samples, sample) {
+		dropped.total.Add(1)
+		dropped.pending.Add(1)
+		return
+	}
+
+	pending := dropped.pending.Swap(0)
+	if pending == 0 {
+		return
+	}
+	report := metrics.Sample{
+		TimeSeries: metrics.TimeSeries{
+			Metric: j.droppedSamples,
+			Tags:   state.Tags.GetCurrentValues().Tags,
+		},
+		Time:  time.Now(),
+		Value: float64(pending),
+	}
+	if !metrics.PushIfNotDone(j.vu.Context(), state.Samples, report) {
+		dropped.pending.Add(pending)
+	}
+}
+
+// DroppedSamples returns how many samples from flows couldn't be delivered
+// to k6 so far, across all VUs
+func (j *ExternalJS) DroppedSamples() int64 {
+	return j.module.dropped.total.Load()
+}
@@ -0,0 +1,64 @@
+package js
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseSelect reads the select option, an array of top-level keys or dotted
+// paths to nested ones
+func parseSelect(raw []interface{}) ([]string, error) {
+	paths := make([]string, 0, len(raw))
+	for _, item := range raw {
+		path, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("select must be an array of strings, got %T element", item)
+		}
+		for _, segment := range strings.Split(path, ".") {
+			if segment == "" {
+				return nil, fmt.Errorf("invalid select path %q", path)
+			}
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// selectFields returns a result with only the values at paths. A path like
+// "user.id" keeps result.user.id nested under user. Paths that don't exist
+// in result are left out.
+func selectFields(result map[string]interface{}, paths []string) map[string]interface{} {
+	selected := make(map[string]interface{}, len(paths))
+	for _, path := range paths {
+		segments := strings.Split(path, ".")
+
+		value, found := interface{}(result), true
+		for _, segment := range segments {
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				found = false
+				break
+			}
+			if value, ok = object[segment]; !ok {
+				found = false
+				break
+			}
+		}
+		if !found {
+			continue
+		}
+
+		// Rebuild the path down to the value, merging with other selected paths
+		target := selected
+		for _, segment := range segments[:len(segments)-1] {
+			next, ok := target[segment].(map[string]interface{})
+			if !ok {
+				next = make(map[string]interface{})
+				target[segment] = next
+			}
+			target = next
+		}
+		target[segments[len(segments)-1]] = value
+	}
+	return selected
+}
@@ -0,0 +1,155 @@
+package js
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// serverTimingKey holds Server-Timing data returned by a flow
+const serverTimingKey = "__k6_server_timing__"
+
+// parseServerTiming converts __k6_server_timing__ into entries in the
+// __k6_metrics__ format, one time trend per timing named server_timing_<name>
+// and tagged with timing: <name>. raw is either a Server-Timing header value:
+//
+//	db;dur=53, cache;desc="Cache Read";dur=5
+//
+// or an array of { name, duration } objects, like the serverTiming entries of
+// PerformanceResourceTiming (dur is accepted for duration). Header strings
+// may also appear in the array, for flows that collect several responses.
+// Timings without a duration are skipped, invalid ones are reported in the
+// returned errors.
+func parseServerTiming(raw interface{}) ([]interface{}, []error) {
+	var (
+		entries []interface{}
+		errs    []error
+	)
+	add := func(name string, duration float64) {
+		entries = append(entries, map[string]interface{}{
+			"type":     "trend",
+			"contains": "time",
+			"name":     "server_timing_" + sanitizeMetricName(name),
+			"value":    duration,
+			"tags":     map[string]interface{}{"timing": name},
+		})
+	}
+
+	var items []interface{}
+	switch v := raw.(type) {
+	case string:
+		items = []interface{}{v}
+	case []interface{}:
+		items = v
+	default:
+		return nil, []error{fmt.Errorf("must be a header string or an array, got %T", raw)}
+	}
+
+	for i, item := range items {
+		switch v := item.(type) {
+		case string:
+			timings, headerErrs := parseServerTimingHeader(v)
+			errs = append(errs, headerErrs...)
+			for _, t := range timings {
+				add(t.name, t.duration)
+			}
+		case map[string]interface{}:
+			name, _ := v["name"].(string)
+			if name == "" {
+				errs = append(errs, fmt.Errorf("entry %d has no name", i))
+				continue
+			}
+			duration, ok := v["duration"]
+			if !ok {
+				duration, ok = v["dur"]
+			}
+			if !ok || duration == nil {
+				continue
+			}
+			d, ok := duration.(float64)
+			if !ok || d < 0 {
+				errs = append(errs, fmt.Errorf("duration of %s must be a non-negative number, got %v", name, duration))
+				continue
+			}
+			add(name, d)
+		default:
+			errs = append(errs, fmt.Errorf("entry %d must be a header string or an object, got %T", i, item))
+		}
+	}
+
+	return entries, errs
+}
+
+// serverTiming is a timing of a Server-Timing header with a duration
+type serverTiming struct {
+	name     string
+	duration float64
+}
+
+// parseServerTimingHeader parses the timings of a Server-Timing header value
+func parseServerTimingHeader(header string) ([]serverTiming, []error) {
+	var (
+		timings []serverTiming
+		errs    []error
+	)
+	for _, metric := range splitServerTiming(header, ',') {
+		params := splitServerTiming(metric, ';')
+		name := strings.TrimSpace(params[0])
+		if name == "" {
+			continue
+		}
+
+		for _, param := range params[1:] {
+			key, value, _ := strings.Cut(param, "=")
+			if !strings.EqualFold(strings.TrimSpace(key), "dur") {
+				continue
+			}
+			value = strings.Trim(strings.TrimSpace(value), `"`)
+			d, err := strconv.ParseFloat(value, 64)
+			if err != nil || d < 0 {
+				errs = append(errs, fmt.Errorf("invalid duration %q for %s", value, name))
+				break
+			}
+			timings = append(timings, serverTiming{name: name, duration: d})
+			break
+		}
+	}
+	return timings, errs
+}
+
+// splitServerTiming splits s on sep, ignoring separators in quoted strings
+func splitServerTiming(s string, sep rune) []string {
+	var (
+		parts  []string
+		start  int
+		quoted bool
+	)
+	for i, r := range s {
+		switch {
+		case r == '"' && (i == 0 || s[i-1] != '\\'):
+			quoted = !quoted
+		case r == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// sanitizeMetricName replaces the characters of a name that k6
+// doesn't allow in metric names (anything but ASCII letters, digits and
+// underscores) with underscores, and keeps the name within k6's length limit
+func sanitizeMetricName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < utf8.RuneSelf && (r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return r
+		}
+		return '_'
+	}, name)
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	return name
+}
@@ -0,0 +1,92 @@
+package js
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseServerTimingHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    []serverTiming
+		wantErr string
+	}{
+		{
+			name:   "durations",
+			header: `db;dur=53, cache;desc="Cache Read";dur=5.5`,
+			want:   []serverTiming{{name: "db", duration: 53}, {name: "cache", duration: 5.5}},
+		},
+		{
+			name:   "quoted separators in desc",
+			header: `cache;desc="hit, miss; stale";dur=5, db;dur=1`,
+			want:   []serverTiming{{name: "cache", duration: 5}, {name: "db", duration: 1}},
+		},
+		{
+			name:   "escaped quote in desc",
+			header: `app;desc="say \"hi\", then; go";dur=2`,
+			want:   []serverTiming{{name: "app", duration: 2}},
+		},
+		{
+			name:   "quoted and uppercase dur",
+			header: `db;dur="7.5", cache;DUR=3`,
+			want:   []serverTiming{{name: "db", duration: 7.5}, {name: "cache", duration: 3}},
+		},
+		{
+			name:   "missing dur",
+			header: `miss;desc="no time", hit;dur=1, , ;dur=4`,
+			want:   []serverTiming{{name: "hit", duration: 1}},
+		},
+		{
+			name:    "negative dur",
+			header:  `db;dur=-1, cache;dur=2`,
+			want:    []serverTiming{{name: "cache", duration: 2}},
+			wantErr: `invalid duration "-1" for db`,
+		},
+		{
+			name:    "invalid dur",
+			header:  `db;dur=fast`,
+			wantErr: `invalid duration "fast" for db`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timings, errs := parseServerTimingHeader(tt.header)
+			if !reflect.DeepEqual(timings, tt.want) {
+				t.Errorf("timings are %v, want %v", timings, tt.want)
+			}
+			if tt.wantErr == "" {
+				if len(errs) != 0 {
+					t.Errorf("unexpected errors %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), tt.wantErr) {
+				t.Errorf("errors are %v, want %q", errs, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseServerTimingEntries(t *testing.T) {
+	entries, errs := parseServerTiming([]interface{}{
+		map[string]interface{}{"name": "db", "duration": 12.0},
+		map[string]interface{}{"name": "cache", "dur": 3.0},
+		map[string]interface{}{"name": "no-duration"},
+		map[string]interface{}{"name": "negative", "duration": -1.0},
+		map[string]interface{}{"duration": 1.0},
+		"edge;dur=4",
+	})
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.(map[string]interface{})["name"].(string))
+	}
+	if want := []string{"server_timing_db", "server_timing_cache", "server_timing_edge"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("entries are %v, want %v", names, want)
+	}
+	if len(errs) != 2 {
+		t.Errorf("errors are %v, want 2", errs)
+	}
+}
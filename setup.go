@@ -0,0 +1,58 @@
+package js
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+
+	"go.k6.io/k6/lib"
+)
+
+// setupGroup is the group tag k6 gives samples emitted from setup()
+const setupGroup = "::setup"
+
+// setupStore holds the result of the last flow run in setup(), which later
+// flows receive as ctx.setupData
+type setupStore struct {
+	mu   sync.RWMutex
+	data json.RawMessage
+}
+
+// set stores result as the setup data. Values that can't be serialized, like
+// the json() helper of __k6_response__ results, are left out.
+func (s *setupStore) set(result map[string]interface{}) error {
+	data := make(map[string]interface{}, len(result))
+	for key, value := range result {
+		if value != nil && reflect.TypeOf(value).Kind() == reflect.Func {
+			continue
+		}
+		data[key] = value
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = encoded
+	return nil
+}
+
+// get returns the stored setup data, or nil if no flow ran in setup()
+func (s *setupStore) get() json.RawMessage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data
+}
+
+// inSetup reports whether a call is made from k6's setup(), which runs in a
+// VU with ID 0 whose samples are tagged with the ::setup group
+func inSetup(state *lib.State) bool {
+	if state == nil || state.VUID != 0 {
+		return false
+	}
+	group, _ := state.Tags.GetCurrentValues().Tags.Get("group")
+	return group == setupGroup
+}
@@ -0,0 +1,88 @@
+package js
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// sharedRegistry holds datasets registered with ext.shared(). Each dataset is
+// serialized once to a temp file that every flow invocation can read.
+type sharedRegistry struct {
+	mu    sync.RWMutex
+	files map[string]string
+}
+
+// Shared registers an immutable dataset under name so flows can read it
+// without it being embedded in every payload.
+//
+//	const users = ext.shared("users", JSON.parse(open("./users.json")));
+//	ext.run("lib.js", { payload: {}, shared: [users] });
+//
+// Like k6's SharedArray, only the first registration of a name serializes the
+// data; later calls (e.g. from other VUs' init code) reuse it. The returned
+// handle is the name to pass in the shared option.
+func (j *ExternalJS) Shared(name string, data interface{}) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("shared dataset name must not be empty")
+	}
+	if err := j.module.shared.register(name, data); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// register serializes data to a temp file unless name is already registered
+func (r *sharedRegistry) register(name string, data interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.files[name]; exists {
+		return nil
+	}
+
+	f, err := os.CreateTemp("", "xk6-external-js-shared-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create file for shared dataset %q: %w", name, err)
+	}
+	defer f.Close()
+
+	if err := json.NewEncoder(f).Encode(data); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to serialize shared dataset %q: %w", name, err)
+	}
+
+	if r.files == nil {
+		r.files = make(map[string]string)
+	}
+	r.files[name] = f.Name()
+	return nil
+}
+
+// paths returns the file path of each requested dataset, keyed by name
+func (r *sharedRegistry) paths(names []string) (map[string]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	paths := make(map[string]string, len(names))
+	for _, name := range names {
+		path, ok := r.files[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown shared dataset %q (register it with ext.shared())", name)
+		}
+		paths[name] = path
+	}
+	return paths, nil
+}
+
+// cleanup removes the datasets' files
+func (r *sharedRegistry) cleanup() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, path := range r.files {
+		_ = os.Remove(path)
+	}
+	r.files = nil
+}
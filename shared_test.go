@@ -0,0 +1,29 @@
+package js
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSharedCleanup(t *testing.T) {
+	var r sharedRegistry
+	if err := r.register("users", []interface{}{"alice", "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	paths, err := r.paths([]string{"users"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(paths["users"]); err != nil {
+		t.Fatalf("dataset file missing: %v", err)
+	}
+
+	r.cleanup()
+
+	if _, err := os.Stat(paths["users"]); !os.IsNotExist(err) {
+		t.Errorf("dataset file still exists after cleanup: %v", err)
+	}
+	if _, err := r.paths([]string{"users"}); err == nil {
+		t.Error("dataset still registered after cleanup")
+	}
+}
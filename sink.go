@@ -0,0 +1,200 @@
+package js
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.k6.io/k6/metrics"
+)
+
+// statsdQueueSize is how many lines a sink buffers before dropping new ones
+const statsdQueueSize = 4096
+
+// statsdMaxPacket keeps packets under the usual 1500 byte MTU
+const statsdMaxPacket = 1432
+
+// statsdSinks holds one sink per metricsSink address, shared by all VUs
+type statsdSinks struct {
+	mu    sync.Mutex
+	sinks map[string]*statsdSink
+}
+
+// get returns the sink for rawURL, connecting on first use
+func (s *statsdSinks) get(rawURL string) (*statsdSink, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sink, ok := s.sinks[rawURL]; ok {
+		return sink, nil
+	}
+
+	u, err := parseMetricsSink(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial("udp", u.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to metricsSink %s: %w", rawURL, err)
+	}
+
+	sink := &statsdSink{
+		conn:  conn,
+		tags:  u.Scheme == "dogstatsd",
+		lines: make(chan string, statsdQueueSize),
+		done:  make(chan struct{}),
+	}
+	go sink.loop()
+
+	if s.sinks == nil {
+		s.sinks = make(map[string]*statsdSink)
+	}
+	s.sinks[rawURL] = sink
+	return sink, nil
+}
+
+// close flushes and closes every sink, returning how many lines were dropped
+// because a sink's queue was full
+func (s *statsdSinks) close() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var dropped int64
+	for rawURL, sink := range s.sinks {
+		dropped += sink.close()
+		delete(s.sinks, rawURL)
+	}
+	return dropped
+}
+
+// parseMetricsSink validates a statsd://host:port or dogstatsd://host:port URL
+func parseMetricsSink(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metricsSink %q: %w", rawURL, err)
+	}
+	if u.Scheme != "statsd" && u.Scheme != "dogstatsd" {
+		return nil, fmt.Errorf("unsupported metricsSink scheme %q (supported: statsd, dogstatsd)", u.Scheme)
+	}
+	if u.Hostname() == "" || u.Port() == "" {
+		return nil, fmt.Errorf("metricsSink %q must have a host and port", rawURL)
+	}
+	return u, nil
+}
+
+// statsdSink sends metric lines over UDP from a background goroutine, so
+// forwarding never blocks the VU. Lines are dropped when the queue is full.
+type statsdSink struct {
+	conn    net.Conn
+	tags    bool
+	lines   chan string
+	done    chan struct{}
+	dropped atomic.Int64
+
+	// mu keeps send from racing with close
+	mu     sync.RWMutex
+	closed bool
+}
+
+// send queues a sample of metric for the sink
+func (s *statsdSink) send(metric *metrics.Metric, value float64, tags map[string]string) {
+	line := s.format(metric, value, tags)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.lines <- line:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// format renders a sample in the StatsD line format. Trends are sent as
+// timers and rates as 0/1 gauges, since StatsD has no rate type. DogStatsD
+// sinks also get the tags.
+func (s *statsdSink) format(metric *metrics.Metric, value float64, tags map[string]string) string {
+	kind := "c"
+	switch metric.Type {
+	case metrics.Gauge, metrics.Rate:
+		kind = "g"
+	case metrics.Trend:
+		kind = "ms"
+	}
+
+	var b strings.Builder
+	b.WriteString(metric.Name)
+	b.WriteByte(':')
+	b.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	b.WriteByte('|')
+	b.WriteString(kind)
+
+	if s.tags && len(tags) > 0 {
+		keys := make([]string, 0, len(tags))
+		for k := range tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		b.WriteString("|#")
+		for i, k := range keys {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(k)
+			b.WriteByte(':')
+			b.WriteString(tags[k])
+		}
+	}
+	return b.String()
+}
+
+// loop batches queued lines into packets until the sink is closed
+func (s *statsdSink) loop() {
+	defer close(s.done)
+
+	var packet []byte
+	for line := range s.lines {
+		packet = append(packet[:0], line...)
+	batch:
+		for len(packet) < statsdMaxPacket {
+			select {
+			case next, ok := <-s.lines:
+				if !ok {
+					break batch
+				}
+				if len(packet)+1+len(next) > statsdMaxPacket {
+					_, _ = s.conn.Write(packet)
+					packet = packet[:0]
+				} else {
+					packet = append(packet, '\n')
+				}
+				packet = append(packet, next...)
+			default:
+				break batch
+			}
+		}
+		_, _ = s.conn.Write(packet)
+	}
+}
+
+// close flushes the queued lines and closes the connection
+func (s *statsdSink) close() int64 {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.lines)
+	}
+	s.mu.Unlock()
+
+	<-s.done
+	_ = s.conn.Close()
+	return s.dropped.Load()
+}
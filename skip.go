@@ -0,0 +1,26 @@
+package js
+
+import "go.k6.io/k6/lib"
+
+// skippedKey marks the result of a call whose onlyVU or everyNIterations
+// predicate didn't match, so the flow wasn't run
+const skippedKey = "__skipped__"
+
+// skipCall reports whether the onlyVU and everyNIterations options rule out
+// running the flow in this iteration. Outside of a VU, e.g. in setup() or
+// the init context, the VU and iteration are both 0.
+func skipCall(state *lib.State, opts *RunOptions) bool {
+	var vuID uint64
+	var iteration int64
+	if state != nil {
+		vuID, iteration = state.VUID, state.Iteration
+	}
+
+	if opts.OnlyVU > 0 && vuID != uint64(opts.OnlyVU) {
+		return true
+	}
+	if opts.EveryNIterations > 0 && iteration%int64(opts.EveryNIterations) != 0 {
+		return true
+	}
+	return false
+}
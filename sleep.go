@@ -0,0 +1,39 @@
+package js
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// sleepKey is the think time in milliseconds a flow can return for the VU to
+// sleep after the call
+const sleepKey = "__k6_sleep__"
+
+// parseSleep checks a __k6_sleep__ value
+func parseSleep(raw interface{}) (time.Duration, error) {
+	ms, ok := raw.(float64)
+	if !ok || ms < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative number of milliseconds, got %v", sleepKey, raw)
+	}
+	return time.Duration(ms * float64(time.Millisecond)), nil
+}
+
+// thinkTime sleeps for d like k6's sleep(), returning early once the VU's
+// context is done, e.g. at the end of the scenario
+func (j *ExternalJS) thinkTime(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	ctx := j.vu.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
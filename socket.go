@@ -0,0 +1,134 @@
+package js
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// socketTransport receives flow results over a unix domain socket instead of
+// parsing stdout markers. The runner connects to path and writes frames made
+// of a 4-byte big-endian length followed by that many bytes of JSON:
+//
+//	{"type": "result", "value": { ... }}
+type socketTransport struct {
+	dir      string
+	path     string
+	listener net.Listener
+	maxFrame int64
+	done     chan struct{}
+	result   map[string]interface{}
+	err      error
+}
+
+// newSocketTransport starts listening on a fresh socket in a temp directory.
+// Frames larger than maxFrame bytes are rejected.
+func newSocketTransport(maxFrame int64) (*socketTransport, error) {
+	// Keep the path short, unix socket paths are limited to ~100 bytes
+	dir, err := os.MkdirTemp("", "xk6js-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+
+	path := filepath.Join(dir, "flow.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+
+	t := &socketTransport{
+		dir:      dir,
+		path:     path,
+		listener: listener,
+		maxFrame: maxFrame,
+		done:     make(chan struct{}),
+	}
+	go t.serve()
+	return t, nil
+}
+
+// serve accepts a single runner connection and reads frames until it closes
+func (t *socketTransport) serve() {
+	defer close(t.done)
+
+	conn, err := t.listener.Accept()
+	if err != nil {
+		t.err = fmt.Errorf("runner never connected to the result socket")
+		return
+	}
+	defer conn.Close()
+
+	var header [4]byte
+	for {
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			if !errors.Is(err, io.EOF) {
+				t.err = fmt.Errorf("failed to read frame header: %w", err)
+			}
+			return
+		}
+
+		size := binary.BigEndian.Uint32(header[:])
+		if int64(size) > t.maxFrame {
+			t.err = fmt.Errorf("frame of %d bytes: %w", size, errOutputLimit)
+			return
+		}
+
+		body := make([]byte, size)
+		if _, err := io.ReadFull(conn, body); err != nil {
+			t.err = fmt.Errorf("failed to read frame body: %w", err)
+			return
+		}
+
+		var frame struct {
+			Type  string                 `json:"type"`
+			Value map[string]interface{} `json:"value"`
+		}
+		if err := unmarshalNumbers(body, &frame); err != nil {
+			t.err = fmt.Errorf("failed to unmarshal frame: %w", err)
+			return
+		}
+
+		if frame.Type == "result" {
+			t.result = frame.Value
+		}
+	}
+}
+
+// send passes payload as the JSON argument and tells the runner where to connect
+func (t *socketTransport) send(payload interface{}, execContext map[string]interface{}) ([]byte, error) {
+	execContext["socket"] = t.path
+	return marshalPayload(payload)
+}
+
+// receive returns the result sent by the runner, stdout isn't used. It must
+// be called after the runner process has exited.
+func (t *socketTransport) receive(string) (map[string]interface{}, error) {
+	// Unblocks Accept if the runner never connected
+	_ = t.listener.Close()
+
+	select {
+	case <-t.done:
+	case <-time.After(5 * time.Second):
+		return nil, fmt.Errorf("timed out reading the result socket")
+	}
+
+	if t.err != nil {
+		return nil, t.err
+	}
+	if t.result == nil {
+		return nil, fmt.Errorf("no result frame received on the result socket")
+	}
+	return t.result, nil
+}
+
+// close stops listening and removes the socket directory
+func (t *socketTransport) close() {
+	_ = t.listener.Close()
+	os.RemoveAll(t.dir)
+}
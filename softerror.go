@@ -0,0 +1,71 @@
+package js
+
+import (
+	"fmt"
+	"time"
+
+	"go.k6.io/k6/metrics"
+)
+
+// softErrorKey marks a result that reports a failure without the flow throwing
+const softErrorKey = "__k6_error__"
+
+// softError is returned by calls with failOnError whose flow returned a
+// __k6_error__. The processed result is kept, so scripts can still read it
+// from the exception as e.value.result.
+type softError struct {
+	entry   string
+	message string
+	Result  map[string]interface{} `js:"result"`
+}
+
+func (e *softError) Error() string {
+	return fmt.Sprintf("%s returned an error: %s", e.entry, e.message)
+}
+
+// softErrorMessage returns the message of a __k6_error__ value, or "" if it
+// doesn't report an error. Strings are used as is, objects by their message
+// field, and true is a failure without a message.
+func softErrorMessage(raw interface{}) string {
+	switch v := raw.(type) {
+	case nil:
+		return ""
+	case bool:
+		if v {
+			return "failed"
+		}
+		return ""
+	case string:
+		return v
+	case map[string]interface{}:
+		if message, ok := v["message"].(string); ok && message != "" {
+			return message
+		}
+		return "failed"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// pushSuccess records whether a call succeeded in external_js_success
+func (j *ExternalJS) pushSuccess(opts *RunOptions, succeeded bool) {
+	state := j.metricsState()
+	if state == nil {
+		return
+	}
+
+	value := 0.0
+	if succeeded {
+		value = 1
+	}
+	j.pushSample(state, metrics.Sample{
+		TimeSeries: metrics.TimeSeries{
+			Metric: j.success,
+			Tags: state.Tags.GetCurrentValues().Tags.WithTagsFromMap(
+				callTags(opts, map[string]string{"flow": opts.Entry, "runtime": opts.runtimeTag}),
+			),
+		},
+		Time:  time.Now(),
+		Value: value,
+	})
+}
@@ -0,0 +1,50 @@
+package js
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"go.k6.io/k6/lib"
+	"go.k6.io/k6/metrics"
+)
+
+// statusKey is the outcome a flow can report with its result, finer than
+// success or failure
+const statusKey = "__k6_status__"
+
+// flowStatuses are the outcomes a flow can report in __k6_status__
+var flowStatuses = []string{"ok", "degraded", "failed", "skipped"}
+
+// parseStatus checks a __k6_status__ value
+func parseStatus(raw interface{}) (string, error) {
+	status, ok := raw.(string)
+	if !ok || !slices.Contains(flowStatuses, status) {
+		return "", fmt.Errorf("%s must be one of ok, degraded, failed or skipped, got %v", statusKey, raw)
+	}
+	return status, nil
+}
+
+// pushStatus records status in external_js_status, a rate with a sample per
+// possible status, tagged with it: 1 for the reported one and 0 for the
+// others. external_js_status{status:degraded} is then the share of calls
+// that were degraded.
+func (j *ExternalJS) pushStatus(state *lib.State, opts *RunOptions, status string) {
+	now := time.Now()
+	for _, s := range flowStatuses {
+		value := 0.0
+		if s == status {
+			value = 1
+		}
+		j.pushSample(state, metrics.Sample{
+			TimeSeries: metrics.TimeSeries{
+				Metric: j.flowStatus,
+				Tags: state.Tags.GetCurrentValues().Tags.WithTagsFromMap(
+					callTags(opts, map[string]string{"flow": opts.Entry, "runtime": opts.runtimeTag, "status": s}),
+				),
+			},
+			Time:  now,
+			Value: value,
+		})
+	}
+}
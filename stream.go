@@ -0,0 +1,108 @@
+package js
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// flowStream is a flow started with RunStreaming. The script writes chunks
+// to the flow's stdin while it runs, and gets its result once the stream is
+// closed.
+type flowStream struct {
+	entry string
+	w     *os.File
+
+	// mu keeps writes and closing apart, since the script can call them from
+	// promises too
+	mu     sync.Mutex
+	closed bool
+
+	done   chan struct{}
+	result map[string]interface{}
+	err    error
+}
+
+// RunStreaming starts a flow that reads its input as a stream, which the
+// script writes to over time, and returns the stream right away:
+//
+//	const stream = ext.runStreaming("./consume.js", { timeout: "30s" });
+//	for (const event of events) {
+//	  stream.write(event);
+//	}
+//	const result = stream.close();
+//
+// The flow reads the chunks from ctx.stream, an async iterable:
+//
+//	export default async function (ctx) {
+//	  let count = 0;
+//	  for await (const event of ctx.stream) count++;
+//	  return { count };
+//	}
+//
+// It takes the same arguments as Run, except stdin and retries, since a
+// stream can't be replayed. Not supported with persistPerVU, threads or the
+// workerd runtime.
+func (j *ExternalJS) RunStreaming(flowPath string, payloadOrOptions interface{}) (*flowStream, error) {
+	opts, err := parseRunOptionsFromArgs(flowPath, payloadOrOptions)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Stdin != nil || opts.Retries > 0 {
+		return nil, fmt.Errorf("runStreaming writes the flow's stdin, it can't be combined with stdin or retries")
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the stream of %s: %w", flowPath, err)
+	}
+	opts.stdinPipe = r
+
+	s := &flowStream{entry: opts.Entry, w: w, done: make(chan struct{})}
+	go func() {
+		s.result, s.err = j.execute(flowPath, opts)
+		// Writes fail from now on instead of blocking on a flow that's gone
+		r.Close()
+		close(s.done)
+	}()
+	return s, nil
+}
+
+// Write sends chunk to the flow as a line of JSON. It blocks while the flow
+// is behind on reading. If the flow is already done, e.g. because it failed
+// or timed out, its error is returned.
+func (s *flowStream) Write(chunk interface{}) error {
+	line, err := json.Marshal(chunk)
+	if err != nil {
+		return fmt.Errorf("stream chunks must be serializable to JSON: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return fmt.Errorf("the stream of %s is closed", s.entry)
+	}
+	if _, err := s.w.Write(append(line, '\n')); err != nil {
+		<-s.done
+		if s.err != nil {
+			return s.err
+		}
+		return fmt.Errorf("%s stopped reading its stream: %w", s.entry, err)
+	}
+	return nil
+}
+
+// Close ends the stream and waits for the flow's result. It can be called
+// more than once, and returns the same result every time.
+func (s *flowStream) Close() (map[string]interface{}, error) {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		s.w.Close()
+	}
+	s.mu.Unlock()
+
+	<-s.done
+	return s.result, s.err
+}
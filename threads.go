@@ -0,0 +1,212 @@
+package js
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.k6.io/k6/metrics"
+)
+
+// threadJobBuffer is how many lines of a job's output may be queued before
+// the host's output is held up
+const threadJobBuffer = 64
+
+// threadHost is a Node.js process running jobs on a pool of worker_threads.
+// Unlike a worker it's shared by all VUs and runs as many jobs at once as it
+// has threads, queueing the rest. Jobs are sent as {"id": ..., "job": ...}
+// lines and their output comes back prefixed with the job ID, so it can be
+// routed to the call that sent it.
+type threadHost struct {
+	w *worker
+
+	mu     sync.Mutex
+	jobs   map[uint64]*threadJob
+	nextID uint64
+	dead   bool
+}
+
+// threadJob receives the output of one job running on a threadHost
+type threadJob struct {
+	lines    chan string
+	errLines chan string
+	// done is closed once the call stops reading the job's output
+	done chan struct{}
+}
+
+// threadHosts holds the thread hosts shared by all VUs, one per pool size
+type threadHosts struct {
+	mu    sync.Mutex
+	hosts map[int]*threadHost
+}
+
+// get returns the host with the given number of threads, starting it on
+// first use or after it died. The host gets the environment and command
+// wrapper of the call that starts it. started reports whether it was started
+// by this call.
+func (t *threadHosts) get(threads int, env, wrapper []string, pool *workerRegistry) (host *threadHost, started bool, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if host := t.hosts[threads]; host != nil {
+		if host.alive() {
+			return host, false, nil
+		}
+		pool.remove(host.w)
+	}
+
+	runner, err := runnerFile()
+	if err != nil {
+		return nil, false, err
+	}
+	cmd := exec.Command("node", "-e", runnerScript, "__thread_host__", strconv.Itoa(threads), runner)
+	w, err := launchWorker("node", cmd, env, wrapper)
+	if err != nil {
+		return nil, false, err
+	}
+	// Shut down with the workers at the end of the test
+	pool.add(w)
+
+	host = &threadHost{w: w, jobs: make(map[uint64]*threadJob)}
+	go host.routeLines()
+	go host.routeErrLines()
+
+	if t.hosts == nil {
+		t.hosts = make(map[int]*threadHost)
+	}
+	t.hosts[threads] = host
+	return host, true, nil
+}
+
+// alive reports whether the host can still accept jobs
+func (h *threadHost) alive() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.dead
+}
+
+// run sends job to the host and copies its output to stdout and stderr. If
+// ctx is done before the job finishes, the host terminates the thread
+// running it and replaces it with a new one.
+func (h *threadHost) run(ctx context.Context, job workerJob, stdout, stderr io.Writer) error {
+	h.mu.Lock()
+	if h.dead {
+		h.mu.Unlock()
+		return fmt.Errorf("thread host is not accepting jobs")
+	}
+	h.nextID++
+	id := h.nextID
+	tj := &threadJob{
+		lines:    make(chan string, threadJobBuffer),
+		errLines: make(chan string, threadJobBuffer),
+		done:     make(chan struct{}),
+	}
+	h.jobs[id] = tj
+	err := h.send(map[string]interface{}{"id": id, "job": job})
+	h.mu.Unlock()
+
+	defer func() {
+		h.mu.Lock()
+		delete(h.jobs, id)
+		h.mu.Unlock()
+		close(tj.done)
+	}()
+	if err != nil {
+		return fmt.Errorf("thread host is not accepting jobs: %w", err)
+	}
+
+	return readJob(ctx, tj.lines, tj.errLines, stdout, stderr, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		_ = h.send(map[string]interface{}{"cancel": id})
+	})
+}
+
+// send writes a message to the host. The caller must hold h.mu.
+func (h *threadHost) send(message map[string]interface{}) error {
+	line, err := marshalJSON(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job: %w", err)
+	}
+	_, err = h.w.stdin.Write(append(line, '\n'))
+	return err
+}
+
+// routeLines sends each stdout line of the host to the job it's prefixed
+// with. Once the host exits, the jobs still running see their output end.
+func (h *threadHost) routeLines() {
+	for line := range h.w.lines {
+		if tj := h.jobFor(&line); tj != nil {
+			select {
+			case tj.lines <- line:
+			case <-tj.done:
+			}
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.dead = true
+	// This goroutine is the only sender on the jobs' lines
+	for _, tj := range h.jobs {
+		close(tj.lines)
+	}
+}
+
+// routeErrLines sends each stderr line of the host to the job it's prefixed
+// with
+func (h *threadHost) routeErrLines() {
+	for line := range h.w.errLines {
+		if tj := h.jobFor(&line); tj != nil {
+			select {
+			case tj.errLines <- line:
+			case <-tj.done:
+			}
+		}
+	}
+}
+
+// jobFor strips the job ID from line and returns the job. Lines of jobs that
+// were cancelled and lines without an ID, like Node.js warnings, are dropped.
+func (h *threadHost) jobFor(line *string) *threadJob {
+	rawID, rest, ok := strings.Cut(*line, " ")
+	if !ok {
+		rawID, rest = *line, ""
+	}
+	id, err := strconv.ParseUint(rawID, 10, 64)
+	if err != nil {
+		return nil
+	}
+	*line = rest
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.jobs[id]
+}
+
+// runInThreads runs job on the shared thread host with opts.Threads threads
+func (j *ExternalJS) runInThreads(ctx context.Context, opts *RunOptions, env []string, job workerJob, stdout, stderr io.Writer) error {
+	start := time.Now()
+	host, started, err := j.module.threads.get(opts.Threads, env, opts.CommandWrapper, &j.module.pool)
+	if err != nil {
+		return err
+	}
+
+	if state := j.metricsState(); started && state != nil {
+		j.pushSample(state, metrics.Sample{
+			TimeSeries: metrics.TimeSeries{
+				Metric: j.workerSpawnDuration,
+				Tags:   state.Tags.GetCurrentValues().Tags.WithTagsFromMap(callTags(opts, map[string]string{"runtime": opts.runtimeTag})),
+			},
+			Time:  time.Now(),
+			Value: float64(time.Since(start).Milliseconds()),
+		})
+	}
+
+	return host.run(ctx, job, stdout, stderr)
+}
@@ -0,0 +1,44 @@
+package js
+
+import "sync"
+
+// ResultTransformer rewrites a flow's result before it's returned to the k6
+// script, e.g. to redact fields or rename keys
+type ResultTransformer func(result map[string]interface{}) map[string]interface{}
+
+// resultTransform holds the registered ResultTransformer
+type resultTransform struct {
+	mu sync.RWMutex
+	fn ResultTransformer
+}
+
+// apply runs the registered transformer on result, if any
+func (t *resultTransform) apply(result map[string]interface{}) map[string]interface{} {
+	t.mu.RLock()
+	fn := t.fn
+	t.mu.RUnlock()
+
+	if fn == nil {
+		return result
+	}
+	if transformed := fn(result); transformed != nil {
+		return transformed
+	}
+	return map[string]interface{}{}
+}
+
+// SetResultTransformer registers fn to process every flow result, across all
+// VUs, before it's returned to the script. It's meant for Go code embedding
+// the extension that needs cross-cutting result handling like PII redaction.
+// fn runs concurrently for different VUs. Passing nil removes it.
+func (m *ExternalJSModule) SetResultTransformer(fn ResultTransformer) {
+	m.transform.mu.Lock()
+	defer m.transform.mu.Unlock()
+	m.transform.fn = fn
+}
+
+// SetResultTransformer registers fn on the module registered as
+// k6/x/external_js. See ExternalJSModule.SetResultTransformer.
+func SetResultTransformer(fn ResultTransformer) {
+	rootModule.SetResultTransformer(fn)
+}
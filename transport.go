@@ -0,0 +1,118 @@
+package js
+
+import (
+	"fmt"
+	"os"
+)
+
+// transport carries the payload of a call to the runner and its result back.
+// The payload is passed as a command line argument (or in the worker job),
+// and the execution context tells the runner how to decode it and where to
+// send the result. New transports, like stdin or an inherited fd, only need
+// to implement this and be picked by newTransport.
+type transport interface {
+	// send encodes payload for the runner and adds what the runner needs to
+	// read it and send the result to execContext
+	send(payload interface{}, execContext map[string]interface{}) ([]byte, error)
+	// receive returns the result sent by the runner, given the flow's stdout.
+	// It must be called after the runner has finished.
+	receive(stdout string) (map[string]interface{}, error)
+	// close releases anything the transport holds
+	close()
+}
+
+// newTransport returns the transport for opts. Results on stdout are limited
+// by the output limit, socket and binary frames by maxResultBytes.
+func newTransport(opts *RunOptions, maxResultBytes int64) (transport, error) {
+	if opts.Format == "cbor" && (opts.Runtime == "workerd" || opts.Transport == "socket") {
+		return nil, fmt.Errorf("the cbor format is not supported with the workerd runtime or the socket transport")
+	}
+	if opts.Compress && (opts.Format == "cbor" || opts.Transport == "socket" || opts.PersistPerVU || opts.Runtime == "workerd") {
+		return nil, fmt.Errorf("compress is not supported with the cbor format, the socket transport, persistPerVU or the workerd runtime")
+	}
+
+	if opts.Protocol == "binary" && (opts.Transport == "socket" || opts.Compress || opts.PersistPerVU || opts.Threads > 0 || opts.Runtime == "workerd") {
+		return nil, fmt.Errorf("the binary protocol is not supported with the socket transport, compress, persistPerVU, threads or the workerd runtime")
+	}
+
+	switch {
+	case opts.Transport == "socket":
+		if opts.Runtime == "workerd" {
+			return nil, fmt.Errorf("the socket transport is not supported by the workerd runtime")
+		}
+		return newSocketTransport(maxResultBytes)
+	case opts.Compress:
+		return &compressedTransport{}, nil
+	case opts.Protocol == "binary":
+		return &binaryTransport{cbor: opts.Format == "cbor", maxBytes: maxResultBytes}, nil
+	default:
+		return &stdoutTransport{cbor: opts.Format == "cbor"}, nil
+	}
+}
+
+// stdoutTransport is the default transport: the payload is passed as an
+// argument and the result is printed between markers on stdout, as JSON or
+// as base64 CBOR
+type stdoutTransport struct {
+	cbor bool
+}
+
+func (t *stdoutTransport) send(payload interface{}, execContext map[string]interface{}) ([]byte, error) {
+	if !t.cbor {
+		return marshalPayload(payload)
+	}
+	execContext["format"] = "cbor"
+	encoded, err := encodeCBORPayload(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	return encoded, nil
+}
+
+func (t *stdoutTransport) receive(stdout string) (map[string]interface{}, error) {
+	if t.cbor {
+		return extractCBORResult(stdout)
+	}
+	return extractResult(stdout)
+}
+
+func (t *stdoutTransport) close() {}
+
+// compressedTransport passes the path of a gzipped payload file as the
+// argument, and reads the result from stdout as base64 gzipped JSON
+type compressedTransport struct {
+	path string
+}
+
+func (t *compressedTransport) send(payload interface{}, execContext map[string]interface{}) ([]byte, error) {
+	payloadBytes, err := marshalPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	path, err := writeCompressedPayload(payloadBytes)
+	if err != nil {
+		return nil, err
+	}
+	t.path = path
+	execContext["compress"] = true
+	return []byte(path), nil
+}
+
+func (t *compressedTransport) receive(stdout string) (map[string]interface{}, error) {
+	return extractCompressedResult(stdout)
+}
+
+func (t *compressedTransport) close() {
+	if t.path != "" {
+		os.Remove(t.path)
+	}
+}
+
+// marshalPayload encodes payload as the JSON argument of the runner
+func marshalPayload(payload interface{}) ([]byte, error) {
+	payloadBytes, err := marshalJSON(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	return payloadBytes, nil
+}
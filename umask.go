@@ -0,0 +1,52 @@
+package js
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// umaskWarnOnce limits the warning about umask on Windows to one per test
+var umaskWarnOnce sync.Once
+
+// umaskWrapper returns the command prefix that runs the runtime process with
+// the given umask, through sh since Go can't set it for a child process
+// alone. It returns nil on Windows, where umask is ignored, and warn is called
+// once.
+func umaskWrapper(umask uint32, warn func(string, ...interface{})) []string {
+	if runtime.GOOS == "windows" {
+		umaskWarnOnce.Do(func() {
+			warn("umask is not supported on Windows, ignoring it")
+		})
+		return nil
+	}
+	return []string{"sh", "-c", fmt.Sprintf(`umask %03o && exec "$@"`, umask), "sh"}
+}
+
+// parseUmask reads the umask option, either an octal string like "077" or a
+// number like 0o077
+func parseUmask(value interface{}) (uint32, error) {
+	var umask int64
+	switch v := value.(type) {
+	case string:
+		parsed, err := strconv.ParseInt(v, 8, 64)
+		if err != nil {
+			return 0, fmt.Errorf("umask must be an octal string like \"077\", got %q", v)
+		}
+		umask = parsed
+	case int64:
+		umask = v
+	case float64:
+		if v != float64(int64(v)) {
+			return 0, fmt.Errorf("umask must be a whole number, got %v", v)
+		}
+		umask = int64(v)
+	default:
+		return 0, fmt.Errorf("umask must be an octal string or a number, got %T", value)
+	}
+	if umask < 0 || umask > 0o777 {
+		return 0, fmt.Errorf("umask must be between 000 and 777, got %o", umask)
+	}
+	return uint32(umask), nil
+}
@@ -0,0 +1,108 @@
+package js
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// versionRegex matches the first dotted version number in `<runtime> --version` output
+var versionRegex = regexp.MustCompile(`(\d+)(?:\.(\d+))?(?:\.(\d+))?`)
+
+// versionCache detects installed runtime versions once per test run
+type versionCache struct {
+	mu       sync.Mutex
+	versions map[string]string
+	// errs remembers runtimes that couldn't be detected, e.g. not installed
+	errs map[string]error
+}
+
+// get returns the installed version of runtime, e.g. "20.11.0" for node
+func (c *versionCache) get(runtime string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if version, ok := c.versions[runtime]; ok {
+		return version, nil
+	}
+	if err, ok := c.errs[runtime]; ok {
+		return "", err
+	}
+
+	version, err := detectVersion(runtime)
+	if err != nil {
+		if c.errs == nil {
+			c.errs = make(map[string]error)
+		}
+		c.errs[runtime] = err
+		return "", err
+	}
+
+	if c.versions == nil {
+		c.versions = make(map[string]string)
+	}
+	c.versions[runtime] = version
+	return version, nil
+}
+
+// available reports whether runtime is installed
+func (c *versionCache) available(runtime string) bool {
+	_, err := c.get(runtime)
+	return err == nil
+}
+
+// detectVersion runs `<runtime> --version` and extracts the version number
+func detectVersion(runtime string) (string, error) {
+	out, err := exec.Command(runtime, "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to detect %s version: %w", runtime, err)
+	}
+
+	version := versionRegex.FindString(strings.TrimSpace(string(out)))
+	if version == "" {
+		return "", fmt.Errorf("failed to detect %s version from output %q", runtime, strings.TrimSpace(string(out)))
+	}
+	return version, nil
+}
+
+// require returns an error if the installed runtime is older than minVersion
+func (c *versionCache) require(runtime, minVersion string) error {
+	installed, err := c.get(runtime)
+	if err != nil {
+		return err
+	}
+
+	if compareVersions(installed, minVersion) < 0 {
+		return fmt.Errorf("%s %s is installed but the flow requires %s >= %s", runtime, installed, runtime, minVersion)
+	}
+	return nil
+}
+
+// compareVersions compares dotted versions numerically, treating missing
+// components as 0. It returns -1, 0 or 1.
+func compareVersions(a, b string) int {
+	pa := parseVersion(a)
+	pb := parseVersion(b)
+	for i := range pa {
+		if pa[i] != pb[i] {
+			if pa[i] < pb[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// parseVersion returns the major, minor and patch components of version
+func parseVersion(version string) [3]int {
+	var parts [3]int
+	matches := versionRegex.FindStringSubmatch(version)
+	for i := 1; i < len(matches) && i <= 3; i++ {
+		parts[i-1], _ = strconv.Atoi(matches[i])
+	}
+	return parts
+}
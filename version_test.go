@@ -0,0 +1,67 @@
+package js
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{a: "20.11.0", b: "18", want: 1},
+		{a: "18.19.1", b: "20.0.0", want: -1},
+		{a: "20", b: "20.0.0", want: 0},
+		{a: "1.10.0", b: "1.9.9", want: 1},
+		{a: "1.2", b: "1.2.1", want: -1},
+		{a: "v20.11.1", b: "20.11.1", want: 0},
+		{a: "2.0.0-rc.1", b: "2.0.0", want: 0},
+		{a: "deno 2.1.4 (stable, release, x86_64-unknown-linux-gnu)", b: "2.1.4", want: 0},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := compareVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    [3]int
+	}{
+		{version: "v20.11.0", want: [3]int{20, 11, 0}},
+		{version: "1.1", want: [3]int{1, 1, 0}},
+		{version: "7", want: [3]int{7, 0, 0}},
+		{version: "deno 2.1.4\nv8 12.9.202.13\ntypescript 5.6.2", want: [3]int{2, 1, 4}},
+		{version: "unknown", want: [3]int{0, 0, 0}},
+	}
+	for _, tt := range tests {
+		if got := parseVersion(tt.version); got != tt.want {
+			t.Errorf("parseVersion(%q) = %v, want %v", tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestVersionCacheRequire(t *testing.T) {
+	cache := &versionCache{versions: map[string]string{"node": "18.19.0"}}
+
+	if err := cache.require("node", "18.2"); err != nil {
+		t.Errorf("18.19.0 should satisfy 18.2: %v", err)
+	}
+	err := cache.require("node", "20")
+	if err == nil || !strings.Contains(err.Error(), "node 18.19.0 is installed but the flow requires node >= 20") {
+		t.Errorf("expected a version error, got %v", err)
+	}
+
+	if err := cache.require("no-such-runtime", "1"); err == nil {
+		t.Error("expected an error for a runtime that isn't installed")
+	}
+	if _, ok := cache.errs["no-such-runtime"]; !ok {
+		t.Error("the failed detection wasn't cached")
+	}
+}
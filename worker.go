@@ -0,0 +1,473 @@
+package js
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"go.k6.io/k6/metrics"
+)
+
+const (
+	// workerStartTimeout bounds how long a worker may take to become ready
+	workerStartTimeout = 30 * time.Second
+	// workerStderrTimeout bounds how long to wait for a job's stderr to be flushed
+	workerStderrTimeout = 5 * time.Second
+)
+
+// workerJob is a single flow invocation sent to a worker as one JSON line
+type workerJob struct {
+	Entry   string            `json:"entry"`
+	Payload json.RawMessage   `json:"payload"`
+	Context json.RawMessage   `json:"context"`
+	Env     map[string]string `json:"env,omitempty"`
+}
+
+// worker is a long-lived runtime process that runs jobs one at a time, so
+// modules imported by flows stay cached between calls.
+type worker struct {
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	lines    chan string
+	errLines chan string
+	dead     bool
+
+	// entryModTimes tracks entry mtimes for watch mode
+	entryModTimes map[string]time.Time
+}
+
+var (
+	runnerFileOnce sync.Once
+	runnerFilePath string
+	runnerFileErr  error
+)
+
+// runnerFile writes the runner script to a temp file once. Deno workers can't
+// read the script from stdin since stdin carries the jobs.
+func runnerFile() (string, error) {
+	runnerFileOnce.Do(func() {
+		f, err := os.CreateTemp("", "xk6-external-js-runner-*.js")
+		if err != nil {
+			runnerFileErr = fmt.Errorf("failed to create runner file: %w", err)
+			return
+		}
+		defer f.Close()
+		if _, err := f.WriteString(runnerScript); err != nil {
+			runnerFileErr = fmt.Errorf("failed to write runner file: %w", err)
+			return
+		}
+		runnerFilePath = f.Name()
+	})
+	return runnerFilePath, runnerFileErr
+}
+
+// startWorker spawns a runtime in worker mode and waits until it is ready
+func startWorker(runtime string, env []string, wrapper []string) (*worker, error) {
+	var cmd *exec.Cmd
+	switch runtime {
+	case "node":
+		cmd = exec.Command("node", "-e", runnerScript, "__worker__")
+	case "deno":
+		path, err := runnerFile()
+		if err != nil {
+			return nil, err
+		}
+		cmd = exec.Command("deno", "run", "--allow-all", path, "__worker__")
+		if wd, err := os.Getwd(); err == nil {
+			cmd.Dir = wd
+		}
+	case "bun":
+		cmd = exec.Command("bun", "-e", runnerScript, "__worker__")
+	default:
+		return nil, fmt.Errorf("persistent workers are not supported by the %s runtime", runtime)
+	}
+	return launchWorker(runtime, cmd, env, wrapper)
+}
+
+// launchWorker starts cmd, a runner in a long-lived mode, and waits until it
+// prints __WORKER_READY__
+func launchWorker(runtime string, cmd *exec.Cmd, env []string, wrapper []string) (*worker, error) {
+	if len(wrapper) > 0 {
+		cmd = wrapCommand(context.Background(), wrapper, cmd)
+	}
+	cmd.Env = env
+
+	w := &worker{
+		cmd:           cmd,
+		lines:         make(chan string, 64),
+		errLines:      make(chan string, 64),
+		entryModTimes: make(map[string]time.Time),
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open worker stdin: %w", err)
+	}
+	w.stdin = stdin
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open worker stdout: %w", err)
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open worker stderr: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s worker: %w", runtime, err)
+	}
+
+	go scanLines(stdout, w.lines)
+	go scanLines(stderr, w.errLines)
+
+	timeout := time.After(workerStartTimeout)
+	for {
+		select {
+		case line, ok := <-w.lines:
+			if !ok {
+				var output bytes.Buffer
+				drainLines(w.errLines, &output, false)
+				w.kill()
+				return nil, fmt.Errorf("%s worker exited before becoming ready\nOutput: %s", runtime, output.String())
+			}
+			if line == "__WORKER_READY__" {
+				return w, nil
+			}
+		case <-w.errLines:
+			// Nothing is running yet, startup noise is dropped
+		case <-timeout:
+			w.kill()
+			return nil, fmt.Errorf("%s worker did not become ready within %s", runtime, workerStartTimeout)
+		}
+	}
+}
+
+// run sends job to the worker and copies the job's output to stdout and
+// stderr. The worker is killed if ctx is done before the job finishes.
+//
+// After each job the runner prints __JOB_DONE__ to stderr, so stderr written
+// by the job can be attributed to it even though it arrives on its own pipe.
+func (w *worker) run(ctx context.Context, job workerJob, stdout, stderr io.Writer) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	line, err := marshalJSON(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job: %w", err)
+	}
+	if _, err := w.stdin.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("worker is not accepting jobs: %w", err)
+	}
+	return readJob(ctx, w.lines, w.errLines, stdout, stderr, w.kill)
+}
+
+// readJob copies the output of a job from lines and errLines to stdout and
+// stderr until the job finishes. If ctx is done or lines is closed first,
+// stop is called before the stderr left is drained.
+func readJob(ctx context.Context, lines, errLines <-chan string, stdout, stderr io.Writer, stop func()) error {
+	// stderr is consumed while waiting so a chatty job can't fill the pipe
+	pendingErrLines := errLines
+	stderrDone := false
+	for {
+		select {
+		case <-ctx.Done():
+			stop()
+			drainLines(errLines, stderr, false)
+			return ctx.Err()
+		case errLine, ok := <-pendingErrLines:
+			switch {
+			case !ok:
+				pendingErrLines = nil
+			case errLine == "__JOB_DONE__":
+				stderrDone = true
+				pendingErrLines = nil
+			default:
+				_, _ = io.WriteString(stderr, errLine+"\n")
+			}
+		case line, ok := <-lines:
+			if !ok {
+				stop()
+				drainLines(errLines, stderr, false)
+				return fmt.Errorf("worker exited unexpectedly")
+			}
+
+			if msg, found := strings.CutPrefix(line, "__FLOW_ERROR__ "); found {
+				var stack string
+				if err := json.Unmarshal([]byte(msg), &stack); err != nil {
+					stack = msg
+				}
+				drainLines(errLines, stderr, !stderrDone)
+				_, _ = io.WriteString(stderr, stack+"\n")
+				return fmt.Errorf("flow failed")
+			}
+
+			_, _ = io.WriteString(stdout, line+"\n")
+			if line == "__RESULT_END__" {
+				drainLines(errLines, stderr, !stderrDone)
+				return nil
+			}
+		}
+	}
+}
+
+// drainLines copies pending stderr lines to out. When untilDone is set it
+// waits for the __JOB_DONE__ sentinel, otherwise it stops once no line is
+// immediately available.
+func drainLines(errLines <-chan string, out io.Writer, untilDone bool) {
+	timeout := time.After(workerStderrTimeout)
+	for {
+		if !untilDone {
+			select {
+			case line, ok := <-errLines:
+				if !ok {
+					return
+				}
+				_, _ = io.WriteString(out, line+"\n")
+				continue
+			default:
+				return
+			}
+		}
+
+		select {
+		case line, ok := <-errLines:
+			if !ok || line == "__JOB_DONE__" {
+				return
+			}
+			_, _ = io.WriteString(out, line+"\n")
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// scanLines sends each line read from r to lines, closing it at EOF
+func scanLines(r io.Reader, lines chan<- string) {
+	defer close(lines)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 256<<20)
+	for scanner.Scan() {
+		lines <- scanner.Text()
+	}
+}
+
+// runInWorker runs job on the VU's worker for opts.Runtime
+func (j *ExternalJS) runInWorker(ctx context.Context, opts *RunOptions, env []string, job workerJob, stdout, stderr io.Writer) error {
+	w, err := j.workerFor(opts, env)
+	if err != nil {
+		return err
+	}
+	return w.run(ctx, job, stdout, stderr)
+}
+
+// workerFor returns the VU's worker for opts.Runtime, spawning it on first
+// use or after it died. Spawn times are recorded so the benefit of keeping
+// modules cached can be compared with one-shot runs.
+func (j *ExternalJS) workerFor(opts *RunOptions, env []string) (*worker, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	w := j.workers[opts.Runtime]
+
+	if w != nil && opts.Watch && os.Getenv("XK6_EXTERNAL_JS_WATCH") == "true" && w.entryChanged(opts.entryPath) {
+		// Async calls may still be running a job on it
+		w.terminate()
+		j.module.pool.remove(w)
+		w = nil
+	}
+
+	if w == nil || !w.alive() {
+		if w != nil {
+			j.module.pool.remove(w)
+		}
+		start := time.Now()
+		var err error
+		w, err = startWorker(opts.Runtime, env, opts.CommandWrapper)
+		if err != nil {
+			delete(j.workers, opts.Runtime)
+			return nil, err
+		}
+		j.workers[opts.Runtime] = w
+		j.module.pool.add(w)
+		// Seed the mtime so the first change after spawning is detected
+		w.entryChanged(opts.entryPath)
+
+		if state := j.metricsState(); state != nil {
+			j.pushSample(state, metrics.Sample{
+				TimeSeries: metrics.TimeSeries{
+					Metric: j.workerSpawnDuration,
+					Tags:   state.Tags.GetCurrentValues().Tags.WithTagsFromMap(callTags(opts, map[string]string{"runtime": opts.runtimeTag})),
+				},
+				Time:  time.Now(),
+				Value: float64(time.Since(start).Milliseconds()),
+			})
+		}
+	}
+
+	return w, nil
+}
+
+// entryChanged reports whether entry was modified since the worker last ran it
+func (w *worker) entryChanged(entry string) bool {
+	info, err := os.Stat(entry)
+	if err != nil {
+		return false
+	}
+
+	last, seen := w.entryModTimes[entry]
+	w.entryModTimes[entry] = info.ModTime()
+	return seen && !info.ModTime().Equal(last)
+}
+
+// alive reports whether the worker can still accept jobs
+func (w *worker) alive() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return !w.dead
+}
+
+// terminate kills the worker once the job it's running, if any, is done
+func (w *worker) terminate() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.kill()
+}
+
+// kill terminates the worker process. The caller must hold w.mu or be the
+// only one with access to the worker.
+func (w *worker) kill() {
+	if w.dead {
+		return
+	}
+	w.dead = true
+
+	if w.cmd.Process != nil {
+		_ = w.cmd.Process.Kill()
+	}
+	// Unblock the output readers so they can exit
+	go func() {
+		for range w.lines {
+		}
+	}()
+	go func() {
+		for range w.errLines {
+		}
+	}()
+	_ = w.cmd.Wait()
+}
+
+// workerShutdownGrace is how long workers get to exit on their own at test end
+const workerShutdownGrace = 5 * time.Second
+
+// k6ExitEvent is k6's event.Exit type. The event package is internal to k6, so
+// the untyped constant is passed to Subscribe instead.
+const k6ExitEvent = 6
+
+// workerRegistry tracks the workers of every VU so they can be shut down
+// together once the test is over.
+type workerRegistry struct {
+	mu      sync.Mutex
+	workers map[*worker]struct{}
+}
+
+// add starts tracking w
+func (r *workerRegistry) add(w *worker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.workers == nil {
+		r.workers = make(map[*worker]struct{})
+	}
+	r.workers[w] = struct{}{}
+}
+
+// remove stops tracking w
+func (r *workerRegistry) remove(w *worker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.workers, w)
+}
+
+// shutdown asks every live worker to exit by closing its stdin, killing those
+// still running after grace. It returns how many exited on their own and how
+// many had to be killed.
+func (r *workerRegistry) shutdown(grace time.Duration) (stopped, killed int) {
+	r.mu.Lock()
+	workers := make([]*worker, 0, len(r.workers))
+	for w := range r.workers {
+		workers = append(workers, w)
+	}
+	r.workers = nil
+	r.mu.Unlock()
+
+	var (
+		wg      sync.WaitGroup
+		countMu sync.Mutex
+	)
+	for _, w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			exited, forced := w.stop(grace)
+			countMu.Lock()
+			defer countMu.Unlock()
+			if forced {
+				killed++
+			} else if exited {
+				stopped++
+			}
+		}()
+	}
+	wg.Wait()
+	return stopped, killed
+}
+
+// stop closes the worker's stdin, which makes the runner exit once its current
+// job is done, and kills it if it hasn't exited after grace. It reports whether
+// the worker was running and whether it had to be killed.
+func (w *worker) stop(grace time.Duration) (exited, forced bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.dead {
+		return false, false
+	}
+	_ = w.stdin.Close()
+
+	timeout := time.After(grace)
+	lines, errLines := w.lines, w.errLines
+	for lines != nil {
+		select {
+		case _, ok := <-lines:
+			if !ok {
+				lines = nil
+			}
+		case _, ok := <-errLines:
+			if !ok {
+				errLines = nil
+			}
+		case <-timeout:
+			w.kill()
+			return true, true
+		}
+	}
+
+	w.dead = true
+	go func() {
+		for range w.errLines {
+		}
+	}()
+	_ = w.cmd.Wait()
+	return true, false
+}
@@ -0,0 +1,70 @@
+package js
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWorkerSeedEnvPerJob(t *testing.T) {
+	j, state, _ := newTestInstance(t)
+	flow := writeFlow(t, `module.exports = async (ctx) => ({ seed: ctx.env.SEED });`)
+
+	seeds := make(map[string]bool)
+	for iteration := int64(0); iteration < 2; iteration++ {
+		state.Iteration = iteration
+		result, err := j.Run(flow, map[string]interface{}{"payload": nil, "persistPerVU": true, "seedEnv": "SEED"})
+		if err != nil {
+			t.Fatalf("iteration %d: %v", iteration, err)
+		}
+		seed, _ := result["seed"].(string)
+		if want := strconv.FormatUint(uint64(iterationSeed(state.VUID, iteration)), 10); seed != want {
+			t.Errorf("iteration %d: SEED is %q, want %s", iteration, seed, want)
+		}
+		seeds[seed] = true
+	}
+	if len(seeds) != 2 {
+		t.Errorf("both iterations saw the same SEED: %v", seeds)
+	}
+}
+
+func TestWorkerWatchWaitsForRunningJob(t *testing.T) {
+	t.Setenv("XK6_EXTERNAL_JS_WATCH", "true")
+	j, _, _ := newTestInstance(t)
+	flow := writeFlow(t, `module.exports = async (ctx) => {
+  await new Promise((resolve) => setTimeout(resolve, ctx.payload.ms));
+  return { pid: process.pid };
+};`)
+	run := func(ms int64) (map[string]interface{}, error) {
+		return j.Run(flow, map[string]interface{}{"payload": map[string]interface{}{"ms": ms}, "persistPerVU": true, "watch": true})
+	}
+
+	first, err := run(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A slow job is running on the worker when the entry changes
+	slow := make(chan error, 1)
+	go func() {
+		_, err := run(500)
+		slow <- err
+	}()
+	time.Sleep(200 * time.Millisecond)
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(flow, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := run(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-slow; err != nil {
+		t.Errorf("the running job failed when the worker was recycled: %v", err)
+	}
+	if next["pid"] == first["pid"] {
+		t.Error("the worker wasn't recycled after the entry changed")
+	}
+}
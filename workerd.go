@@ -0,0 +1,79 @@
+package js
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+//go:embed workerd_runner.js
+var workerdRunnerScript string
+
+// workerdConfig is the workerd config used to run a single flow. The entry,
+// runner and input are embedded as modules of one worker and executed via
+// its test() handler.
+const workerdConfig = `using Workerd = import "/workerd/workerd.capnp";
+
+const config :Workerd.Config = (
+  services = [ (name = "main", worker = .flowWorker) ],
+);
+
+const flowWorker :Workerd.Worker = (
+  modules = [
+    (name = "runner.js", esModule = embed "runner.js"),
+    (name = "flow.js", esModule = embed "flow.js"),
+    (name = "input.json", json = embed "input.json"),
+  ],
+  compatibilityDate = "2024-09-23",
+  compatibilityFlags = ["nodejs_compat"],
+);
+`
+
+// workerdCommand builds a `workerd test` invocation for entry in a temp
+// directory. Workers can't read the host environment or filesystem, so the
+// payload, execution context and env are passed in input.json and the entry
+// must be self-contained (bundle it first if it has imports).
+//
+// The returned cleanup function removes the temp directory.
+func workerdCommand(ctx context.Context, entry string, payload, execContext []byte, env map[string]string) (*exec.Cmd, func(), error) {
+	flowSource, err := os.ReadFile(entry)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read worker flow: %w", err)
+	}
+
+	input, err := marshalJSON(map[string]interface{}{
+		"payload": json.RawMessage(payload),
+		"context": json.RawMessage(execContext),
+		"env":     env,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal worker input: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "xk6-external-js-workerd-*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create workerd directory: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	files := map[string][]byte{
+		"config.capnp": []byte(workerdConfig),
+		"runner.js":    []byte(workerdRunnerScript),
+		"flow.js":      flowSource,
+		"input.json":   input,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0o600); err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("failed to write workerd %s: %w", name, err)
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, "workerd", "test", "config.capnp")
+	cmd.Dir = dir
+	return cmd, cleanup, nil
+}
@@ -0,0 +1,164 @@
+// Runner for the workerd (Cloudflare Workers) runtime. workerd has no argv or
+// filesystem, so the entry is embedded as "flow.js" and the payload and
+// execution context as "input.json" in a generated config.
+import * as flowModule from "flow.js";
+import input from "input.json";
+
+function createCollectors() {
+  const collected = { metrics: [], checks: [] };
+  const record = (type, name, many) => (value, tags = {}, time) => {
+    const entry = many ? { type, name, values: Array.from(value), tags } : { type, name, value, tags };
+    if (time !== undefined) {
+      entry.time = time instanceof Date ? time.getTime() : time;
+    }
+    collected.metrics.push(entry);
+  };
+
+  const metrics = {
+    counter: (name) => ({ add: (value = 1, tags = {}, time) => record("counter", name)(value, tags, time) }),
+    gauge: (name) => ({ set: record("gauge", name) }),
+    trend: (name) => ({ add: record("trend", name), addAll: record("trend", name, true) }),
+    rate: (name) => ({ add: record("rate", name) }),
+  };
+  const checks = {
+    check(name, condition) {
+      collected.checks.push({ name, ok: Boolean(condition) });
+    },
+  };
+
+  return { collected, metrics, checks };
+}
+
+// deadlineSignal aborts ahead of deadline, like the runner for the other
+// runtimes does
+function deadlineSignal(deadline) {
+  if (!deadline) {
+    return new AbortController().signal;
+  }
+  const remaining = deadline - Date.now();
+  return AbortSignal.timeout(Math.max(0, Math.round(remaining - Math.min(1000, remaining / 10))));
+}
+
+// stringifyResult encodes a result as JSON with BigInt values as plain
+// numbers, like the runner for the other runtimes does
+function stringifyResult(value) {
+  // The marker is random so strings in the result won't be mistaken for it
+  const marker = "bigint" + Math.random().toString(36).slice(2);
+  let bigints = false;
+  const json = JSON.stringify(value, (key, item) => {
+    if (typeof item !== "bigint") return item;
+    bigints = true;
+    return "\u0000" + marker + ":" + item;
+  });
+  return bigints ? json.replace(new RegExp(`"\\\\u0000${marker}:(-?\\d+)"`, "g"), "$1") : json;
+}
+
+// decodeMultipart replaces { __multipart__: [...] } objects with a FormData,
+// like the runner for the other runtimes does
+function decodeMultipart(value) {
+  if (Array.isArray(value)) {
+    return value.map(decodeMultipart);
+  }
+  if (!value || typeof value !== "object") {
+    return value;
+  }
+  if (Array.isArray(value.__multipart__)) {
+    const form = new FormData();
+    for (const part of value.__multipart__) {
+      let data = part.data;
+      if (part.encoding === "base64") {
+        data = Uint8Array.from(atob(part.data), (c) => c.charCodeAt(0));
+      }
+      if (part.filename === undefined && part.contentType === undefined && typeof data === "string") {
+        form.append(part.name, data);
+        continue;
+      }
+      const type = part.contentType || (typeof data === "string" ? "text/plain" : "application/octet-stream");
+      const blob = new Blob([data], { type });
+      if (part.filename !== undefined) {
+        form.append(part.name, blob, part.filename);
+      } else {
+        form.append(part.name, blob);
+      }
+    }
+    return form;
+  }
+  for (const key of Object.keys(value)) {
+    value[key] = decodeMultipart(value[key]);
+  }
+  return value;
+}
+
+export default {
+  async test() {
+    const { collected, metrics, checks } = createCollectors();
+    globalThis.metrics = metrics;
+    globalThis.checks = checks;
+
+    const executionContext = input.context || {};
+    if (executionContext.ready) {
+      // Starts the flowTimeout clock
+      console.log("__READY__ " + performance.now());
+    }
+    const ctx = {
+      payload: decodeMultipart(input.payload),
+      meta: executionContext.meta || {},
+      setupData: executionContext.setupData,
+      log: Object.fromEntries(["debug", "info", "warn", "error"].map((level) => [
+        level,
+        (msg, fields) => console.log("__LOG__ " + JSON.stringify({ level, msg: String(msg), fields: fields || {} })),
+      ])),
+      env: input.env || {},
+      vu: executionContext.vu || { id: 0, iteration: 0, scenario: "" },
+      seed: executionContext.seed,
+      // now is the same for every flow of the iteration, in epoch milliseconds
+      now: executionContext.now ?? Date.now(),
+      // correlationId identifies this call, see the correlationId option
+      correlationId: executionContext.correlationId,
+      // deadline is when the call times out, in epoch milliseconds
+      deadline: executionContext.deadline,
+      signal: deadlineSignal(executionContext.deadline),
+      execution: executionContext,
+    };
+
+    let result;
+    if (executionContext.fn) {
+      if (typeof flowModule[executionContext.fn] !== "function") {
+        throw new Error(`Expected export "${executionContext.fn}" to be a function in the worker flow.`);
+      }
+      const { args, kwargs } = executionContext;
+      if (args || kwargs) {
+        // Same as invokeFlow in the runner for the other runtimes
+        result = await flowModule[executionContext.fn](...(args || []), ...(kwargs ? [kwargs] : []));
+      } else {
+        result = await flowModule[executionContext.fn](ctx);
+      }
+      result = result && typeof result === "object" && !Array.isArray(result) ? result : { value: result };
+    } else if (typeof flowModule.handler === "function") {
+      result = await flowModule.handler(ctx);
+      result = result && typeof result === "object" ? result : {};
+    } else if (typeof flowModule.default === "function") {
+      result = await flowModule.default(ctx);
+    } else {
+      throw new Error("Expected a handler export or a default function export in the worker flow.");
+    }
+
+    // Whichever export ran, add what it recorded with metrics and checks, like
+    // createMetricsAndChecksWrapper in the runner for the other runtimes
+    if (collected.metrics.length > 0 || collected.checks.length > 0) {
+      if (!result || typeof result !== "object" || Array.isArray(result)) {
+        result = { value: result };
+      }
+      if (collected.metrics.length > 0) {
+        result.__k6_metrics__ = collected.metrics;
+      }
+      if (collected.checks.length > 0) {
+        result.__k6_checks__ = collected.checks;
+      }
+    }
+
+    console.log("__RESULT_START__");
+    console.log(stringifyResult(result || {}));
+    console.log("__RESULT_END__");
+  },
+};